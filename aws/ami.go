@@ -2,7 +2,10 @@ package aws
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"maps"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
@@ -17,18 +20,10 @@ import (
 
 var (
 	ConfigManager *ConfigurationManager
-	ec2Services   = make(map[string]map[string]*ec2.Client)
 )
 
-func getEC2ServiceForAccountAndRegion(account string, region string) *ec2.Client {
-	if ec2Services[account] == nil {
-		ec2Services[account] = make(map[string]*ec2.Client)
-	}
-
-	if ec2Services[account][region] == nil {
-		ec2Services[account][region] = ec2.NewFromConfig(ConfigManager.getConfigurationForAccountAndRegion(account, region))
-	}
-	return ec2Services[account][region]
+func getEC2ServiceForAccountAndRegion(account string, region string) EC2API {
+	return ConfigManager.getEC2Client(account, region)
 }
 
 type Ami struct {
@@ -39,6 +34,15 @@ type Ami struct {
 	AWSImage      *ec2Types.Image
 
 	AmisPerRegion map[string]*Ami
+
+	// Journal records the steps of the operation in a state file, when set.
+	Journal *Journal
+
+	// description is set on a regional copy when it is copied
+	description string
+	// owner is the target account that owns a copy made with CopyModeOwnedCopy. It is empty for
+	// the AMIs of the default account.
+	owner string
 }
 
 // NewAmi creates a new AMI instance with the provided source AMI ID.
@@ -59,6 +63,14 @@ func NewAmiWithRegions(sourceAmiID string, sourceRegion string, regions []string
 	return ami
 }
 
+// ownerAccount returns the account that owns the AMI.
+func (ami *Ami) ownerAccount() string {
+	if ami.owner != "" {
+		return ami.owner
+	}
+	return *ConfigManager.defaultAccountID
+}
+
 // formatTags converts a pointer to a slice of EC2 tags into a readable comma-separated string.
 // It safely handles nil pointers and nil key/value pointers within each tag.
 func formatTags(tags *[]ec2Types.Tag) string {
@@ -86,9 +98,9 @@ func formatTags(tags *[]ec2Types.Tag) string {
 	return strings.Join(parts, ", ")
 }
 
-func (ami *Ami) fetchMetadata() error {
+func (ami *Ami) fetchMetadata(ctx context.Context) error {
 	log.Debug("Fetching metadata about the AMI")
-	ec2svc := getEC2ServiceForAccountAndRegion(*ConfigManager.defaultAccountID, ami.SourceRegion)
+	ec2svc := getEC2ServiceForAccountAndRegion(ami.ownerAccount(), ami.SourceRegion)
 
 	var amiList []string
 	amiList = append(amiList, ami.SourceAmiID)
@@ -96,7 +108,7 @@ func (ami *Ami) fetchMetadata() error {
 	describeImagesInput := ec2.DescribeImagesInput{
 		ImageIds: amiList,
 	}
-	result, err := ec2svc.DescribeImages(context.Background(), &describeImagesInput)
+	result, err := ec2svc.DescribeImages(ctx, &describeImagesInput)
 
 	if err != nil {
 		return err
@@ -127,130 +139,457 @@ func (ami *Ami) fetchMetadata() error {
 
 // Copy copies the AMI to the specified regions and sets launch permissions for the configured accounts.
 // It fetches source AMI metadata, copies to each region concurrently, and applies tags and permissions.
-func (ami *Ami) Copy() {
+// Failures in one region do not stop the others; they are reported per region in the returned result.
+func (ami *Ami) Copy(ctx context.Context, opts CopyOptions) (*CopyResult, error) {
+	if err := opts.Validate(ami.SourceRegion, ami.targetRegions()); err != nil {
+		return nil, err
+	}
+	// the resumed run may have deregistered failed copies without deleting their snapshots
+	ami.finishSnapshotDeletions(ctx)
+
 	// Fetch name and tags for the source AMI
-	err := ami.fetchMetadata()
+	err := ami.fetchMetadata(ctx)
 
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	var wg sync.WaitGroup
+	if err := ami.nameCopies(opts, time.Now()); err != nil {
+		return nil, err
+	}
 
-	// in this loop region is the key
-	for region := range ami.AmisPerRegion {
-		log.Debugf("Region is %s", region)
+	if opts.ShareSnapshots {
+		warnSnapshotsNotSharedWithOrganizations(opts.OrganizationTargets)
+	}
 
-		wg.Add(1)
-		go func(amiF *Ami, region string) {
-			var (
-				relatedAmi *Ami
-				err        error
-			)
+	if opts.Mode == CopyModeOwnedCopy {
+		return ami.copyOwned(ctx, opts)
+	}
 
-			// We obviously don't have to copy the AMI to a region where it already exists
-			if region != amiF.SourceRegion {
-				log.Debug("Starting copying")
+	if _, ok := ami.AmisPerRegion[ami.SourceRegion]; ok && opts.Encrypted && !isEncrypted(ami.AWSImage) {
+		log.Warnf("Source AMI %s in %s is not encrypted and is not copied, so it stays unencrypted in that region", ami.SourceAmiID, ami.SourceRegion)
+	}
 
-				relatedAmi, err = amiF.copyToRegion(region)
+	return ami.copyAll(ctx, opts), nil
+}
 
-				if err != nil {
-					log.Fatal(err)
-				}
+// copyOwned shares the source AMI with the target accounts and has every one of them copy it to
+// every region, see CopyModeOwnedCopy.
+func (ami *Ami) copyOwned(ctx context.Context, opts CopyOptions) (*CopyResult, error) {
+	accounts := ownedCopyAccounts()
+	if len(accounts) == 0 {
+		return nil, errors.New("owned copies need at least one target account other than the default account")
+	}
 
-				err = relatedAmi.setOwners(ConfigManager.accounts)
+	keyAccess, err := ami.shareSource(ctx, accounts, opts)
+	if err != nil {
+		return nil, err
+	}
+	result := ami.copyAll(ctx, opts)
+	result.SourceKeyAccess = keyAccess
+	return result, nil
+}
 
-				if err != nil {
-					log.Fatal(err)
-				}
-			} else {
-				relatedAmi = amiF
-			}
+// copyAll copies the AMI to every region concurrently and shares and tags the copies. With
+// opts.MaxConcurrency, only that many regions are handled at the same time.
+func (ami *Ami) copyAll(ctx context.Context, opts CopyOptions) *CopyResult {
+	result := newCopyResult(ami)
 
-			for _, account := range ConfigManager.getAccounts() {
-				// the original AMI already has the tags
-				if account != *ConfigManager.defaultAccountID {
-					err := relatedAmi.setTagsForAccount(account, *amiF.SourceAmiTags)
+	var wg sync.WaitGroup
+	var slots chan struct{}
+	if opts.MaxConcurrency > 0 {
+		slots = make(chan struct{}, opts.MaxConcurrency)
+	}
+
+	for _, region := range ami.targetRegions() {
+		log.Debugf("Region is %s", region)
 
-					if err != nil {
-						log.Fatal(err)
-					}
+		wg.Add(1)
+		go func(amiF *Ami, regionResult *RegionCopyResult) {
+			defer wg.Done()
+			if slots != nil {
+				select {
+				case slots <- struct{}{}:
+					defer func() { <-slots }()
+				case <-ctx.Done():
+					regionResult.CopyErr = fmt.Errorf("not started: %w", ctx.Err())
+					return
 				}
 			}
-
-			wg.Done()
-		}(ami, region)
+			amiF.copyAndShareInRegion(ctx, regionResult, opts)
+		}(ami, result.Regions[region])
 	}
 
 	wg.Wait()
+
+	return result
 }
 
-func (ami *Ami) copyToRegion(region string) (*Ami, error) {
-	relatedAmi := ami.AmisPerRegion[region]
+// copyAndShareInRegion copies the AMI to the region of regionResult, grants launch permissions and tags
+// the AMI in every account. Each goroutine only writes to its own regionResult.
+func (ami *Ami) copyAndShareInRegion(ctx context.Context, regionResult *RegionCopyResult, opts CopyOptions) {
+	var relatedAmi *Ami
+	region := regionResult.Region
+	copiedAt := time.Now().UTC()
 
-	log.Infof("Copying AMI to region %s", relatedAmi.SourceRegion)
-	copyImageInput := &ec2.CopyImageInput{
-		Name:          aws.String(ami.SourceAmiName),
-		SourceRegion:  aws.String(ami.SourceRegion),
-		SourceImageId: aws.String(ami.SourceAmiID),
+	if amiID := ami.Journal.completedRegion(region); amiID != "" {
+		log.Infof("Region %s was completed as %s by the resumed run", region, amiID)
+		regionResult.AmiID = amiID
+		regionResult.Resumed = true
+		return
 	}
-	ec2Service := getEC2ServiceForAccountAndRegion(*ConfigManager.defaultAccountID, relatedAmi.SourceRegion)
+	defer ami.recordRegion(regionResult)
+
+	if opts.Mode == CopyModeOwnedCopy {
+		ami.copyToAccounts(ctx, regionResult, opts, copiedAt)
+		return
+	}
+
+	// the accounts that are tagged once the copy is available
+	tagAccounts := []string{*ConfigManager.defaultAccountID}
+
+	// We obviously don't have to copy the AMI to a region where it already exists
+	if region != ami.SourceRegion {
+		log.Debug("Starting copying")
+
+		var taggedAtCopy bool
+		var err error
+		relatedAmi, taggedAtCopy, err = ami.copyOrReuse(ctx, regionResult, opts, copiedAt)
+		if taggedAtCopy {
+			tagAccounts = nil
+		}
+
+		if err != nil {
+			regionResult.AmiID = ami.AmisPerRegion[region].SourceAmiID
+			regionResult.CopyErr = err
+			log.Errorf("Copying AMI to region %s failed: %v", region, err)
+			return
+		}
+		regionResult.AmiID = relatedAmi.SourceAmiID
+		if regionResult.Pending {
+			log.Infof("Not waiting for copy %s in region %s; share and tag it with the wait command once it is available", relatedAmi.SourceAmiID, region)
+			return
+		}
+
+		err = relatedAmi.setOwners(ctx, ConfigManager.accounts, opts.OrganizationTargets)
+		ami.Journal.record(JournalEntry{
+			Step: journalStepPermissions, Region: region, AmiID: relatedAmi.SourceAmiID, Error: errorString(err),
+			Principals: launchPermissionPrincipals(append(createLaunchPermissionsForOwners(ConfigManager.accounts), opts.OrganizationTargets.launchPermissions()...)),
+		})
+
+		if err != nil {
+			regionResult.PermissionErr = err
+			log.Errorf("Setting launch permissions on AMI %s failed: %v", relatedAmi.SourceAmiID, err)
+			// the accounts can't see the AMI, so tagging it for them is bound to fail as well
+			ami.tagInRegion(ctx, regionResult, relatedAmi, opts.TagRules, copiedAt, tagAccounts)
+			return
+		}
+
+		if opts.ShareSnapshots {
+			regionResult.Snapshots = relatedAmi.shareSnapshots(ctx, ConfigManager.accounts)
+			ami.recordSharedSnapshots(regionResult)
+		}
+
+		regionResult.KeyAccess, regionResult.KeyAccessErr = relatedAmi.checkKeyAccess(ctx, ConfigManager.accounts, opts.CreateKmsGrants)
+		if regionResult.KeyAccessErr != nil {
+			log.Warnf("Checking KMS key access for AMI %s failed: %v", relatedAmi.SourceAmiID, regionResult.KeyAccessErr)
+		}
+	} else {
+		relatedAmi = ami
+		regionResult.AmiID = ami.SourceAmiID
+	}
+
+	ami.tagInRegion(ctx, regionResult, relatedAmi, opts.TagRules, copiedAt, append(tagAccounts, ConfigManager.getAccounts()...))
+}
 
-	output, err := ec2Service.CopyImage(context.Background(), copyImageInput)
+// tagInRegion tags the AMI in the region of regionResult as seen by each of the accounts. In the
+// default account, the regional copy and its snapshots are tagged; the source AMI already has its
+// tags.
+func (ami *Ami) tagInRegion(ctx context.Context, regionResult *RegionCopyResult, relatedAmi *Ami, rules TagRules, copiedAt time.Time, accounts []string) {
+	defaultAccount := *ConfigManager.defaultAccountID
 
+	tags, err := rules.Apply(ami.sourceTags(), ami.tagTemplateData(regionResult.Region, relatedAmi.SourceAmiID, copiedAt))
 	if err != nil {
-		log.Debug(err)
-		return nil, err
+		regionResult.TagErrors[defaultAccount] = err
+		log.Errorf("Building the tags for AMI %s failed: %v", relatedAmi.SourceAmiID, err)
+		return
+	}
+	if len(tags) == 0 {
+		log.Debugf("Source AMI %s has no tags to copy", ami.SourceAmiID)
+		return
 	}
-	log.Infof("New AMI ID: %s", *output.ImageId)
-	relatedAmi.SourceAmiID = *output.ImageId
 
-	// Wait until AMI is `available`
-	duration, _ := time.ParseDuration("5s")
-	maxDuration, _ := time.ParseDuration("30s")
-	maxPollingDuration, _ := time.ParseDuration("30m")
-	warningThreshold, _ := time.ParseDuration("5m")
-	start := time.Now()
-	warnedAboutThreshold := false
+	tagged := make(map[string]bool, len(accounts))
+	for _, account := range accounts {
+		if tagged[account] || (account == defaultAccount && relatedAmi == ami) {
+			continue
+		}
+		tagged[account] = true
 
-	for {
-		_ = relatedAmi.fetchMetadata()
+		var snapshotIDs []string
+		if account == defaultAccount {
+			snapshotIDs = snapshotIDsOf(relatedAmi.AWSImage)
+		}
+		err := relatedAmi.setTagsForAccount(ctx, account, tags, snapshotIDs...)
+		ami.Journal.record(JournalEntry{
+			Step: journalStepTagged, Region: regionResult.Region, Account: account, AmiID: relatedAmi.SourceAmiID,
+			SnapshotIDs: snapshotIDs, Error: errorString(err),
+		})
 
-		if relatedAmi.isAvailable() {
-			log.Infof("AMI %s is available.", relatedAmi.SourceAmiID)
-			break
+		if err != nil {
+			regionResult.TagErrors[account] = err
+			log.Errorf("Setting tags on AMI %s for account %s failed: %v", relatedAmi.SourceAmiID, account, err)
 		}
+	}
+}
+
+func (ami *Ami) sourceTags() []ec2Types.Tag {
+	if ami.SourceAmiTags == nil {
+		return nil
+	}
+	return *ami.SourceAmiTags
+}
 
-		elapsed := time.Since(start)
+// tagTemplateData returns the values for the tag templates of the copy with amiID in region.
+func (ami *Ami) tagTemplateData(region string, amiID string, copiedAt time.Time) TagTemplateData {
+	return TagTemplateData{
+		SourceAmiID:   ami.SourceAmiID,
+		SourceAmiName: ami.SourceAmiName,
+		SourceRegion:  ami.SourceRegion,
+		AmiID:         amiID,
+		Region:        region,
+		CopiedAt:      copiedAt.Format(time.RFC3339),
+	}
+}
 
-		// Warn if polling exceeds threshold
-		if elapsed > warningThreshold && !warnedAboutThreshold {
-			log.Warnf("AMI %s has not become available after %s. This may indicate a regional issue.", relatedAmi.SourceAmiID, elapsed)
-			warnedAboutThreshold = true
+// copyOrReuse returns the copy of the AMI in the region of regionResult once it is available. A
+// copy that fails is deregistered with opts.DeregisterFailedCopies, and copied again up to
+// opts.CopyRetries times. taggedAtCopy reports whether the last copy was tagged in the CopyImage
+// request.
+func (ami *Ami) copyOrReuse(ctx context.Context, regionResult *RegionCopyResult, opts CopyOptions, copiedAt time.Time) (relatedAmi *Ami, taggedAtCopy bool, err error) {
+	region := regionResult.Region
+	for {
+		relatedAmi, taggedAtCopy, err = ami.copyOnce(ctx, regionResult, opts, copiedAt)
+		var failure *CopyFailedError
+		if !errors.As(err, &failure) {
+			return relatedAmi, taggedAtCopy, err
+		}
+		log.Errorf("Copying AMI %s to region %s failed: %v", ami.SourceAmiID, region, failure)
+		ami.Journal.record(JournalEntry{Step: journalStepCopyFailed, Region: region, AmiID: failure.AmiID, Error: failure.Error()})
+
+		failed := ami.AmisPerRegion[region]
+		if opts.DeregisterFailedCopies {
+			ec2Service := getEC2ServiceForAccountAndRegion(*ConfigManager.defaultAccountID, region)
+			if deregisterErr := removeAwsAmi(ctx, ami.Journal, region, failed.AWSImage, ec2Service); deregisterErr != nil {
+				// a retry would fail, since the failed copy keeps its name
+				log.Warnf("Unable to deregister failed copy %s in region %s: %v", failure.AmiID, region, deregisterErr)
+				return nil, taggedAtCopy, err
+			}
+			log.Infof("Deregistered failed copy %s in region %s", failure.AmiID, region)
+			failed.SourceAmiID = ""
+		}
+		if regionResult.Retries >= opts.CopyRetries || ctx.Err() != nil {
+			return nil, taggedAtCopy, err
 		}
 
-		// Check if total polling duration exceeded
-		if elapsed > maxPollingDuration {
-			return nil, fmt.Errorf("timeout waiting for AMI %s to become available after %s in region %s", relatedAmi.SourceAmiID, elapsed, region)
+		regionResult.Retries++
+		log.Infof("Copying AMI %s to region %s again (retry %d of %d)", ami.SourceAmiID, region, regionResult.Retries, opts.CopyRetries)
+		failed.SourceAmiID = ""
+		failed.AWSImage = nil
+	}
+}
+
+// copyOnce returns the copy of the AMI in the region of regionResult once it is available. A copy
+// that was already started, either given up front or found from an earlier run that failed
+// halfway, is reused and waited for; otherwise a new copy is started. With opts.NoWait, a copy that
+// is not available yet is returned right away and the region is marked pending.
+func (ami *Ami) copyOnce(ctx context.Context, regionResult *RegionCopyResult, opts CopyOptions, copiedAt time.Time) (relatedAmi *Ami, taggedAtCopy bool, err error) {
+	region := regionResult.Region
+	relatedAmi = ami.AmisPerRegion[region]
+
+	if relatedAmi.SourceAmiID != "" {
+		log.Infof("Waiting for copy %s of AMI %s in region %s", relatedAmi.SourceAmiID, ami.SourceAmiID, region)
+	} else if relatedAmi, taggedAtCopy, err = ami.startCopy(ctx, regionResult, opts, copiedAt); err != nil {
+		return nil, taggedAtCopy, err
+	}
+
+	if opts.NoWait && (relatedAmi.AWSImage == nil || relatedAmi.AWSImage.State != ec2Types.ImageStateAvailable) {
+		regionResult.Pending = true
+		return relatedAmi, taggedAtCopy, nil
+	}
+	if err := relatedAmi.waitUntilAvailable(ctx, opts.Wait); err != nil {
+		return nil, taggedAtCopy, err
+	}
+	ami.Journal.record(JournalEntry{Step: journalStepCopyAvailable, Region: region, AmiID: relatedAmi.SourceAmiID})
+	return relatedAmi, taggedAtCopy, nil
+}
+
+// startCopy reuses an earlier copy of the AMI in the region of regionResult, or else starts a new
+// one. It does not wait for the copy to become available.
+func (ami *Ami) startCopy(ctx context.Context, regionResult *RegionCopyResult, opts CopyOptions, copiedAt time.Time) (*Ami, bool, error) {
+	region := regionResult.Region
+	existing, err := ami.existingCopy(ctx, *ConfigManager.defaultAccountID, region, opts)
+	if err != nil {
+		return nil, false, err
+	}
+	if existing != nil {
+		regionResult.Reused = true
+		relatedAmi := ami.AmisPerRegion[region]
+		relatedAmi.SourceAmiID = aws.ToString(existing.ImageId)
+		relatedAmi.SourceAmiName = aws.ToString(existing.Name)
+		relatedAmi.AWSImage = existing
+		log.Infof("Reusing copy %s (%s) of AMI %s in region %s", relatedAmi.SourceAmiID, existing.State, ami.SourceAmiID, region)
+		ami.Journal.record(JournalEntry{Step: journalStepCopyReused, Region: region, AmiID: relatedAmi.SourceAmiID})
+		return relatedAmi, false, nil
+	}
+
+	var tags *copyTags
+	if !opts.TagAfterCopy {
+		tags = opts.TagRules.atCopy(ami.sourceTags(), ami.tagTemplateData(region, "", copiedAt))
+	}
+	relatedAmi, err := ami.copyToRegion(ctx, region, opts, tags)
+	return relatedAmi, tags != nil, err
+}
+
+// recordRegion records the outcome of the copy to the region of regionResult in the journal.
+func (ami *Ami) recordRegion(regionResult *RegionCopyResult) {
+	if regionResult.Pending {
+		return
+	}
+	if regionResult.Failed() {
+		ami.Journal.record(JournalEntry{Step: journalStepRegionFailed, Region: regionResult.Region, AmiID: regionResult.AmiID, Error: errorString(regionResult.Err())})
+		return
+	}
+	ami.Journal.record(JournalEntry{Step: journalStepRegionDone, Region: regionResult.Region, AmiID: regionResult.AmiID})
+}
+
+// recordSharedSnapshots records the snapshots of the copy in the region of regionResult that were
+// shared in the journal.
+func (ami *Ami) recordSharedSnapshots(regionResult *RegionCopyResult) {
+	var shared []string
+	for _, snapshot := range regionResult.Snapshots {
+		if snapshot.Err == nil {
+			shared = append(shared, snapshot.SnapshotID)
 		}
+	}
+	ami.Journal.record(JournalEntry{
+		Step: journalStepSnapshotsShared, Region: regionResult.Region, AmiID: regionResult.AmiID,
+		SnapshotIDs: shared, Principals: ConfigManager.accounts,
+	})
+}
 
-		log.Infof("AMI %s is not available yet. Waiting %f seconds.", relatedAmi.SourceAmiID, duration.Seconds())
-		time.Sleep(duration)
+// existingCopy returns the newest copy of the AMI in region that account owns and that is
+// available, or else still pending. Failed copies are ignored, as are copies that are not encrypted
+// when opts asks for encryption, or that are encrypted with another key than the one opts gives for
+// region.
+func (ami *Ami) existingCopy(ctx context.Context, account string, region string, opts CopyOptions) (*ec2Types.Image, error) {
+	ec2Service := getEC2ServiceForAccountAndRegion(account, region)
+	output, err := ec2Service.DescribeImages(ctx, &ec2.DescribeImagesInput{
+		Owners:  []string{"self"},
+		Filters: ami.copyFilters(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("looking for an existing copy of AMI %s: %w", ami.SourceAmiID, err)
+	}
 
-		// Increase wait duration up to max, but cap at 30 seconds
-		duration = duration + 5*time.Second
-		if duration > maxDuration {
-			duration = maxDuration
+	encrypted, kmsKeyID := opts.encryptionFor(region)
+	var keyArn string
+	var found *ec2Types.Image
+	for i := range output.Images {
+		image := &output.Images[i]
+		if encrypted && !isEncrypted(image) {
+			log.Infof("Not reusing copy %s of AMI %s in region %s, since it is not encrypted", aws.ToString(image.ImageId), ami.SourceAmiID, region)
+			continue
+		}
+		if kmsKeyID != "" {
+			// the images list the ARN of their key, whereas the key may be given by ID or alias
+			if keyArn == "" {
+				if keyArn, err = kmsKeyArn(ctx, account, region, kmsKeyID); err != nil {
+					return nil, err
+				}
+			}
+			if !isEncryptedWith(image, keyArn) {
+				log.Infof("Not reusing copy %s of AMI %s in region %s, since it is not encrypted with KMS key %s", aws.ToString(image.ImageId), ami.SourceAmiID, region, kmsKeyID)
+				continue
+			}
+		}
+		if found == nil || betterCopy(image, found) {
+			found = image
 		}
 	}
+	return found, nil
+}
+
+// copyFilters returns the DescribeImages filters that find the copies of the AMI that are available
+// or still pending.
+func (ami *Ami) copyFilters() []ec2Types.Filter {
+	return []ec2Types.Filter{
+		{Name: aws.String("source-image-id"), Values: []string{ami.SourceAmiID}},
+		{Name: aws.String("source-image-region"), Values: []string{ami.SourceRegion}},
+		{Name: aws.String("state"), Values: []string{string(ec2Types.ImageStateAvailable), string(ec2Types.ImageStatePending)}},
+	}
+}
 
-	elapsed := time.Since(start)
-	log.Infof("AMI %s took %s to become available in region %s", *output.ImageId, elapsed, region)
+// betterCopy reports whether image is a better copy to reuse than other: available before
+// pending, then the newest.
+func betterCopy(image *ec2Types.Image, other *ec2Types.Image) bool {
+	if (image.State == ec2Types.ImageStateAvailable) != (other.State == ec2Types.ImageStateAvailable) {
+		return image.State == ec2Types.ImageStateAvailable
+	}
+	return aws.ToString(image.CreationDate) > aws.ToString(other.CreationDate)
+}
+
+// copyToRegion starts copying the AMI to region, without waiting for the copy. The copy and its
+// snapshots get tags in the CopyImage request, so they are tagged from the start, unless tags is
+// nil.
+func (ami *Ami) copyToRegion(ctx context.Context, region string, opts CopyOptions, tags *copyTags) (*Ami, error) {
+	relatedAmi := ami.AmisPerRegion[region]
+
+	log.Infof("Copying AMI to region %s", relatedAmi.SourceRegion)
+	copyImageInput := ami.copyImageInput(region, relatedAmi.SourceAmiName, relatedAmi.description, opts, tags)
+	ec2Service := getEC2ServiceForAccountAndRegion(*ConfigManager.defaultAccountID, relatedAmi.SourceRegion)
 
+	output, err := ec2Service.CopyImage(ctx, copyImageInput)
+
+	if err != nil {
+		log.Debug(err)
+		return nil, err
+	}
+	log.Infof("New AMI ID: %s", *output.ImageId)
+	relatedAmi.SourceAmiID = *output.ImageId
+	ami.Journal.record(JournalEntry{Step: journalStepCopyStarted, Region: region, AmiID: relatedAmi.SourceAmiID})
 	return relatedAmi, nil
 }
 
-func (ami *Ami) setOwners(owners []string) error {
+// copyImageInput returns the CopyImage request that copies the AMI to region under name.
+func (ami *Ami) copyImageInput(region string, name string, description string, opts CopyOptions, tags *copyTags) *ec2.CopyImageInput {
+	copyImageInput := &ec2.CopyImageInput{
+		Name:          aws.String(name),
+		SourceRegion:  aws.String(ami.SourceRegion),
+		SourceImageId: aws.String(ami.SourceAmiID),
+	}
+	if description != "" {
+		copyImageInput.Description = aws.String(description)
+	}
+	if encrypted, kmsKeyID := opts.encryptionFor(region); encrypted {
+		copyImageInput.Encrypted = aws.Bool(true)
+		if kmsKeyID != "" {
+			copyImageInput.KmsKeyId = aws.String(kmsKeyID)
+			log.Infof("Encrypting the copy in region %s with KMS key %s", region, kmsKeyID)
+		} else {
+			log.Infof("Encrypting the copy in region %s with the default EBS KMS key", region)
+		}
+	}
+	if tags != nil {
+		copyImageInput.CopyImageTags = aws.Bool(tags.copySourceTags)
+		copyImageInput.TagSpecifications = tags.tagSpecifications()
+	}
+	return copyImageInput
+}
+
+// setOwners grants launch permissions on the AMI to the owner accounts and to the organizations and
+// organizational units in orgs.
+func (ami *Ami) setOwners(ctx context.Context, owners []string, orgs OrganizationTargets) error {
 	log.Infof("Setting owners to AMI %s", ami.SourceAmiID)
 	log.Debugf("Fetching EC2 service for region: %s", ami.SourceRegion)
 	ec2Service := getEC2ServiceForAccountAndRegion(*ConfigManager.defaultAccountID, ami.SourceRegion)
@@ -258,42 +597,70 @@ func (ami *Ami) setOwners(owners []string) error {
 	modifyImageAttributeInput := &ec2.ModifyImageAttributeInput{
 		ImageId: aws.String(ami.SourceAmiID),
 		LaunchPermission: &ec2Types.LaunchPermissionModifications{
-			Add: createLaunchPermissionsForOwners(owners),
+			Add: append(createLaunchPermissionsForOwners(owners), orgs.launchPermissions()...),
 		},
 	}
 
-	_, err := ec2Service.ModifyImageAttribute(context.Background(), modifyImageAttributeInput)
+	_, err := ec2Service.ModifyImageAttribute(ctx, modifyImageAttributeInput)
 
 	log.Debugf("Owners set for AMI %s", ami.SourceAmiID)
 
 	return err
 }
 
-// isAvailable returns true if the AMI is in the 'available' state.
-func (ami *Ami) isAvailable() bool {
-	if ami.AWSImage == nil {
-		_ = ami.fetchMetadata()
-	}
-
-	log.Debugf("Current AMI state is %s", ami.AWSImage.State)
-	return ami.AWSImage.State == ec2Types.ImageStateAvailable
-}
-
-func (ami *Ami) setTagsForAccount(account string, tags []ec2Types.Tag) error {
+// setTagsForAccount tags the AMI, and the given snapshots, as seen by account.
+func (ami *Ami) setTagsForAccount(ctx context.Context, account string, tags []ec2Types.Tag, snapshotIDs ...string) error {
 	log.Infof("Setting tags for account %s", account)
 	log.Debug(ami)
 	ec2service := getEC2ServiceForAccountAndRegion(account, ami.SourceRegion)
 
 	input := &ec2.CreateTagsInput{
-		Resources: []string{ami.SourceAmiID},
+		Resources: append([]string{ami.SourceAmiID}, snapshotIDs...),
 		Tags:      tags,
 	}
 
-	_, err := ec2service.CreateTags(context.Background(), input)
+	_, err := ec2service.CreateTags(ctx, input)
 
 	return err
 }
 
+// targetRegions returns the regions the AMI is copied to, sorted by name.
+func (ami *Ami) targetRegions() []string {
+	return slices.Sorted(maps.Keys(ami.AmisPerRegion))
+}
+
+// isEncrypted returns true if every EBS volume of the image is encrypted.
+func isEncrypted(image *ec2Types.Image) bool {
+	if image == nil {
+		return false
+	}
+	hasEbs := false
+	for _, mapping := range image.BlockDeviceMappings {
+		if mapping.Ebs == nil {
+			continue
+		}
+		hasEbs = true
+		if mapping.Ebs.Encrypted == nil || !*mapping.Ebs.Encrypted {
+			return false
+		}
+	}
+	return hasEbs
+}
+
+// isEncryptedWith returns true if every EBS volume of the image is encrypted with the KMS key with
+// ARN keyArn.
+func isEncryptedWith(image *ec2Types.Image, keyArn string) bool {
+	if !isEncrypted(image) {
+		return false
+	}
+	for _, mapping := range image.BlockDeviceMappings {
+		if mapping.Ebs != nil && aws.ToString(mapping.Ebs.KmsKeyId) != keyArn {
+			return false
+		}
+	}
+	return true
+}
+
 func convertRegionSliceToAmi(slice []string) map[string]*Ami {
 	amis := make(map[string]*Ami)
 
@@ -315,81 +682,207 @@ func createLaunchPermissionsForOwners(owners []string) []ec2Types.LaunchPermissi
 	return launchPermissions
 }
 
-// Cleanup removes older AMI versions based on tag filters and keeps only the specified number of newest versions per region.
-func (ami *Ami) Cleanup(regions []string, tagsToMatch []string, versionsToKeep int) error {
+// Cleanup removes older AMI versions based on tag filters and keeps only the versions opts.Retention keeps per region.
+// Other versions with a tag in opts.ProtectTags, or still used by instances, launch templates,
+// launch configurations or Auto Scaling groups, in the default account or in the accounts of the
+// ConfigManager, are kept as well. Images of other accounts that match, with opts.Owners, are
+// skipped. The result holds the plan for every version, and why versions could not be
+// deregistered. With opts.DryRun, nothing is removed.
+func (ami *Ami) Cleanup(ctx context.Context, regions []string, opts CleanupOptions) (*CleanupResult, error) {
+	if err := opts.Retention.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid retention policy: %w", err)
+	}
+	if !opts.DryRun {
+		ami.finishSnapshotDeletions(ctx)
+	}
+
 	// describe ami
-	err := ami.fetchMetadata()
+	err := ami.fetchMetadata(ctx)
 
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	// convert Tag slice to map for easier lookup
-	tags := convertTagSliceToMap(ami.AWSImage.Tags)
+	// take the values to match from the tags of the AMI
+	filters, err := opts.Tags.resolve(ami.AWSImage.Tags)
+	if err != nil {
+		return nil, fmt.Errorf("AMI %s: %w", ami.SourceAmiID, err)
+	}
 
-	// get the tags we need to match with
-	var matchedTags []ec2Types.Tag
-	for _, tagToMatch := range tagsToMatch {
-		if match, ok := tags[tagToMatch]; ok {
-			matchedTags = append(matchedTags, match)
+	result := &CleanupResult{
+		SourceAmiID: ami.SourceAmiID,
+		DryRun:      opts.DryRun,
+		Retention:   opts.Retention,
+		Regions:     make(map[string]*RegionCleanupResult, len(regions)),
+	}
+	for _, region := range regions {
+		regionResult := &RegionCleanupResult{Region: region}
+		result.Regions[region] = regionResult
+		if err := ami.cleanupRegion(ctx, regionResult, filters, opts); err != nil {
+			return result, err
 		}
 	}
+	return result, nil
+}
 
-	for _, region := range regions {
-		ec2svc := getEC2ServiceForAccountAndRegion(*ConfigManager.defaultAccountID, region)
+// cleanupRegion plans which versions in the region of regionResult are kept and which are
+// deregistered, and deregisters them unless opts.DryRun is set.
+func (ami *Ami) cleanupRegion(ctx context.Context, regionResult *RegionCleanupResult, filters TagFilters, opts CleanupOptions) error {
+	region := regionResult.Region
+	ec2svc := getEC2ServiceForAccountAndRegion(*ConfigManager.defaultAccountID, region)
 
-		describeImagesInput := ec2.DescribeImagesInput{
-			Filters: convertTagSliceToFilter(matchedTags),
+	describeImagesInput := ec2.DescribeImagesInput{
+		Owners:  opts.owners(),
+		Filters: filters.ec2Filters(),
+	}
+	var images []ec2Types.Image
+	paginator := ec2.NewDescribeImagesPaginator(ec2svc, &describeImagesInput)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("describing images in region %s: %w", region, err)
 		}
-		result, err := ec2svc.DescribeImages(context.Background(), &describeImagesInput)
+		// EC2 filters cannot negate, so match every filter again
+		for _, image := range page.Images {
+			if filters.matches(image.Tags) {
+				images = append(images, image)
+			}
+		}
+	}
 
+	// sort the returned images, newest first
+	created := make(map[string]time.Time, len(images))
+	for _, image := range images {
+		date, err := time.Parse(time.RFC3339, aws.ToString(image.CreationDate))
 		if err != nil {
-			log.Fatal(err)
+			return fmt.Errorf("creation date of image %s in region %s: %w", aws.ToString(image.ImageId), region, err)
 		}
+		created[aws.ToString(image.ImageId)] = date
+	}
+	sort.SliceStable(images, func(i, j int) bool {
+		return created[aws.ToString(images[i].ImageId)].After(created[aws.ToString(images[j].ImageId)])
+	})
 
-		images := result.Images
-
-		// sort the returned images
-		sort.Slice(images, func(i, j int) bool {
-			firstDate, err := time.Parse(time.RFC3339, *images[i].CreationDate)
+	// only the images of the default account can be deregistered, so only they are versions
+	var owned []ec2Types.Image
+	for _, image := range images {
+		if aws.ToString(image.OwnerId) == *ConfigManager.defaultAccountID {
+			owned = append(owned, image)
+		}
+	}
+	decisions, err := opts.Retention.evaluate(owned, time.Now())
+	if err != nil {
+		return fmt.Errorf("applying the retention policy in region %s: %w", region, err)
+	}
 
-			if err != nil {
-				log.Fatal(err)
+	var usage AmiUsage
+	for _, decision := range decisions {
+		if decision.reason == "" {
+			if usage, err = amisInUse(ctx, region, ConfigManager.getAccounts()); err != nil {
+				return fmt.Errorf("checking which AMIs are in use in region %s: %w", region, err)
 			}
+			break
+		}
+	}
 
-			secondDate, err := time.Parse(time.RFC3339, *images[j].CreationDate)
+	for i := range images {
+		decision, ok := decisions[aws.ToString(images[i].ImageId)]
+		if !ok {
+			log.Warnf("Skipping image %s, which is owned by account %s", aws.ToString(images[i].ImageId), aws.ToString(images[i].OwnerId))
+			regionResult.Images = append(regionResult.Images, planNotOwned(&images[i]))
+			continue
+		}
+		regionResult.Images = append(regionResult.Images, planCleanup(&images[i], decision, opts, usage))
+	}
+	if opts.DryRun {
+		return nil
+	}
 
-			if err != nil {
-				log.Fatal(err)
-			}
+	for i := range regionResult.Images {
+		planned := &regionResult.Images[i]
+		if planned.Action != CleanupActionDeregister {
+			continue
+		}
+		// stop between images, never halfway through removing one
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("cleanup in region %s interrupted: %w", region, err)
+		}
+		log.Debugf("Deleting image %s", planned.AmiID)
+		if err := removeAwsAmi(ctx, ami.Journal, region, &images[i], ec2svc); err != nil {
+			log.Errorf("Unable to delete image %s in region %s: %v", planned.AmiID, region, err)
+			planned.Error = err.Error()
+			continue
+		}
+		log.Infof("Image %s deleted", planned.AmiID)
+		planned.Deregistered = true
+	}
+	return nil
+}
 
-			return firstDate.After(secondDate)
-		})
+// planCleanup decides what happens to image: the versions that the retention policy keeps, the
+// versions with a protect tag and the versions in usage are kept, the others are deregistered with
+// their snapshots.
+func planCleanup(image *ec2Types.Image, decision retention, opts CleanupOptions, usage AmiUsage) CleanupImage {
+	planned := CleanupImage{
+		AmiID:        aws.ToString(image.ImageId),
+		Name:         aws.ToString(image.Name),
+		CreationDate: aws.ToString(image.CreationDate),
+		Group:        decision.group,
+		SnapshotIDs:  snapshotIDsOf(image),
+		Action:       CleanupActionKeep,
+	}
+	tag := protectTag(image, opts.ProtectTags)
+	switch {
+	case decision.reason != "":
+		planned.Reason = decision.reason
+	case tag != "":
+		planned.Reason = CleanupReasonProtected
+		planned.ProtectTag = tag
+		log.Infof("Keeping image %s, which is protected by tag %s", planned.AmiID, planned.ProtectTag)
+	case len(usage[planned.AmiID]) > 0:
+		planned.Reason = CleanupReasonInUse
+		planned.UsedBy = usage[planned.AmiID]
+		log.Infof("Keeping image %s, which is in use by %s", planned.AmiID, strings.Join(planned.UsedBy, ", "))
+	default:
+		planned.Action = CleanupActionDeregister
+	}
+	return planned
+}
 
-		var i = 0
-		for _, image := range images {
-			// keep the first (i.e. most recent) AMI
-			if i >= versionsToKeep {
-				log.Debugf("Deleting image %s", *image.ImageId)
-				err = removeAwsAmi(&image, ec2svc)
-				log.Infof("Image %s deleted", *image.ImageId)
+// planNotOwned reports image, which another account owns, as skipped.
+func planNotOwned(image *ec2Types.Image) CleanupImage {
+	return CleanupImage{
+		AmiID:        aws.ToString(image.ImageId),
+		Name:         aws.ToString(image.Name),
+		CreationDate: aws.ToString(image.CreationDate),
+		OwnerID:      aws.ToString(image.OwnerId),
+		Action:       CleanupActionSkip,
+		Reason:       CleanupReasonNotOwned,
+	}
+}
 
-				if err != nil {
-					log.Fatal(err)
-				}
-			}
-			i++
+// protectTag returns the first tag of image, as key=value, that protectTags protects, or "". An
+// empty value in protectTags protects every value of the key.
+func protectTag(image *ec2Types.Image, protectTags map[string]string) string {
+	for _, tag := range image.Tags {
+		key, value := aws.ToString(tag.Key), aws.ToString(tag.Value)
+		if protected, ok := protectTags[key]; ok && (protected == "" || protected == value) {
+			return key + "=" + value
 		}
-
 	}
-	return err
+	return ""
 }
 
 // RemoveAmi deregisters the AMI and deletes its associated snapshots.
 // If dryRun is true, it logs what would be deleted without making changes.
-func (ami *Ami) RemoveAmi(dryRun bool) error {
+func (ami *Ami) RemoveAmi(ctx context.Context, dryRun bool) error {
+	if !dryRun && ami.finishSnapshotDeletions(ctx)[ami.SourceAmiID] {
+		log.Infof("AMI %s was already deregistered by the resumed run", ami.SourceAmiID)
+		return nil
+	}
+
 	// describe ami (existence + metadata pre-check)
-	err := ami.fetchMetadata()
+	err := ami.fetchMetadata(ctx)
 	if err != nil {
 		account := "<unknown>"
 		if ConfigManager != nil && ConfigManager.defaultAccountID != nil {
@@ -400,14 +893,7 @@ func (ami *Ami) RemoveAmi(dryRun bool) error {
 
 	if dryRun {
 		// Collect snapshot IDs (if any) for informational output
-		snapshotIDs := []string{}
-		if ami.AWSImage != nil {
-			for _, mapping := range ami.AWSImage.BlockDeviceMappings {
-				if mapping.Ebs != nil && mapping.Ebs.SnapshotId != nil {
-					snapshotIDs = append(snapshotIDs, *mapping.Ebs.SnapshotId)
-				}
-			}
-		}
+		snapshotIDs := snapshotIDsOf(ami.AWSImage)
 		log.Infof("[dry-run] Would deregister AMI %s (name=%s) in region %s", ami.SourceAmiID, ami.SourceAmiName, ami.SourceRegion)
 		if len(snapshotIDs) > 0 {
 			log.Infof("[dry-run] Would delete snapshots: %v", snapshotIDs)
@@ -419,27 +905,31 @@ func (ami *Ami) RemoveAmi(dryRun bool) error {
 	}
 
 	ec2Service := getEC2ServiceForAccountAndRegion(*ConfigManager.defaultAccountID, ConfigManager.GetDefaultRegion())
-	if err := removeAwsAmi(ami.AWSImage, ec2Service); err != nil {
+	if err := removeAwsAmi(ctx, ami.Journal, ami.SourceRegion, ami.AWSImage, ec2Service); err != nil {
 		return fmt.Errorf("failed removing AMI %s: %w", ami.SourceAmiID, err)
 	}
 
 	return nil
 }
 
-func removeAwsAmi(image *ec2Types.Image, ec2Service *ec2.Client) error {
+// removeAwsAmi deregisters the image in region and deletes its snapshots, recording both in the
+// journal.
+func removeAwsAmi(ctx context.Context, journal *Journal, region string, image *ec2Types.Image, ec2Service EC2API) error {
 	// deregister ami
 	deregisterAmiInput := &ec2.DeregisterImageInput{
 		ImageId: image.ImageId,
 	}
 
-	_, err := ec2Service.DeregisterImage(context.Background(), deregisterAmiInput)
+	_, err := ec2Service.DeregisterImage(ctx, deregisterAmiInput)
 	if err != nil {
 		return err
 	}
 
 	log.Debug("AMI is de-registered.")
+	journal.record(JournalEntry{Step: journalStepDeregistered, Region: region, AmiID: aws.ToString(image.ImageId), SnapshotIDs: snapshotIDsOf(image)})
 
-	// delete snapshots
+	// delete snapshots, even when interrupted, so a deregistered AMI doesn't leave them behind
+	ctx = context.WithoutCancel(ctx)
 	var snapshotErrors []error
 	for _, mapping := range image.BlockDeviceMappings {
 		if mapping.Ebs == nil || mapping.Ebs.SnapshotId == nil {
@@ -450,12 +940,13 @@ func removeAwsAmi(image *ec2Types.Image, ec2Service *ec2.Client) error {
 			SnapshotId: &snapshotID,
 		}
 
-		_, err := ec2Service.DeleteSnapshot(context.Background(), deleteSnapshotInput)
+		_, err := ec2Service.DeleteSnapshot(ctx, deleteSnapshotInput)
 		if err != nil {
 			log.Warnf("Failed to delete snapshot %s: %v", snapshotID, err)
 			snapshotErrors = append(snapshotErrors, err)
 		} else {
 			log.Debugf("Successfully deleted snapshot %s", snapshotID)
+			journal.record(JournalEntry{Step: journalStepSnapshotDeleted, Region: region, AmiID: aws.ToString(image.ImageId), SnapshotIDs: []string{snapshotID}})
 		}
 	}
 
@@ -477,25 +968,3 @@ func convertTagSliceToMap(tagSlice []ec2Types.Tag) map[string]ec2Types.Tag {
 	}
 	return tagMap
 }
-
-func convertTagSliceToFilter(tags []ec2Types.Tag) []ec2Types.Filter {
-	tagsFilter := make([]ec2Types.Filter, len(tags))
-
-	for key, tag := range tags {
-		tagsFilter[key] = convertTagToFilter(tag)
-	}
-
-	return tagsFilter
-}
-
-func convertTagToFilter(tag ec2Types.Tag) ec2Types.Filter {
-	name := "tag:" + *tag.Key
-	values := make([]string, 1)
-
-	values = append(values, *tag.Value)
-
-	return ec2Types.Filter{
-		Name:   &name,
-		Values: values,
-	}
-}
@@ -133,57 +133,6 @@ func TestConvertTagSliceToMap(t *testing.T) {
 	}
 }
 
-func TestConvertTagSliceToFilter(t *testing.T) {
-	tests := []struct {
-		name        string
-		tags        []ec2Types.Tag
-		expectedLen int
-	}{
-		{
-			name:        "empty tags",
-			tags:        []ec2Types.Tag{},
-			expectedLen: 0,
-		},
-		{
-			name: "single tag",
-			tags: []ec2Types.Tag{
-				{Key: strPtr("Name"), Value: strPtr("test-ami")},
-			},
-			expectedLen: 1,
-		},
-		{
-			name: "multiple tags",
-			tags: []ec2Types.Tag{
-				{Key: strPtr("Name"), Value: strPtr("test-ami")},
-				{Key: strPtr("Environment"), Value: strPtr("prod")},
-			},
-			expectedLen: 2,
-		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			result := convertTagSliceToFilter(tt.tags)
-			if len(result) != tt.expectedLen {
-				t.Errorf("convertTagSliceToFilter() length = %d, want %d", len(result), tt.expectedLen)
-			}
-
-			// Verify each filter has correct tag: prefix
-			for i, filter := range result {
-				if filter.Name == nil {
-					t.Errorf("convertTagSliceToFilter() filter[%d] name is nil", i)
-					continue
-				}
-
-				// Name should be "tag:KeyName"
-				if len(*filter.Name) < 5 || (*filter.Name)[:4] != "tag:" {
-					t.Errorf("convertTagSliceToFilter() filter[%d] name = %q, should start with 'tag:'", i, *filter.Name)
-				}
-			}
-		})
-	}
-}
-
 func TestCreateLaunchPermissionsForOwners(t *testing.T) {
 	tests := []struct {
 		name          string
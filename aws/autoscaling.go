@@ -0,0 +1,25 @@
+package aws
+
+import (
+	"context"
+
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+)
+
+// AutoScalingAPI is the subset of the Auto Scaling client used to find the AMIs that launch
+// configurations and Auto Scaling groups still use.
+type AutoScalingAPI interface {
+	DescribeAutoScalingGroups(ctx context.Context, params *autoscaling.DescribeAutoScalingGroupsInput, optFns ...func(*autoscaling.Options)) (*autoscaling.DescribeAutoScalingGroupsOutput, error)
+	DescribeLaunchConfigurations(ctx context.Context, params *autoscaling.DescribeLaunchConfigurationsInput, optFns ...func(*autoscaling.Options)) (*autoscaling.DescribeLaunchConfigurationsOutput, error)
+}
+
+var _ AutoScalingAPI = (*autoscaling.Client)(nil)
+
+// AutoScalingClientFactory builds the Auto Scaling client for an account. The config passed in
+// already has its region set to the target region.
+type AutoScalingClientFactory func(account string, conf awsv2.Config) AutoScalingAPI
+
+func newAutoScalingClient(_ string, conf awsv2.Config) AutoScalingAPI {
+	return autoscaling.NewFromConfig(conf)
+}
@@ -0,0 +1,88 @@
+package aws
+
+import (
+	"sync"
+)
+
+type clientKey struct {
+	account string
+	region  string
+}
+
+// clientRegistry caches the service clients built for each account and region. It is safe for
+// concurrent use, so parallel operations can share a single ConfigurationManager.
+type clientRegistry struct {
+	mu          sync.Mutex
+	ec2         map[clientKey]EC2API
+	sts         map[clientKey]STSAPI
+	kms         map[clientKey]KMSAPI
+	autoScaling map[clientKey]AutoScalingAPI
+}
+
+// ec2Client returns the cached EC2 client for the key, calling build once to create it.
+func (r *clientRegistry) ec2Client(key clientKey, build func() EC2API) EC2API {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.ec2 == nil {
+		r.ec2 = make(map[clientKey]EC2API)
+	}
+	if r.ec2[key] == nil {
+		r.ec2[key] = build()
+	}
+	return r.ec2[key]
+}
+
+// stsClient returns the cached STS client for the key, calling build once to create it.
+func (r *clientRegistry) stsClient(key clientKey, build func() STSAPI) STSAPI {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.sts == nil {
+		r.sts = make(map[clientKey]STSAPI)
+	}
+	if r.sts[key] == nil {
+		r.sts[key] = build()
+	}
+	return r.sts[key]
+}
+
+// kmsClient returns the cached KMS client for the key, calling build once to create it.
+func (r *clientRegistry) kmsClient(key clientKey, build func() KMSAPI) KMSAPI {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.kms == nil {
+		r.kms = make(map[clientKey]KMSAPI)
+	}
+	if r.kms[key] == nil {
+		r.kms[key] = build()
+	}
+	return r.kms[key]
+}
+
+// autoScalingClient returns the cached Auto Scaling client for the key, calling build once to
+// create it.
+func (r *clientRegistry) autoScalingClient(key clientKey, build func() AutoScalingAPI) AutoScalingAPI {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.autoScaling == nil {
+		r.autoScaling = make(map[clientKey]AutoScalingAPI)
+	}
+	if r.autoScaling[key] == nil {
+		r.autoScaling[key] = build()
+	}
+	return r.autoScaling[key]
+}
+
+// reset drops every cached client, e.g. after the default credentials changed.
+func (r *clientRegistry) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.ec2 = nil
+	r.sts = nil
+	r.kms = nil
+	r.autoScaling = nil
+}
@@ -0,0 +1,127 @@
+package aws
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/cloudnatives/aws-ami-manager/internal/ec2fake"
+)
+
+func TestGetEC2ClientIsSafeForConcurrentUse(t *testing.T) {
+	var built atomic.Int32
+	backend := ec2fake.New(testDefaultAccount)
+	cm := &ConfigurationManager{defaultAccountID: awsv2.String(testDefaultAccount)}
+	cm.SetEC2ClientFactory(func(account string, conf awsv2.Config) EC2API {
+		built.Add(1)
+		return backend.EC2(account, conf.Region)
+	})
+
+	regions := []string{"eu-west-1", "eu-central-1", "us-east-1", "us-west-2"}
+	clients := make([][]EC2API, 16)
+
+	var wg sync.WaitGroup
+	for i := range clients {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for _, region := range regions {
+				clients[i] = append(clients[i], cm.getEC2Client(testDefaultAccount, region))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if got := int(built.Load()); got != len(regions) {
+		t.Errorf("factory called %d times, want %d", got, len(regions))
+	}
+	for i := range clients {
+		for j, client := range clients[i] {
+			if client != clients[0][j] {
+				t.Fatalf("goroutine %d got a different client for %s", i, regions[j])
+			}
+		}
+	}
+}
+
+func TestClientRegistryKeysByAccountAndRegion(t *testing.T) {
+	var registry clientRegistry
+	backend := ec2fake.New(testDefaultAccount)
+	build := func(name string) func() EC2API {
+		return func() EC2API { return backend.EC2(name, name) }
+	}
+
+	a := registry.ec2Client(clientKey{account: "111111111111", region: "eu-west-1"}, build("a"))
+	b := registry.ec2Client(clientKey{account: "222222222222", region: "eu-west-1"}, build("b"))
+	c := registry.ec2Client(clientKey{account: "111111111111", region: "us-east-1"}, build("c"))
+	again := registry.ec2Client(clientKey{account: "111111111111", region: "eu-west-1"}, build("again"))
+
+	if a == b || a == c || b == c {
+		t.Error("different account/region keys share a client")
+	}
+	if again != a {
+		t.Error("same key returned a new client")
+	}
+
+	registry.reset()
+	if registry.ec2Client(clientKey{account: "111111111111", region: "eu-west-1"}, build("fresh")) == a {
+		t.Error("reset() kept the cached client")
+	}
+}
+
+func TestCopyToManyRegionsConcurrently(t *testing.T) {
+	consumers := []string{"222222222222", "333333333333"}
+	backend := useFakeEC2(t, consumers)
+	backend.AddImage(testDefaultAccount, testDefaultRegion, testImage("ami-source", "golden", "2024-01-01T00:00:00.000Z", map[string]string{"Name": "golden"}))
+
+	var regions []string
+	for i := 0; i < 8; i++ {
+		regions = append(regions, fmt.Sprintf("test-region-%d", i))
+	}
+
+	ami := NewAmiWithRegions("ami-source", testDefaultRegion, regions)
+	result, err := ami.Copy(t.Context(), CopyOptions{})
+	if err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+	if err := result.Err(); err != nil {
+		t.Fatalf("Copy() result error = %v", err)
+	}
+
+	for _, region := range regions {
+		if got := len(callInputs[*ec2.CopyImageInput](backend, testDefaultAccount, region, "CopyImage")); got != 1 {
+			t.Errorf("CopyImage calls in %s = %d, want 1", region, got)
+		}
+	}
+}
+
+func TestAssumeDefaultAccountRoleUsesCachedSTSClients(t *testing.T) {
+	backend := ec2fake.New(testDefaultAccount)
+	var built []string
+	cm := &ConfigurationManager{
+		defaultConfig:    awsv2.Config{Region: testDefaultRegion},
+		defaultAccountID: awsv2.String(testDefaultAccount),
+		stsClientFactory: func(account string, _ awsv2.Config) STSAPI {
+			built = append(built, account)
+			return backend.STS(account)
+		},
+	}
+	base := cm.getSTSClient(testDefaultAccount, testDefaultRegion)
+
+	if err := cm.AssumeDefaultAccountRole(t.Context(), "222222222222", "admin"); err != nil {
+		t.Fatalf("AssumeDefaultAccountRole() error = %v", err)
+	}
+	if got := awsv2.ToString(cm.GetDefaultAccountID()); got != "222222222222" {
+		t.Errorf("default account = %s, want 222222222222", got)
+	}
+	if assumed := cm.getSTSClient("222222222222", testDefaultRegion); assumed == base {
+		t.Error("the assumed role shares the STS client of the previous default account")
+	}
+	if !slices.Equal(built, []string{testDefaultAccount, "222222222222"}) {
+		t.Errorf("STS clients built for %v, want one for each account", built)
+	}
+}
@@ -41,20 +41,61 @@ type ConfigurationManager struct {
 	configsPerAccount map[string]awsv2.Config
 
 	role string
+
+	ec2ClientFactory         EC2ClientFactory
+	stsClientFactory         STSClientFactory
+	kmsClientFactory         KMSClientFactory
+	autoScalingClientFactory AutoScalingClientFactory
+	clients                  clientRegistry
+}
+
+// Option customizes a ConfigurationManager while it is being created.
+type Option func(*ConfigurationManager)
+
+// WithEC2ClientFactory makes the ConfigurationManager build its EC2 clients with the given factory.
+func WithEC2ClientFactory(factory EC2ClientFactory) Option {
+	return func(cm *ConfigurationManager) {
+		cm.ec2ClientFactory = factory
+	}
+}
+
+// WithSTSClientFactory makes the ConfigurationManager build its STS clients with the given factory.
+func WithSTSClientFactory(factory STSClientFactory) Option {
+	return func(cm *ConfigurationManager) {
+		cm.stsClientFactory = factory
+	}
+}
+
+// WithKMSClientFactory makes the ConfigurationManager build its KMS clients with the given factory.
+func WithKMSClientFactory(factory KMSClientFactory) Option {
+	return func(cm *ConfigurationManager) {
+		cm.kmsClientFactory = factory
+	}
+}
+
+// WithAutoScalingClientFactory makes the ConfigurationManager build its Auto Scaling clients with
+// the given factory.
+func WithAutoScalingClientFactory(factory AutoScalingClientFactory) Option {
+	return func(cm *ConfigurationManager) {
+		cm.autoScalingClientFactory = factory
+	}
 }
 
 // NewConfigurationManager creates a new ConfigurationManager using environment and AWS credentials.
-func NewConfigurationManager() (*ConfigurationManager, error) {
-	return NewConfigurationManagerForRegionsAndAccounts(make([]string, 0), make([]string, 0), "")
+func NewConfigurationManager(ctx context.Context, opts ...Option) (*ConfigurationManager, error) {
+	return NewConfigurationManagerForRegionsAndAccounts(ctx, make([]string, 0), make([]string, 0), "", opts...)
 }
 
 // NewConfigurationManagerForRegionsAndAccounts creates a new ConfigurationManager with specified regions, accounts, and role for cross-account operations.
-func NewConfigurationManagerForRegionsAndAccounts(regions []string, accounts []string, role string) (*ConfigurationManager, error) {
+func NewConfigurationManagerForRegionsAndAccounts(ctx context.Context, regions []string, accounts []string, role string, opts ...Option) (*ConfigurationManager, error) {
 	cm := &ConfigurationManager{
 		regions:  regions,
 		accounts: accounts,
 		role:     role,
 	}
+	for _, opt := range opts {
+		opt(cm)
+	}
 
 	log.Debug("Setting defaults")
 	// Ensure shared config is considered (helps with SSO profiles when user forgot to export AWS_SDK_LOAD_CONFIG=1)
@@ -64,11 +105,13 @@ func NewConfigurationManagerForRegionsAndAccounts(regions []string, accounts []s
 	}
 
 	profileFromEnv := os.Getenv("AWS_PROFILE")
-	conf, err := config.LoadDefaultConfig(context.TODO(), func(o *config.LoadOptions) error {
+	conf, err := config.LoadDefaultConfig(ctx, func(o *config.LoadOptions) error {
 		// If a profile is set, use it explicitly
 		if profileFromEnv != "" {
 			o.SharedConfigProfile = profileFromEnv
 		}
+		// Every client, including those of the assumed roles, retries throttled calls
+		o.Retryer = newRetryer
 		return nil
 	})
 	if err != nil {
@@ -92,7 +135,7 @@ func NewConfigurationManagerForRegionsAndAccounts(regions []string, accounts []s
 	cm.defaultRegion = conf.Region
 
 	// Attempt credential retrieval before making STS call for clearer diagnostics
-	creds, credErr := conf.Credentials.Retrieve(context.TODO())
+	creds, credErr := conf.Credentials.Retrieve(ctx)
 	if credErr != nil {
 		return nil, fmt.Errorf("unable to retrieve AWS credentials for profile '%s' region '%s': %v. Hints: ensure 'aws sso login --profile %s' was executed, or export static credentials. If using SSO, confirm your AWS CLI v2 cache exists in ~/.aws/sso/cache and AWS_SDK_LOAD_CONFIG=1", cm.defaultProfile, conf.Region, credErr, cm.defaultProfile)
 	}
@@ -107,8 +150,11 @@ func NewConfigurationManagerForRegionsAndAccounts(regions []string, accounts []s
 		"has_session_token":      os.Getenv("AWS_SESSION_TOKEN") != "",
 	}).Debug("Resolved AWS configuration inputs")
 
-	stsService := sts.NewFromConfig(conf)
-	defaultAccountID, err := stsService.GetCallerIdentity(context.TODO(), &sts.GetCallerIdentityInput{})
+	// The default account is not known yet, so the client is cached under an empty account
+	stsService := cm.clients.stsClient(clientKey{region: conf.Region}, func() STSAPI {
+		return cm.newSTSClient("", conf)
+	})
+	defaultAccountID, err := stsService.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
 	if err != nil {
 		baseMsg := fmt.Sprintf("unable to load default account identity (region=%s): %v", conf.Region, err)
 		if strings.Contains(err.Error(), "ResolveEndpointV2") {
@@ -119,6 +165,10 @@ func NewConfigurationManagerForRegionsAndAccounts(regions []string, accounts []s
 	}
 
 	cm.defaultAccountID = defaultAccountID.Account
+	// Now that the account is known, its STS client is shared with getSTSClient and the assumed roles
+	cm.clients.stsClient(clientKey{account: *cm.defaultAccountID, region: conf.Region}, func() STSAPI {
+		return stsService
+	})
 
 	// Enhanced cross-account role handling (in-place patch)
 	// If accounts are specified and role is empty, attempt environment fallback then default constant
@@ -152,7 +202,7 @@ func NewConfigurationManagerForRegionsAndAccounts(regions []string, accounts []s
 		confCopy := cm.defaultConfig.Copy()
 		assumeArn := fmt.Sprintf("arn:aws:iam::%s:role/%s", account, cm.role)
 		log.WithFields(log.Fields{"account": account, "role": cm.role, "assume_role_arn": assumeArn}).Debug("Configuring assume role provider")
-		confCopy.Credentials = stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cm.defaultConfig), assumeArn)
+		confCopy.Credentials = stscreds.NewAssumeRoleProvider(stsService, assumeArn)
 		cm.configsPerAccount[account] = confCopy
 	}
 
@@ -202,6 +252,16 @@ func (cm *ConfigurationManager) getConfigurationForAccount(account string) awsv2
 	return cm.configsPerAccount[account]
 }
 
+// hasConfigurationForAccount reports whether the manager holds credentials for the account, i.e.
+// it is the default account or a role was configured to assume into it.
+func (cm *ConfigurationManager) hasConfigurationForAccount(account string) bool {
+	if cm.defaultAccountID != nil && account == *cm.defaultAccountID {
+		return true
+	}
+	_, ok := cm.configsPerAccount[account]
+	return ok
+}
+
 func (cm *ConfigurationManager) getConfigurationForAccountAndRegion(account string, region string) awsv2.Config {
 	log.Debugf("getConfigurationForAccountAndRegion - Account: %s, Region: %s", account, region)
 	conf := cm.getConfigurationForAccount(account)
@@ -210,22 +270,84 @@ func (cm *ConfigurationManager) getConfigurationForAccountAndRegion(account stri
 	return conf
 }
 
+// SetEC2ClientFactory replaces the function used to build EC2 clients, e.g. to run against a fake.
+// Passing nil restores the default SDK client.
+func (cm *ConfigurationManager) SetEC2ClientFactory(factory EC2ClientFactory) {
+	cm.ec2ClientFactory = factory
+}
+
+// getEC2Client returns the cached EC2 client for the account and region, creating it on first use.
+func (cm *ConfigurationManager) getEC2Client(account string, region string) EC2API {
+	return cm.clients.ec2Client(clientKey{account: account, region: region}, func() EC2API {
+		return cm.newEC2Client(account, cm.getConfigurationForAccountAndRegion(account, region))
+	})
+}
+
+func (cm *ConfigurationManager) newEC2Client(account string, conf awsv2.Config) EC2API {
+	if cm.ec2ClientFactory == nil {
+		return newEC2Client(account, conf)
+	}
+	return cm.ec2ClientFactory(account, conf)
+}
+
+// getKMSClient returns the cached KMS client for the account and region, creating it on first use.
+func (cm *ConfigurationManager) getKMSClient(account string, region string) KMSAPI {
+	return cm.clients.kmsClient(clientKey{account: account, region: region}, func() KMSAPI {
+		return cm.newKMSClient(account, cm.getConfigurationForAccountAndRegion(account, region))
+	})
+}
+
+func (cm *ConfigurationManager) newKMSClient(account string, conf awsv2.Config) KMSAPI {
+	if cm.kmsClientFactory == nil {
+		return newKMSClient(account, conf)
+	}
+	return cm.kmsClientFactory(account, conf)
+}
+
+// getAutoScalingClient returns the cached Auto Scaling client for the account and region, creating
+// it on first use.
+func (cm *ConfigurationManager) getAutoScalingClient(account string, region string) AutoScalingAPI {
+	return cm.clients.autoScalingClient(clientKey{account: account, region: region}, func() AutoScalingAPI {
+		return cm.newAutoScalingClient(account, cm.getConfigurationForAccountAndRegion(account, region))
+	})
+}
+
+func (cm *ConfigurationManager) newAutoScalingClient(account string, conf awsv2.Config) AutoScalingAPI {
+	if cm.autoScalingClientFactory == nil {
+		return newAutoScalingClient(account, conf)
+	}
+	return cm.autoScalingClientFactory(account, conf)
+}
+
+// getSTSClient returns the cached STS client for the account and region, creating it on first use.
+func (cm *ConfigurationManager) getSTSClient(account string, region string) STSAPI {
+	return cm.clients.stsClient(clientKey{account: account, region: region}, func() STSAPI {
+		return cm.newSTSClient(account, cm.getConfigurationForAccountAndRegion(account, region))
+	})
+}
+
+func (cm *ConfigurationManager) newSTSClient(account string, conf awsv2.Config) STSAPI {
+	if cm.stsClientFactory == nil {
+		return newSTSClient(account, conf)
+	}
+	return cm.stsClientFactory(account, conf)
+}
+
 func (cm *ConfigurationManager) getAccounts() []string {
 	return cm.accounts
 }
 
 // AssumeDefaultAccountRole assumes an IAM role in the specified account and updates the manager's default configuration.
-func (cm *ConfigurationManager) AssumeDefaultAccountRole(account string, role string) error {
+func (cm *ConfigurationManager) AssumeDefaultAccountRole(ctx context.Context, account string, role string) error {
 	// Build new assumed role config based on current default
 	base := cm.GetConfigurationForDefaultAccount()
-	stsSvc := sts.NewFromConfig(base)
-	provider := stscreds.NewAssumeRoleProvider(stsSvc, fmt.Sprintf("arn:aws:iam::%s:role/%s", account, role))
+	provider := stscreds.NewAssumeRoleProvider(cm.getSTSClient(*cm.defaultAccountID, base.Region), fmt.Sprintf("arn:aws:iam::%s:role/%s", account, role))
 	assumed := base
 	assumed.Credentials = awsv2.NewCredentialsCache(provider)
 
 	// Verify identity
-	stsAssumed := sts.NewFromConfig(assumed)
-	id, err := stsAssumed.GetCallerIdentity(context.TODO(), &sts.GetCallerIdentityInput{})
+	stsAssumed := cm.newSTSClient(account, assumed)
+	id, err := stsAssumed.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
 	if err != nil {
 		return fmt.Errorf("failed to assume role %s in account %s: %w", role, account, err)
 	}
@@ -233,5 +355,11 @@ func (cm *ConfigurationManager) AssumeDefaultAccountRole(account string, role st
 	cm.defaultConfig = assumed
 	cm.defaultAccountID = id.Account
 	cm.role = role
+	// Clients built so far use the previous default credentials
+	cm.clients.reset()
+	// The client that verified the role is the STS client of the new default account
+	cm.clients.stsClient(clientKey{account: *id.Account, region: assumed.Region}, func() STSAPI {
+		return stsAssumed
+	})
 	return nil
 }
@@ -56,18 +56,18 @@ func TestNewConfigurationManagerDefaults(t *testing.T) {
 	// For a proper test, we would need to mock the credential chain or use test doubles
 
 	// Minimal smoke test: verify that NewConfigurationManager doesn't crash
-	cm, err := NewConfigurationManager()
+	cm, err := NewConfigurationManager(t.Context())
 
 	// The behavior depends on the environment:
 	// - If credentials are available, cm should be non-nil and err should be nil
 	// - If credentials are not available, cm should be nil and err should be non-nil
 
 	if err == nil && cm == nil {
-		t.Error("NewConfigurationManager() returned both nil cm and nil error")
+		t.Error("NewConfigurationManager(t.Context()) returned both nil cm and nil error")
 	}
 
 	if err != nil && cm != nil {
-		t.Error("NewConfigurationManager() returned both cm and error")
+		t.Error("NewConfigurationManager(t.Context()) returned both cm and error")
 	}
 }
 
@@ -0,0 +1,668 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/cloudnatives/aws-ami-manager/internal/ec2fake"
+)
+
+const (
+	testDefaultAccount = "111111111111"
+	testDefaultRegion  = "eu-west-1"
+)
+
+// useFakeEC2 installs a ConfigurationManager backed by an ec2fake.Backend for the duration of the
+// test.
+func useFakeEC2(t *testing.T, accounts []string) *ec2fake.Backend {
+	t.Helper()
+
+	backend := ec2fake.New(testDefaultAccount)
+	cm := &ConfigurationManager{
+		defaultRegion:     testDefaultRegion,
+		defaultAccountID:  awsv2.String(testDefaultAccount),
+		accounts:          accounts,
+		configsPerAccount: make(map[string]awsv2.Config),
+		kmsClientFactory: func(account string, conf awsv2.Config) KMSAPI {
+			return backend.KMS(account, conf.Region)
+		},
+		autoScalingClientFactory: func(account string, conf awsv2.Config) AutoScalingAPI {
+			return backend.AutoScaling(account, conf.Region)
+		},
+	}
+	cm.SetEC2ClientFactory(func(account string, conf awsv2.Config) EC2API {
+		return backend.EC2(account, conf.Region)
+	})
+
+	previous := ConfigManager
+	ConfigManager = cm
+	t.Cleanup(func() {
+		ConfigManager = previous
+	})
+
+	return backend
+}
+
+// callInputs returns the inputs of the calls to operation that account made in region, in order.
+func callInputs[T any](backend *ec2fake.Backend, account, region, operation string) []T {
+	var inputs []T
+	for _, call := range backend.Calls(operation) {
+		if call.Account == account && call.Region == region {
+			inputs = append(inputs, call.Input.(T))
+		}
+	}
+	return inputs
+}
+
+// deregistered returns the images account deregistered in region, in order.
+func deregistered(backend *ec2fake.Backend, account, region string) []string {
+	var ids []string
+	for _, input := range callInputs[*ec2.DeregisterImageInput](backend, account, region, "DeregisterImage") {
+		if _, ok := backend.Image(account, region, *input.ImageId); !ok {
+			ids = append(ids, *input.ImageId)
+		}
+	}
+	return ids
+}
+
+// deletedSnapshots returns the snapshots account deleted in region, in order.
+func deletedSnapshots(backend *ec2fake.Backend, account, region string) []string {
+	var ids []string
+	for _, input := range callInputs[*ec2.DeleteSnapshotInput](backend, account, region, "DeleteSnapshot") {
+		if !backend.SnapshotExists(*input.SnapshotId) {
+			ids = append(ids, *input.SnapshotId)
+		}
+	}
+	return ids
+}
+
+func testImage(id, name, creationDate string, tags map[string]string, snapshots ...string) ec2Types.Image {
+	image := ec2Types.Image{
+		ImageId:      awsv2.String(id),
+		Name:         awsv2.String(name),
+		State:        ec2Types.ImageStateAvailable,
+		CreationDate: awsv2.String(creationDate),
+	}
+	for key, value := range tags {
+		image.Tags = append(image.Tags, ec2Types.Tag{Key: awsv2.String(key), Value: awsv2.String(value)})
+	}
+	for _, snapshot := range snapshots {
+		image.BlockDeviceMappings = append(image.BlockDeviceMappings, ec2Types.BlockDeviceMapping{
+			Ebs: &ec2Types.EbsBlockDevice{SnapshotId: awsv2.String(snapshot)},
+		})
+	}
+	return image
+}
+
+// copyOf makes image a copy of the source AMI in sourceRegion.
+func copyOf(image ec2Types.Image, source, sourceRegion string) ec2Types.Image {
+	image.SourceImageId = awsv2.String(source)
+	image.SourceImageRegion = awsv2.String(sourceRegion)
+	return image
+}
+
+func TestCopyUsesInjectedClients(t *testing.T) {
+	consumer := "222222222222"
+	backend := useFakeEC2(t, []string{consumer})
+	backend.AddImage(testDefaultAccount, testDefaultRegion, testImage("ami-source", "golden", "2024-01-01T00:00:00.000Z", map[string]string{"Name": "golden"}))
+	// copy leaves the launch permissions of the source AMI alone, so the consumer can only see it
+	// when it was shared before
+	if _, err := backend.EC2(testDefaultAccount, testDefaultRegion).ModifyImageAttribute(t.Context(), &ec2.ModifyImageAttributeInput{
+		ImageId:          awsv2.String("ami-source"),
+		LaunchPermission: &ec2Types.LaunchPermissionModifications{Add: createLaunchPermissionsForOwners([]string{consumer})},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	ami := NewAmiWithRegions("ami-source", testDefaultRegion, []string{testDefaultRegion, "us-east-1"})
+	result, err := ami.Copy(t.Context(), CopyOptions{})
+	if err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+	if err := result.Err(); err != nil {
+		t.Fatalf("Copy() result error = %v", err)
+	}
+
+	copied := callInputs[*ec2.CopyImageInput](backend, testDefaultAccount, "us-east-1", "CopyImage")
+	if len(copied) != 1 {
+		t.Fatalf("CopyImage calls in us-east-1 = %d, want 1", len(copied))
+	}
+	if got := *copied[0].SourceImageId; got != "ami-source" {
+		t.Errorf("CopyImage SourceImageId = %q, want %q", got, "ami-source")
+	}
+	if got := len(callInputs[*ec2.CopyImageInput](backend, testDefaultAccount, testDefaultRegion, "CopyImage")); got != 0 {
+		t.Errorf("CopyImage calls in source region = %d, want 0", got)
+	}
+
+	copyID := result.Regions["us-east-1"].AmiID
+	if got := backend.LaunchPermissions(copyID); !slices.Equal(got, []string{consumer}) {
+		t.Errorf("launch permissions of %s = %v, want [%s]", copyID, got, consumer)
+	}
+
+	// consumer accounts get tags on both the source AMI and the regional copy
+	for region, amiID := range map[string]string{testDefaultRegion: "ami-source", "us-east-1": copyID} {
+		if image, _ := backend.Image(consumer, region, amiID); len(image.Tags) == 0 {
+			t.Errorf("%s in %s has no tags for %s", amiID, region, consumer)
+		}
+	}
+}
+
+func TestCopyTagsInCopyImageRequest(t *testing.T) {
+	tests := []struct {
+		name           string
+		opts           CopyOptions
+		wantTagAtCopy  bool
+		wantCreateTags int
+	}{
+		{name: "at copy", opts: CopyOptions{}, wantTagAtCopy: true},
+		{name: "after copy", opts: CopyOptions{TagAfterCopy: true}, wantCreateTags: 1},
+		{
+			name:           "template uses the copy id",
+			opts:           CopyOptions{TagRules: TagRules{Add: map[string]string{"Self": "{{.AmiID}}"}}},
+			wantCreateTags: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := useFakeEC2(t, nil)
+			backend.AddImage(testDefaultAccount, testDefaultRegion, testImage("ami-source", "golden", "2024-01-01T00:00:00.000Z", map[string]string{"Name": "golden"}))
+
+			ami := NewAmiWithRegions("ami-source", testDefaultRegion, []string{"us-east-1"})
+			result, err := ami.Copy(t.Context(), tt.opts)
+			if err != nil {
+				t.Fatalf("Copy() error = %v", err)
+			}
+			if err := result.Err(); err != nil {
+				t.Fatalf("Copy() result error = %v", err)
+			}
+
+			input := callInputs[*ec2.CopyImageInput](backend, testDefaultAccount, "us-east-1", "CopyImage")[0]
+			if got := input.CopyImageTags != nil && *input.CopyImageTags; got != tt.wantTagAtCopy {
+				t.Errorf("CopyImageTags = %v, want %v", got, tt.wantTagAtCopy)
+			}
+			if got := len(input.TagSpecifications) > 0; got != tt.wantTagAtCopy {
+				t.Errorf("TagSpecifications = %v, want them set: %v", input.TagSpecifications, tt.wantTagAtCopy)
+			}
+			if got := len(callInputs[*ec2.CreateTagsInput](backend, testDefaultAccount, "us-east-1", "CreateTags")); got != tt.wantCreateTags {
+				t.Errorf("CreateTags calls in us-east-1 = %d, want %d", got, tt.wantCreateTags)
+			}
+			if image, _ := backend.Image(testDefaultAccount, "us-east-1", result.Regions["us-east-1"].AmiID); len(image.Tags) == 0 {
+				t.Error("the copy has no tags")
+			}
+		})
+	}
+}
+
+func TestCopyReusesExistingCopies(t *testing.T) {
+	backend := useFakeEC2(t, nil)
+	backend.AddImage(testDefaultAccount, testDefaultRegion, testImage("ami-source", "golden", "2024-01-01T00:00:00.000Z", map[string]string{"Name": "golden"}, "snap-root"))
+
+	// the pending copy stays pending while the copies are looked up
+	backend.PendingPolls = 100
+	for _, image := range []ec2Types.Image{
+		copyOf(testImage("ami-older", "golden", "2024-01-15T00:00:00.000Z", nil), "ami-source", testDefaultRegion),
+		copyOf(testImage("ami-newer", "golden", "2024-02-01T00:00:00.000Z", nil), "ami-source", testDefaultRegion),
+		// the copies below are newer, but are not reused: available copies go first, failed copies
+		// and copies from another source region are left out
+		copyOf(testImage("ami-pending", "golden", "2024-03-01T00:00:00.000Z", nil), "ami-source", testDefaultRegion),
+		copyOf(testImage("ami-failed", "golden", "2024-04-01T00:00:00.000Z", nil), "ami-source", testDefaultRegion),
+		copyOf(testImage("ami-other-region", "golden", "2024-05-01T00:00:00.000Z", nil), "ami-source", "us-west-2"),
+		testImage("ami-other", "other", "2024-06-01T00:00:00.000Z", nil),
+	} {
+		switch *image.ImageId {
+		case "ami-pending":
+			image.State = ec2Types.ImageStatePending
+		case "ami-failed":
+			image.State = ec2Types.ImageStateFailed
+		}
+		backend.AddImage(testDefaultAccount, "us-east-1", image)
+	}
+
+	ami := NewAmiWithRegions("ami-source", testDefaultRegion, []string{"us-east-1"})
+	result, err := ami.Copy(t.Context(), CopyOptions{})
+	if err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+
+	regionResult := result.Regions["us-east-1"]
+	if !regionResult.Reused || regionResult.AmiID != "ami-newer" {
+		t.Errorf("us-east-1 result = %+v, want ami-newer reused", regionResult)
+	}
+	if got := len(callInputs[*ec2.CopyImageInput](backend, testDefaultAccount, "us-east-1", "CopyImage")); got != 0 {
+		t.Errorf("CopyImage calls = %d, want 0", got)
+	}
+	// a reused copy was not tagged by CopyImage, so it is tagged afterwards
+	if tagged := callInputs[*ec2.CreateTagsInput](backend, testDefaultAccount, "us-east-1", "CreateTags"); len(tagged) != 1 || tagged[0].Resources[0] != "ami-newer" {
+		t.Errorf("CreateTags calls = %+v, want the reused copy tagged", tagged)
+	}
+}
+
+func TestCopyDoesNotReuseUnencryptedCopiesForEncryptedCopies(t *testing.T) {
+	backend := useFakeEC2(t, nil)
+	backend.AddImage(testDefaultAccount, testDefaultRegion, testImage("ami-source", "golden", "2024-01-01T00:00:00.000Z", nil, "snap-root"))
+	backend.AddImage(testDefaultAccount, "us-east-1", copyOf(testImage("ami-plain", "golden-plain", "2024-02-01T00:00:00.000Z", nil, "snap-plain"), "ami-source", testDefaultRegion))
+
+	ami := NewAmiWithRegions("ami-source", testDefaultRegion, []string{"us-east-1"})
+	result, err := ami.Copy(t.Context(), CopyOptions{Encrypted: true})
+	if err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+	if err := result.Err(); err != nil {
+		t.Fatalf("Copy() result error = %v", err)
+	}
+	regionResult := result.Regions["us-east-1"]
+	if copied, _ := backend.Image(testDefaultAccount, "us-east-1", regionResult.AmiID); regionResult.Reused || !isEncrypted(&copied) {
+		t.Errorf("us-east-1 = %+v, want a new encrypted copy", regionResult)
+	}
+}
+
+func TestCopyDoesNotReuseCopiesEncryptedWithAnotherKey(t *testing.T) {
+	backend := useFakeEC2(t, nil)
+	backend.AddImage(testDefaultAccount, testDefaultRegion, testImage("ami-source", "golden", "2024-01-01T00:00:00.000Z", nil, "snap-root"))
+
+	// the copy with the other key is newer, so it would be reused if the key was not checked
+	for id, key := range map[string]string{
+		"ami-same-key":  backend.AddKey(testDefaultAccount, "us-east-1", "alias/ami"),
+		"ami-other-key": backend.AddKey(testDefaultAccount, "us-east-1", "alias/other"),
+	} {
+		created := "2024-02-01T00:00:00.000Z"
+		if id == "ami-other-key" {
+			created = "2024-03-01T00:00:00.000Z"
+		}
+		existing := copyOf(testImage(id, "golden", created, nil, "snap-"+id), "ami-source", testDefaultRegion)
+		existing.BlockDeviceMappings[0].Ebs.Encrypted = awsv2.Bool(true)
+		existing.BlockDeviceMappings[0].Ebs.KmsKeyId = awsv2.String(key)
+		backend.AddImage(testDefaultAccount, "us-east-1", existing)
+	}
+
+	ami := NewAmiWithRegions("ami-source", testDefaultRegion, []string{"us-east-1"})
+	result, err := ami.Copy(t.Context(), CopyOptions{KmsKeyIDs: map[string]string{"us-east-1": "alias/ami"}})
+	if err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+	copied := callInputs[*ec2.CopyImageInput](backend, testDefaultAccount, "us-east-1", "CopyImage")
+	if got := result.Regions["us-east-1"]; !got.Reused || got.AmiID != "ami-same-key" || len(copied) != 0 {
+		t.Errorf("us-east-1 = %+v, CopyImage calls = %d, want ami-same-key reused", got, len(copied))
+	}
+}
+
+func TestCopyReportsRegionFailuresWithoutStoppingOthers(t *testing.T) {
+	backend := useFakeEC2(t, nil)
+	backend.AddImage(testDefaultAccount, testDefaultRegion, testImage("ami-source", "golden", "2024-01-01T00:00:00.000Z", nil))
+	backend.SetError(testDefaultAccount, "us-west-2", "CopyImage", errors.New("ResourceLimitExceeded"))
+
+	ami := NewAmiWithRegions("ami-source", testDefaultRegion, []string{"us-east-1", "us-west-2"})
+	result, err := ami.Copy(t.Context(), CopyOptions{})
+	if err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+
+	if failed := result.FailedRegions(); len(failed) != 1 || failed[0] != "us-west-2" {
+		t.Fatalf("FailedRegions() = %v, want [us-west-2]", failed)
+	}
+	if result.Regions["us-west-2"].CopyErr == nil {
+		t.Error("us-west-2 CopyErr = nil, want error")
+	}
+	if ok := result.Regions["us-east-1"]; ok.Failed() || ok.AmiID == "" {
+		t.Errorf("us-east-1 result = %+v, want a successful copy", ok)
+	}
+	if err := result.Err(); err == nil || !strings.Contains(err.Error(), "region us-west-2") {
+		t.Errorf("Err() = %v, want error mentioning region us-west-2", err)
+	}
+}
+
+func TestCopyStopsWaitingWhenCancelled(t *testing.T) {
+	backend := useFakeEC2(t, nil)
+	backend.AddImage(testDefaultAccount, testDefaultRegion, testImage("ami-source", "golden", "2024-01-01T00:00:00.000Z", nil))
+	backend.PendingPolls = 1 << 20
+
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	ami := NewAmiWithRegions("ami-source", testDefaultRegion, []string{"us-east-1"})
+	result, err := ami.Copy(ctx, CopyOptions{})
+	if err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Copy() took %s after cancellation, want it to stop polling right away", elapsed)
+	}
+
+	regionResult := result.Regions["us-east-1"]
+	if !errors.Is(regionResult.CopyErr, context.DeadlineExceeded) {
+		t.Errorf("CopyErr = %v, want context.DeadlineExceeded", regionResult.CopyErr)
+	}
+	if regionResult.AmiID == "" {
+		t.Error("AmiID is empty, want the ID of the copy that was already started")
+	}
+}
+
+// slowCopyEC2 calls onCopy at the start of every CopyImage call.
+type slowCopyEC2 struct {
+	*ec2fake.Client
+	onCopy func()
+}
+
+func (c *slowCopyEC2) CopyImage(ctx context.Context, params *ec2.CopyImageInput, optFns ...func(*ec2.Options)) (*ec2.CopyImageOutput, error) {
+	c.onCopy()
+	return c.Client.CopyImage(ctx, params, optFns...)
+}
+
+func TestCopyLimitsConcurrentRegions(t *testing.T) {
+	backend := useFakeEC2(t, nil)
+	backend.AddImage(testDefaultAccount, testDefaultRegion, testImage("ami-source", "golden", "2024-01-01T00:00:00.000Z", nil))
+
+	var active, maxActive atomic.Int32
+	onCopy := func() {
+		now := active.Add(1)
+		for {
+			highest := maxActive.Load()
+			if now <= highest || maxActive.CompareAndSwap(highest, now) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		active.Add(-1)
+	}
+	ConfigManager.SetEC2ClientFactory(func(account string, conf awsv2.Config) EC2API {
+		return &slowCopyEC2{Client: backend.EC2(account, conf.Region), onCopy: onCopy}
+	})
+
+	regions := []string{"us-east-1", "us-east-2", "us-west-1", "us-west-2", "eu-central-1"}
+	ami := NewAmiWithRegions("ami-source", testDefaultRegion, regions)
+	result, err := ami.Copy(t.Context(), CopyOptions{MaxConcurrency: 2})
+	if err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+	if failed := result.FailedRegions(); len(failed) != 0 {
+		t.Fatalf("FailedRegions() = %v, want none", failed)
+	}
+	if got := maxActive.Load(); got < 1 || got > 2 {
+		t.Errorf("copies at the same time = %d, want at most 2", got)
+	}
+}
+
+func TestCopyOwnedCopies(t *testing.T) {
+	consumer := "222222222222"
+	backend := useFakeEC2(t, []string{testDefaultAccount, consumer})
+	backend.AddImage(testDefaultAccount, testDefaultRegion, testImage("ami-source", "golden", "2024-01-01T00:00:00.000Z", map[string]string{"Name": "golden"}, "snap-root"))
+
+	// an earlier run already copied the AMI to us-east-1 in the consumer account
+	backend.AddImage(consumer, "us-east-1", copyOf(testImage("ami-earlier", "golden", "2024-02-01T00:00:00.000Z", nil), "ami-source", testDefaultRegion))
+
+	ami := NewAmiWithRegions("ami-source", testDefaultRegion, []string{testDefaultRegion, "us-east-1"})
+	result, err := ami.Copy(t.Context(), CopyOptions{Mode: CopyModeOwnedCopy})
+	if err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+	if err := result.Err(); err != nil {
+		t.Fatalf("Copy() result error = %v", err)
+	}
+
+	// the source and its snapshots are shared with the consumer only
+	if got := backend.LaunchPermissions("ami-source"); !slices.Equal(got, []string{consumer}) {
+		t.Errorf("launch permissions of the source = %v, want [%s]", got, consumer)
+	}
+	if got := backend.SnapshotPermissions("snap-root"); !slices.Equal(got, []string{consumer}) {
+		t.Errorf("createVolumePermission of snap-root = %v, want [%s]", got, consumer)
+	}
+
+	for _, region := range []string{testDefaultRegion, "us-east-1"} {
+		if got := len(callInputs[*ec2.CopyImageInput](backend, testDefaultAccount, region, "CopyImage")); got != 0 {
+			t.Errorf("CopyImage calls of the default account in %s = %d, want 0", region, got)
+		}
+		regionResult := result.Regions[region]
+		if regionResult.AmiID != "" || len(regionResult.AccountCopies) != 1 || regionResult.AccountCopies[0].Account != consumer {
+			t.Errorf("%s result = %+v, want a single copy by %s", region, regionResult, consumer)
+		}
+	}
+
+	sourceRegion := result.Regions[testDefaultRegion].AccountCopies[0]
+	owned := callInputs[*ec2.CopyImageInput](backend, consumer, testDefaultRegion, "CopyImage")
+	if len(owned) != 1 || sourceRegion.AmiID == "" || sourceRegion.Reused {
+		t.Fatalf("CopyImage calls in the consumer account = %d, result = %+v, want a new copy", len(owned), sourceRegion)
+	}
+	input := owned[0]
+	if copyTags := awsv2.ToBool(input.CopyImageTags); copyTags || len(input.TagSpecifications) != 2 {
+		t.Errorf("CopyImageTags = %v, TagSpecifications = %+v, want the tags set explicitly", copyTags, input.TagSpecifications)
+	}
+	if copied, ok := backend.Image(consumer, testDefaultRegion, sourceRegion.AmiID); !ok || awsv2.ToString(copied.Name) != "golden" || len(copied.Tags) == 0 {
+		t.Errorf("copy in the consumer account = %+v, want it named golden and tagged", copied)
+	}
+
+	reused := result.Regions["us-east-1"].AccountCopies[0]
+	if !reused.Reused || reused.AmiID != "ami-earlier" {
+		t.Errorf("us-east-1 copy = %+v, want ami-earlier reused", reused)
+	}
+	if tagged := callInputs[*ec2.CreateTagsInput](backend, consumer, "us-east-1", "CreateTags"); len(tagged) != 1 || tagged[0].Resources[0] != "ami-earlier" {
+		t.Errorf("CreateTags calls in us-east-1 = %+v, want the reused copy tagged", tagged)
+	}
+}
+
+func TestCopyOwnedCopiesNeedTargetAccounts(t *testing.T) {
+	backend := useFakeEC2(t, []string{testDefaultAccount})
+	backend.AddImage(testDefaultAccount, testDefaultRegion, testImage("ami-source", "golden", "2024-01-01T00:00:00.000Z", nil))
+
+	ami := NewAmiWithRegions("ami-source", testDefaultRegion, []string{"us-east-1"})
+	if _, err := ami.Copy(t.Context(), CopyOptions{Mode: CopyModeOwnedCopy}); err == nil {
+		t.Error("Copy() without target accounts error = nil, want an error")
+	}
+	if _, err := ami.Copy(t.Context(), CopyOptions{Mode: CopyModeOwnedCopy, NoWait: true}); err == nil {
+		t.Error("Copy() of owned copies without waiting error = nil, want an error")
+	}
+	if _, err := ami.Copy(t.Context(), CopyOptions{Mode: CopyModeOwnedCopy, DeregisterFailedCopies: true, CopyRetries: 1}); err == nil {
+		t.Error("Copy() of owned copies with retries error = nil, want an error")
+	}
+	if _, err := ami.Copy(t.Context(), CopyOptions{Mode: "mirror"}); err == nil {
+		t.Error("Copy() with an unknown mode error = nil, want an error")
+	}
+}
+
+func TestCopyMissingSourceAmi(t *testing.T) {
+	useFakeEC2(t, nil)
+
+	ami := NewAmiWithRegions("ami-missing", testDefaultRegion, []string{"us-east-1"})
+	if _, err := ami.Copy(t.Context(), CopyOptions{}); err == nil {
+		t.Fatal("Copy() error = nil, want error for missing source AMI")
+	}
+}
+
+func TestRemoveAmiDeletesImageAndSnapshots(t *testing.T) {
+	backend := useFakeEC2(t, nil)
+	backend.AddImage(testDefaultAccount, testDefaultRegion, testImage("ami-old", "old", "2024-01-01T00:00:00.000Z", nil, "snap-1", "snap-2"))
+
+	ami := NewAmi("ami-old")
+	ami.SourceRegion = testDefaultRegion
+
+	if err := ami.RemoveAmi(t.Context(), false); err != nil {
+		t.Fatalf("RemoveAmi() error = %v", err)
+	}
+	if got := deregistered(backend, testDefaultAccount, testDefaultRegion); !slices.Equal(got, []string{"ami-old"}) {
+		t.Errorf("deregistered = %v, want [ami-old]", got)
+	}
+	if got := deletedSnapshots(backend, testDefaultAccount, testDefaultRegion); !slices.Equal(got, []string{"snap-1", "snap-2"}) {
+		t.Errorf("deleted snapshots = %v, want [snap-1 snap-2]", got)
+	}
+}
+
+func TestRemoveAmiDryRunMakesNoChanges(t *testing.T) {
+	backend := useFakeEC2(t, nil)
+	backend.AddImage(testDefaultAccount, testDefaultRegion, testImage("ami-old", "old", "2024-01-01T00:00:00.000Z", nil, "snap-1"))
+
+	ami := NewAmi("ami-old")
+	ami.SourceRegion = testDefaultRegion
+
+	if err := ami.RemoveAmi(t.Context(), true); err != nil {
+		t.Fatalf("RemoveAmi() error = %v", err)
+	}
+	if _, ok := backend.Image(testDefaultAccount, testDefaultRegion, "ami-old"); !ok || !backend.SnapshotExists("snap-1") {
+		t.Errorf("dry run made changes: image kept = %v, snapshot kept = %v", ok, backend.SnapshotExists("snap-1"))
+	}
+}
+
+func TestRemoveAmiMissingImage(t *testing.T) {
+	useFakeEC2(t, nil)
+
+	ami := NewAmi("ami-missing")
+	ami.SourceRegion = testDefaultRegion
+
+	if err := ami.RemoveAmi(t.Context(), false); err == nil {
+		t.Fatal("RemoveAmi() error = nil, want error for missing AMI")
+	}
+}
+
+func TestCleanupKeepsNewestVersions(t *testing.T) {
+	backend := useFakeEC2(t, nil)
+	tags := map[string]string{"Name": "golden"}
+	backend.AddImage(testDefaultAccount, testDefaultRegion, testImage("ami-1", "golden-1", "2024-01-01T00:00:00.000Z", tags, "snap-1"))
+	backend.AddImage(testDefaultAccount, testDefaultRegion, testImage("ami-2", "golden-2", "2024-02-01T00:00:00.000Z", tags, "snap-2"))
+	backend.AddImage(testDefaultAccount, testDefaultRegion, testImage("ami-3", "golden-3", "2024-03-01T00:00:00.000Z", tags, "snap-3"))
+	// the tag filter leaves out versions of other AMIs
+	backend.AddImage(testDefaultAccount, testDefaultRegion, testImage("ami-other", "other", "2023-01-01T00:00:00.000Z", map[string]string{"Name": "other"}, "snap-other"))
+
+	ami := NewAmi("ami-3")
+	ami.SourceRegion = testDefaultRegion
+
+	result, err := ami.Cleanup(t.Context(), []string{testDefaultRegion}, CleanupOptions{Tags: TagFilters{{Key: "Name", FromReference: true}}, Retention: RetentionPolicy{KeepNewest: 2}})
+	if err != nil {
+		t.Fatalf("Cleanup() error = %v", err)
+	}
+	if got := deregistered(backend, testDefaultAccount, testDefaultRegion); !slices.Equal(got, []string{"ami-1"}) {
+		t.Errorf("deregistered = %v, want [ami-1]", got)
+	}
+	regionResult := result.Regions[testDefaultRegion]
+	if kept := regionResult.AmiIDs(CleanupActionKeep); len(kept) != 2 || kept[0] != "ami-3" || kept[1] != "ami-2" {
+		t.Errorf("kept = %v, want [ami-3 ami-2]", kept)
+	}
+	if len(regionResult.Images) != 3 || !regionResult.Images[2].Deregistered {
+		t.Errorf("images = %+v, want ami-1 deregistered", regionResult.Images)
+	}
+	if got := deletedSnapshots(backend, testDefaultAccount, testDefaultRegion); !slices.Equal(got, []string{"snap-1"}) {
+		t.Errorf("deleted snapshots = %v, want [snap-1]", got)
+	}
+}
+
+func TestCleanupRejectsImagesWithoutCreationDate(t *testing.T) {
+	backend := useFakeEC2(t, nil)
+	tags := map[string]string{"Name": "golden"}
+	backend.AddImage(testDefaultAccount, testDefaultRegion, testImage("ami-1", "golden-1", "2024-01-01T00:00:00.000Z", tags, "snap-1"))
+	backend.AddImage(testDefaultAccount, testDefaultRegion, testImage("ami-2", "golden-2", "2024-02-01T00:00:00.000Z", tags, "snap-2"))
+	undated := testImage("ami-undated", "golden-undated", "", tags, "snap-undated")
+	undated.CreationDate = nil
+	backend.AddImage(testDefaultAccount, testDefaultRegion, undated)
+
+	ami := NewAmi("ami-2")
+	ami.SourceRegion = testDefaultRegion
+
+	if _, err := ami.Cleanup(t.Context(), []string{testDefaultRegion}, CleanupOptions{Tags: TagFilters{{Key: "Name", FromReference: true}}, Retention: RetentionPolicy{KeepNewest: 1}}); err == nil {
+		t.Fatal("Cleanup() error = nil, want an error for the image without a creation date")
+	}
+	if got := deregistered(backend, testDefaultAccount, testDefaultRegion); len(got) != 0 {
+		t.Errorf("deregistered = %v, want nothing", got)
+	}
+}
+
+func TestCleanupPaginatesAndSkipsImagesOfOtherAccounts(t *testing.T) {
+	backend := useFakeEC2(t, nil)
+	backend.PageSize = 1
+	tags := map[string]string{"Name": "golden"}
+	backend.AddImage(testDefaultAccount, testDefaultRegion, testImage("ami-1", "golden-1", "2024-01-01T00:00:00.000Z", tags, "snap-1"))
+	backend.AddImage(testDefaultAccount, testDefaultRegion, testImage("ami-2", "golden-2", "2024-02-01T00:00:00.000Z", tags, "snap-2"))
+	backend.AddImage(testDefaultAccount, testDefaultRegion, testImage("ami-3", "golden-3", "2024-03-01T00:00:00.000Z", tags, "snap-3"))
+	public := testImage("ami-public", "golden-public", "2023-01-01T00:00:00.000Z", nil, "snap-public")
+	public.Public = awsv2.Bool(true)
+	backend.AddImage("333333333333", testDefaultRegion, public)
+	// tags are per account, so the image of the other account matches the tag filter through the
+	// tags the default account gave it
+	if _, err := backend.EC2(testDefaultAccount, testDefaultRegion).CreateTags(t.Context(), &ec2.CreateTagsInput{
+		Resources: []string{"ami-public"},
+		Tags:      []ec2Types.Tag{{Key: awsv2.String("Name"), Value: awsv2.String("golden")}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	ami := NewAmi("ami-3")
+	ami.SourceRegion = testDefaultRegion
+	opts := CleanupOptions{Tags: TagFilters{{Key: "Name", FromReference: true}}, Retention: RetentionPolicy{KeepNewest: 1}, DryRun: true}
+	result, err := ami.Cleanup(t.Context(), []string{testDefaultRegion}, opts)
+	if err != nil {
+		t.Fatalf("Cleanup() error = %v", err)
+	}
+	described := callInputs[*ec2.DescribeImagesInput](backend, testDefaultAccount, testDefaultRegion, "DescribeImages")
+	if calls := len(described); calls < 4 {
+		t.Errorf("DescribeImages calls = %d, want one per page", calls)
+	}
+	if last := described[len(described)-1]; strings.Join(last.Owners, ",") != "self" {
+		t.Errorf("Owners = %v, want [self]", last.Owners)
+	}
+	if planned := result.Regions[testDefaultRegion].AmiIDs(CleanupActionDeregister); strings.Join(planned, ",") != "ami-2,ami-1" {
+		t.Errorf("planned for deregistration = %v, want [ami-2 ami-1] from every page", planned)
+	}
+
+	// with another owner, its images are reported but never deregistered
+	opts.Owners = []string{"self", "333333333333"}
+	opts.DryRun = false
+	result, err = ami.Cleanup(t.Context(), []string{testDefaultRegion}, opts)
+	if err != nil {
+		t.Fatalf("Cleanup() error = %v", err)
+	}
+	if got := deregistered(backend, testDefaultAccount, testDefaultRegion); strings.Join(got, ",") != "ami-2,ami-1" {
+		t.Errorf("deregistered = %v, want [ami-2 ami-1]", got)
+	}
+	images := result.Regions[testDefaultRegion].Images
+	skipped := images[len(images)-1]
+	if skipped.AmiID != "ami-public" || skipped.Action != CleanupActionSkip || skipped.OwnerID != "333333333333" {
+		t.Errorf("ami-public = %+v, want it skipped as owned by 333333333333", skipped)
+	}
+	if _, ok := backend.Image("333333333333", testDefaultRegion, "ami-public"); !ok {
+		t.Error("the image of another account was deregistered")
+	}
+}
+
+func TestCleanupDryRunPlansWithoutChanges(t *testing.T) {
+	backend := useFakeEC2(t, nil)
+	tags := map[string]string{"Name": "golden"}
+	backend.AddImage(testDefaultAccount, testDefaultRegion, testImage("ami-1", "golden-1", "2024-01-01T00:00:00.000Z", tags, "snap-1a", "snap-1b"))
+	backend.AddImage(testDefaultAccount, testDefaultRegion, testImage("ami-2", "golden-2", "2024-02-01T00:00:00.000Z", map[string]string{"Name": "golden", "Release": "2.0"}, "snap-2"))
+	backend.AddImage(testDefaultAccount, testDefaultRegion, testImage("ami-3", "golden-3", "2024-03-01T00:00:00.000Z", tags, "snap-3"))
+
+	ami := NewAmi("ami-3")
+	ami.SourceRegion = testDefaultRegion
+	result, err := ami.Cleanup(t.Context(), []string{testDefaultRegion}, CleanupOptions{
+		Tags:        TagFilters{{Key: "Name", FromReference: true}},
+		Retention:   RetentionPolicy{KeepNewest: 1},
+		ProtectTags: map[string]string{"Release": ""},
+		DryRun:      true,
+	})
+	if err != nil {
+		t.Fatalf("Cleanup() error = %v", err)
+	}
+	if len(backend.Calls("DeregisterImage")) != 0 || len(backend.Calls("DeleteSnapshot")) != 0 {
+		t.Errorf("dry run deregistered images or deleted snapshots: %+v", backend.Calls(""))
+	}
+
+	images := result.Regions[testDefaultRegion].Images
+	if len(images) != 3 {
+		t.Fatalf("images = %+v, want 3", images)
+	}
+	if images[0].AmiID != "ami-3" || images[0].Reason != CleanupReasonNewest {
+		t.Errorf("images[0] = %+v, want ami-3 kept as newest", images[0])
+	}
+	if images[1].AmiID != "ami-2" || images[1].Reason != CleanupReasonProtected || images[1].ProtectTag != "Release=2.0" {
+		t.Errorf("images[1] = %+v, want ami-2 protected by Release=2.0", images[1])
+	}
+	if images[2].AmiID != "ami-1" || images[2].Action != CleanupActionDeregister || images[2].Deregistered ||
+		strings.Join(images[2].SnapshotIDs, ",") != "snap-1a,snap-1b" {
+		t.Errorf("images[2] = %+v, want ami-1 planned for deregistration with snap-1a and snap-1b", images[2])
+	}
+}
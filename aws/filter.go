@@ -0,0 +1,154 @@
+package aws
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// TagFilter selects AMIs on the value of a single tag. It is written as [!]key[=value]:
+//
+//	Name          the value of Name on the reference AMI
+//	Name=golden   exactly golden
+//	Name=golden-* any value starting with golden-, with the * and ? wildcards of EC2 filters
+//	Name=*        any value, i.e. the AMI has the tag
+//	!Stage=test   any value but test, or no Stage tag at all
+//	!Stage=*      no Stage tag
+type TagFilter struct {
+	Key string
+	// Value is matched with the * and ? wildcards. It is set from the reference AMI when
+	// FromReference is set.
+	Value string
+	// FromReference takes Value from the tag with Key on the reference AMI.
+	FromReference bool
+	// Negate selects the AMIs that do not match.
+	Negate bool
+}
+
+// TagFilters selects the AMIs that match every filter.
+type TagFilters []TagFilter
+
+// ParseTagFilter parses a filter written as [!]key[=value].
+func ParseTagFilter(expression string) (TagFilter, error) {
+	var filter TagFilter
+	rest, negate := strings.CutPrefix(strings.TrimSpace(expression), "!")
+	key, value, hasValue := strings.Cut(rest, "=")
+	filter.Key = strings.TrimSpace(key)
+	filter.Value = strings.TrimSpace(value)
+	filter.FromReference = !hasValue
+	filter.Negate = negate
+	if filter.Key == "" {
+		return filter, fmt.Errorf("invalid tag filter %q: expected [!]key[=value]", expression)
+	}
+	return filter, nil
+}
+
+// ParseTagFilters parses every expression with ParseTagFilter.
+func ParseTagFilters(expressions []string) (TagFilters, error) {
+	filters := make(TagFilters, 0, len(expressions))
+	for _, expression := range expressions {
+		filter, err := ParseTagFilter(expression)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+	return filters, nil
+}
+
+// String returns the filter as ParseTagFilter reads it.
+func (f TagFilter) String() string {
+	expression := f.Key
+	if f.Negate {
+		expression = "!" + expression
+	}
+	if f.FromReference {
+		return expression
+	}
+	return expression + "=" + f.Value
+}
+
+// resolve returns the filters with the values taken from the tags of the reference AMI. It returns
+// an error listing every key the reference AMI has no tag for.
+func (f TagFilters) resolve(reference []ec2Types.Tag) (TagFilters, error) {
+	tags := convertTagSliceToMap(reference)
+	resolved := make(TagFilters, 0, len(f))
+	var missing []string
+	for _, filter := range f {
+		if filter.FromReference {
+			tag, ok := tags[filter.Key]
+			if !ok {
+				missing = append(missing, filter.Key)
+				continue
+			}
+			filter.Value = aws.ToString(tag.Value)
+			filter.FromReference = false
+		}
+		resolved = append(resolved, filter)
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("the reference AMI has no tag %s to filter on", strings.Join(missing, ", "))
+	}
+	return resolved, nil
+}
+
+// ec2Filters returns the DescribeImages filters for the filters that are not negated. EC2 filters
+// cannot negate, so the negated filters are left to matches.
+func (f TagFilters) ec2Filters() []ec2Types.Filter {
+	var filters []ec2Types.Filter
+	for _, filter := range f {
+		if filter.Negate {
+			continue
+		}
+		filters = append(filters, ec2Types.Filter{
+			Name:   aws.String("tag:" + filter.Key),
+			Values: []string{filter.Value},
+		})
+	}
+	return filters
+}
+
+// matches reports whether tags match every filter.
+func (f TagFilters) matches(tags []ec2Types.Tag) bool {
+	tagMap := convertTagSliceToMap(tags)
+	for _, filter := range f {
+		tag, ok := tagMap[filter.Key]
+		matched := ok && wildcardMatch(filter.Value, aws.ToString(tag.Value))
+		if matched == filter.Negate {
+			return false
+		}
+	}
+	return true
+}
+
+// wildcardMatch reports whether value matches pattern, in which * matches any number of characters
+// and ? a single character, like in EC2 filters. It backtracks only to the last *, so it runs in
+// O(len(pattern) * len(value)).
+func wildcardMatch(pattern string, value string) bool {
+	p, v := []rune(pattern), []rune(value)
+	pi, vi := 0, 0
+	// star is the index of the last * seen in p, and starValue where in v it started matching
+	star, starValue := -1, 0
+	for vi < len(v) {
+		switch {
+		case pi < len(p) && p[pi] == '*':
+			star, starValue = pi, vi
+			pi++
+		case pi < len(p) && (p[pi] == '?' || p[pi] == v[vi]):
+			pi++
+			vi++
+		case star >= 0:
+			// let the last * match one more character and retry from there
+			starValue++
+			pi, vi = star+1, starValue
+		default:
+			return false
+		}
+	}
+	for pi < len(p) && p[pi] == '*' {
+		pi++
+	}
+	return pi == len(p)
+}
@@ -0,0 +1,157 @@
+package aws
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func TestParseTagFilter(t *testing.T) {
+	tests := []struct {
+		expression string
+		want       TagFilter
+		wantErr    bool
+	}{
+		{expression: "Name", want: TagFilter{Key: "Name", FromReference: true}},
+		{expression: "Name=golden", want: TagFilter{Key: "Name", Value: "golden"}},
+		{expression: " Name = golden-* ", want: TagFilter{Key: "Name", Value: "golden-*"}},
+		{expression: "Name=", want: TagFilter{Key: "Name"}},
+		{expression: "!Stage=test", want: TagFilter{Key: "Stage", Value: "test", Negate: true}},
+		{expression: "!Stage", want: TagFilter{Key: "Stage", FromReference: true, Negate: true}},
+		{expression: "aws:cloudformation:stack-name=ami-*", want: TagFilter{Key: "aws:cloudformation:stack-name", Value: "ami-*"}},
+		{expression: "=golden", wantErr: true},
+		{expression: "!", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseTagFilter(tt.expression)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseTagFilter(%q) error = %v, wantErr %v", tt.expression, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("ParseTagFilter(%q) = %+v, want %+v", tt.expression, got, tt.want)
+		}
+	}
+}
+
+func TestTagFiltersResolve(t *testing.T) {
+	reference := testImage("ami-reference", "golden", "2024-01-01T00:00:00.000Z", map[string]string{"Name": "golden", "Team": "platform"}).Tags
+	filters := TagFilters{{Key: "Name", FromReference: true}, {Key: "Stage", Value: "test", Negate: true}}
+
+	resolved, err := filters.resolve(reference)
+	if err != nil {
+		t.Fatalf("resolve() error = %v", err)
+	}
+	want := TagFilters{{Key: "Name", Value: "golden"}, {Key: "Stage", Value: "test", Negate: true}}
+	if !reflect.DeepEqual(resolved, want) {
+		t.Errorf("resolve() = %+v, want %+v", resolved, want)
+	}
+
+	// every filter of the EC2 request has exactly the value to match
+	wantEC2 := []ec2Types.Filter{{Name: awsv2.String("tag:Name"), Values: []string{"golden"}}}
+	if got := resolved.ec2Filters(); !reflect.DeepEqual(got, wantEC2) {
+		t.Errorf("ec2Filters() = %+v, want %+v", got, wantEC2)
+	}
+
+	missing := TagFilters{{Key: "Name", FromReference: true}, {Key: "Release", FromReference: true}, {Key: "Owner", FromReference: true}}
+	if _, err := missing.resolve(reference); err == nil || err.Error() != "the reference AMI has no tag Release, Owner to filter on" {
+		t.Errorf("resolve() error = %v, want the missing Release and Owner tags", err)
+	}
+}
+
+func TestTagFiltersMatches(t *testing.T) {
+	tags := testImage("ami-1", "golden", "2024-01-01T00:00:00.000Z", map[string]string{"Name": "golden-2024", "Stage": "prod", "Empty": ""}).Tags
+
+	tests := []struct {
+		name    string
+		filters TagFilters
+		want    bool
+	}{
+		{name: "no filters", filters: nil, want: true},
+		{name: "exact", filters: TagFilters{{Key: "Name", Value: "golden-2024"}}, want: true},
+		{name: "other value", filters: TagFilters{{Key: "Name", Value: "golden"}}, want: false},
+		{name: "wildcard", filters: TagFilters{{Key: "Name", Value: "golden-*"}}, want: true},
+		{name: "single character wildcard", filters: TagFilters{{Key: "Name", Value: "golden-202?"}}, want: true},
+		{name: "exists", filters: TagFilters{{Key: "Stage", Value: "*"}}, want: true},
+		{name: "exists with an empty value", filters: TagFilters{{Key: "Empty", Value: "*"}}, want: true},
+		{name: "empty value", filters: TagFilters{{Key: "Empty", Value: ""}}, want: true},
+		{name: "empty value does not match any value", filters: TagFilters{{Key: "Stage", Value: ""}}, want: false},
+		{name: "missing", filters: TagFilters{{Key: "Team", Value: "*"}}, want: false},
+		{name: "negated", filters: TagFilters{{Key: "Stage", Value: "test", Negate: true}}, want: true},
+		{name: "negated match", filters: TagFilters{{Key: "Stage", Value: "prod", Negate: true}}, want: false},
+		{name: "negated missing tag", filters: TagFilters{{Key: "Team", Value: "*", Negate: true}}, want: true},
+		{name: "every filter", filters: TagFilters{{Key: "Name", Value: "golden-*"}, {Key: "Stage", Value: "prod", Negate: true}}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filters.matches(tags); got != tt.want {
+				t.Errorf("matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWildcardMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		value   string
+		want    bool
+	}{
+		{pattern: "", value: "", want: true},
+		{pattern: "", value: "a", want: false},
+		{pattern: "*", value: "", want: true},
+		{pattern: "golden-*-prod", value: "golden-2024-prod", want: true},
+		{pattern: "*-*-*", value: "golden-2024-prod", want: true},
+		{pattern: "*-*-*", value: "golden-2024", want: false},
+		{pattern: "g*n*4", value: "golden-2024", want: true},
+		{pattern: "g*n*5", value: "golden-2024", want: false},
+		{pattern: "**a**", value: "a", want: true},
+		// ? matches a single character, not a single byte
+		{pattern: "caf?", value: "café", want: true},
+		{pattern: "caf??", value: "café", want: false},
+		{pattern: "?-*", value: "é-1", want: true},
+		// many *s that cannot match must not take exponential time
+		{pattern: strings.Repeat("*a", 30) + "b", value: strings.Repeat("a", 60), want: false},
+	}
+	for _, tt := range tests {
+		if got := wildcardMatch(tt.pattern, tt.value); got != tt.want {
+			t.Errorf("wildcardMatch(%q, %q) = %v, want %v", tt.pattern, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestCleanupAppliesTagFilters(t *testing.T) {
+	backend := useFakeEC2(t, nil)
+	backend.AddImage(testDefaultAccount, testDefaultRegion, testImage("ami-1", "golden-1", "2024-01-01T00:00:00.000Z", map[string]string{"Name": "golden", "Stage": "prod"}, "snap-1"))
+	backend.AddImage(testDefaultAccount, testDefaultRegion, testImage("ami-2", "golden-2", "2024-02-01T00:00:00.000Z", map[string]string{"Name": "golden", "Stage": "test"}, "snap-2"))
+	backend.AddImage(testDefaultAccount, testDefaultRegion, testImage("ami-3", "golden-3", "2024-03-01T00:00:00.000Z", map[string]string{"Name": "golden", "Stage": "prod"}, "snap-3"))
+	backend.AddImage(testDefaultAccount, testDefaultRegion, testImage("ami-other", "other", "2023-01-01T00:00:00.000Z", map[string]string{"Name": "other"}, "snap-other"))
+
+	ami := NewAmi("ami-3")
+	ami.SourceRegion = testDefaultRegion
+	filters := TagFilters{{Key: "Name", FromReference: true}, {Key: "Stage", Value: "test", Negate: true}}
+	result, err := ami.Cleanup(t.Context(), []string{testDefaultRegion}, CleanupOptions{Tags: filters, Retention: RetentionPolicy{KeepNewest: 1}})
+	if err != nil {
+		t.Fatalf("Cleanup() error = %v", err)
+	}
+	if got := deregistered(backend, testDefaultAccount, testDefaultRegion); len(got) != 1 || got[0] != "ami-1" {
+		t.Errorf("deregistered = %v, want [ami-1]", got)
+	}
+	// the non-negated filters are sent to EC2, which leaves out ami-other
+	described := callInputs[*ec2.DescribeImagesInput](backend, testDefaultAccount, testDefaultRegion, "DescribeImages")
+	if filters := described[len(described)-1].Filters; len(filters) != 1 || awsv2.ToString(filters[0].Name) != "tag:Name" {
+		t.Errorf("DescribeImages filters = %+v, want tag:Name only", filters)
+	}
+	if images := result.Regions[testDefaultRegion].Images; len(images) != 2 {
+		t.Errorf("images = %+v, want ami-3 and ami-1 only", images)
+	}
+
+	missing := TagFilters{{Key: "Release", FromReference: true}}
+	if _, err := ami.Cleanup(t.Context(), []string{testDefaultRegion}, CleanupOptions{Tags: missing, Retention: RetentionPolicy{KeepNewest: 1}}); err == nil {
+		t.Error("Cleanup() error = nil, want error for a tag the AMI does not have")
+	}
+}
@@ -0,0 +1,215 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	asTypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// inUseInstanceStates are the states of the instances whose AMI is in use: every state but
+// shutting-down and terminated, since a stopped instance needs its AMI to start again.
+var inUseInstanceStates = []string{
+	string(ec2Types.InstanceStateNamePending),
+	string(ec2Types.InstanceStateNameRunning),
+	string(ec2Types.InstanceStateNameStopping),
+	string(ec2Types.InstanceStateNameStopped),
+}
+
+// AmiUsage maps the ID of an AMI to what still uses it, e.g. "instance i-0123 (running) in
+// account 111111111111".
+type AmiUsage map[string][]string
+
+// add records that user uses the AMI.
+func (u AmiUsage) add(amiID string, user string) {
+	if !strings.HasPrefix(amiID, "ami-") || slices.Contains(u[amiID], user) {
+		return
+	}
+	u[amiID] = append(u[amiID], user)
+}
+
+// amisInUse returns the AMIs in region that are used by the instances, the default and latest
+// launch template versions, the launch configurations and the Auto Scaling groups of the default
+// account and of accounts. A failure in any account is returned, since an AMI it uses cannot be
+// told apart from an unused one.
+func amisInUse(ctx context.Context, region string, accounts []string) (AmiUsage, error) {
+	usage := make(AmiUsage)
+	checked := make(map[string]bool)
+	var errs []error
+	for _, account := range append([]string{*ConfigManager.defaultAccountID}, accounts...) {
+		if checked[account] {
+			continue
+		}
+		checked[account] = true
+		if err := usage.addAccount(ctx, account, region); err != nil {
+			errs = append(errs, fmt.Errorf("account %s: %w", account, err))
+		}
+	}
+	return usage, errors.Join(errs...)
+}
+
+// addAccount records the AMIs that account uses in region.
+func (u AmiUsage) addAccount(ctx context.Context, account string, region string) error {
+	ec2Service := getEC2ServiceForAccountAndRegion(account, region)
+	autoScalingService := ConfigManager.getAutoScalingClient(account, region)
+	return errors.Join(
+		u.addInstances(ctx, ec2Service, account),
+		u.addLaunchTemplates(ctx, ec2Service, account),
+		u.addAutoScaling(ctx, autoScalingService, ec2Service, account),
+	)
+}
+
+// addInstances records the AMIs of the instances that are not terminated.
+func (u AmiUsage) addInstances(ctx context.Context, ec2Service EC2API, account string) error {
+	input := &ec2.DescribeInstancesInput{
+		Filters: []ec2Types.Filter{{Name: aws.String("instance-state-name"), Values: inUseInstanceStates}},
+	}
+	for {
+		output, err := ec2Service.DescribeInstances(ctx, input)
+		if err != nil {
+			return fmt.Errorf("describing instances: %w", err)
+		}
+		for _, reservation := range output.Reservations {
+			for _, instance := range reservation.Instances {
+				state := ""
+				if instance.State != nil {
+					state = string(instance.State.Name)
+				}
+				u.add(aws.ToString(instance.ImageId), fmt.Sprintf("instance %s (%s) in account %s", aws.ToString(instance.InstanceId), state, account))
+			}
+		}
+		if aws.ToString(output.NextToken) == "" {
+			return nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+// addLaunchTemplates records the AMIs of the default and latest version of every launch template.
+func (u AmiUsage) addLaunchTemplates(ctx context.Context, ec2Service EC2API, account string) error {
+	input := &ec2.DescribeLaunchTemplateVersionsInput{Versions: []string{"$Default", "$Latest"}}
+	for {
+		output, err := ec2Service.DescribeLaunchTemplateVersions(ctx, input)
+		if err != nil {
+			return fmt.Errorf("describing launch template versions: %w", err)
+		}
+		for _, version := range output.LaunchTemplateVersions {
+			if version.LaunchTemplateData == nil {
+				continue
+			}
+			u.add(aws.ToString(version.LaunchTemplateData.ImageId), fmt.Sprintf("launch template %s version %d in account %s",
+				aws.ToString(version.LaunchTemplateName), aws.ToInt64(version.VersionNumber), account))
+		}
+		if aws.ToString(output.NextToken) == "" {
+			return nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+// addAutoScaling records the AMIs of the launch configurations, and of the launch configuration or
+// launch template versions every Auto Scaling group launches its instances from.
+func (u AmiUsage) addAutoScaling(ctx context.Context, autoScalingService AutoScalingAPI, ec2Service EC2API, account string) error {
+	launchConfigurations := make(map[string]string)
+	configurationsInput := &autoscaling.DescribeLaunchConfigurationsInput{}
+	for {
+		output, err := autoScalingService.DescribeLaunchConfigurations(ctx, configurationsInput)
+		if err != nil {
+			return fmt.Errorf("describing launch configurations: %w", err)
+		}
+		for _, configuration := range output.LaunchConfigurations {
+			name := aws.ToString(configuration.LaunchConfigurationName)
+			launchConfigurations[name] = aws.ToString(configuration.ImageId)
+			u.add(aws.ToString(configuration.ImageId), fmt.Sprintf("launch configuration %s in account %s", name, account))
+		}
+		if aws.ToString(output.NextToken) == "" {
+			break
+		}
+		configurationsInput.NextToken = output.NextToken
+	}
+
+	groupsInput := &autoscaling.DescribeAutoScalingGroupsInput{}
+	for {
+		output, err := autoScalingService.DescribeAutoScalingGroups(ctx, groupsInput)
+		if err != nil {
+			return fmt.Errorf("describing Auto Scaling groups: %w", err)
+		}
+		for _, group := range output.AutoScalingGroups {
+			user := fmt.Sprintf("Auto Scaling group %s in account %s", aws.ToString(group.AutoScalingGroupName), account)
+			if name := aws.ToString(group.LaunchConfigurationName); name != "" {
+				u.add(launchConfigurations[name], user)
+			}
+			for _, template := range groupLaunchTemplates(group) {
+				amiID, err := launchTemplateImage(ctx, ec2Service, template)
+				if err != nil {
+					return fmt.Errorf("launch template of Auto Scaling group %s: %w", aws.ToString(group.AutoScalingGroupName), err)
+				}
+				u.add(amiID, user)
+			}
+		}
+		if aws.ToString(output.NextToken) == "" {
+			return nil
+		}
+		groupsInput.NextToken = output.NextToken
+	}
+}
+
+// groupLaunchTemplates returns the launch templates an Auto Scaling group launches instances from,
+// including those of its mixed instances policy and its overrides.
+func groupLaunchTemplates(group asTypes.AutoScalingGroup) []asTypes.LaunchTemplateSpecification {
+	var templates []asTypes.LaunchTemplateSpecification
+	if group.LaunchTemplate != nil {
+		templates = append(templates, *group.LaunchTemplate)
+	}
+	if policy := group.MixedInstancesPolicy; policy != nil && policy.LaunchTemplate != nil {
+		if policy.LaunchTemplate.LaunchTemplateSpecification != nil {
+			templates = append(templates, *policy.LaunchTemplate.LaunchTemplateSpecification)
+		}
+		for _, override := range policy.LaunchTemplate.Overrides {
+			if override.LaunchTemplateSpecification != nil {
+				templates = append(templates, *override.LaunchTemplateSpecification)
+			}
+		}
+	}
+	return templates
+}
+
+// launchTemplateImage returns the AMI of the launch template version a group launches from. A
+// group without a version uses the default version.
+func launchTemplateImage(ctx context.Context, ec2Service EC2API, template asTypes.LaunchTemplateSpecification) (string, error) {
+	version := aws.ToString(template.Version)
+	if version == "" {
+		version = "$Default"
+	}
+	input := &ec2.DescribeLaunchTemplateVersionsInput{Versions: []string{version}}
+	if template.LaunchTemplateId != nil {
+		input.LaunchTemplateId = template.LaunchTemplateId
+	} else {
+		input.LaunchTemplateName = template.LaunchTemplateName
+	}
+
+	output, err := ec2Service.DescribeLaunchTemplateVersions(ctx, input)
+	if err != nil {
+		return "", fmt.Errorf("describing version %s of launch template %s: %w", version, launchTemplateRef(template), err)
+	}
+	for _, templateVersion := range output.LaunchTemplateVersions {
+		if templateVersion.LaunchTemplateData != nil {
+			return aws.ToString(templateVersion.LaunchTemplateData.ImageId), nil
+		}
+	}
+	return "", nil
+}
+
+func launchTemplateRef(template asTypes.LaunchTemplateSpecification) string {
+	if template.LaunchTemplateId != nil {
+		return aws.ToString(template.LaunchTemplateId)
+	}
+	return aws.ToString(template.LaunchTemplateName)
+}
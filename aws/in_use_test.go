@@ -0,0 +1,73 @@
+package aws
+
+import (
+	"strings"
+	"testing"
+
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+	asTypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func TestAmisInUse(t *testing.T) {
+	consumer := "222222222222"
+	backend := useFakeEC2(t, []string{consumer})
+
+	stopped := backend.AddInstance(testDefaultAccount, testDefaultRegion, "ami-instance", ec2Types.InstanceStateNameStopped)
+	// only the default and the latest version of a launch template count
+	backend.AddLaunchTemplate(testDefaultAccount, testDefaultRegion, "web", "ami-web-default", "ami-web-unused", "ami-template")
+
+	backend.AddLaunchTemplate(consumer, testDefaultRegion, "batch", "ami-group")
+	backend.AddLaunchConfiguration(consumer, testDefaultRegion, "legacy", "ami-legacy")
+	backend.AddAutoScalingGroup(consumer, testDefaultRegion, asTypes.AutoScalingGroup{AutoScalingGroupName: awsv2.String("legacy-asg"), LaunchConfigurationName: awsv2.String("legacy")})
+	backend.AddAutoScalingGroup(consumer, testDefaultRegion, asTypes.AutoScalingGroup{
+		AutoScalingGroupName: awsv2.String("batch-asg"),
+		MixedInstancesPolicy: &asTypes.MixedInstancesPolicy{LaunchTemplate: &asTypes.LaunchTemplate{
+			LaunchTemplateSpecification: &asTypes.LaunchTemplateSpecification{LaunchTemplateName: awsv2.String("batch"), Version: awsv2.String("1")},
+		}},
+	})
+
+	usage, err := amisInUse(t.Context(), testDefaultRegion, []string{testDefaultAccount, consumer})
+	if err != nil {
+		t.Fatalf("amisInUse() error = %v", err)
+	}
+
+	want := map[string]string{
+		"ami-instance":    "instance " + stopped + " (stopped) in account " + testDefaultAccount,
+		"ami-web-default": "launch template web version 1 in account " + testDefaultAccount,
+		"ami-template":    "launch template web version 3 in account " + testDefaultAccount,
+		"ami-legacy":      "launch configuration legacy in account " + consumer + ", Auto Scaling group legacy-asg in account " + consumer,
+		"ami-group":       "launch template batch version 1 in account " + consumer + ", Auto Scaling group batch-asg in account " + consumer,
+	}
+	if len(usage) != len(want) {
+		t.Errorf("amisInUse() = %v, want %d AMIs", usage, len(want))
+	}
+	for amiID, users := range want {
+		if got := strings.Join(usage[amiID], ", "); got != users {
+			t.Errorf("users of %s = %q, want %q", amiID, got, users)
+		}
+	}
+}
+
+func TestCleanupKeepsAmisInUse(t *testing.T) {
+	backend := useFakeEC2(t, nil)
+	tags := map[string]string{"Name": "golden"}
+	backend.AddImage(testDefaultAccount, testDefaultRegion, testImage("ami-1", "golden-1", "2024-01-01T00:00:00.000Z", tags, "snap-1"))
+	backend.AddImage(testDefaultAccount, testDefaultRegion, testImage("ami-2", "golden-2", "2024-02-01T00:00:00.000Z", tags, "snap-2"))
+	backend.AddImage(testDefaultAccount, testDefaultRegion, testImage("ami-3", "golden-3", "2024-03-01T00:00:00.000Z", tags, "snap-3"))
+	running := backend.AddInstance(testDefaultAccount, testDefaultRegion, "ami-1", ec2Types.InstanceStateNameRunning)
+
+	ami := NewAmi("ami-3")
+	ami.SourceRegion = testDefaultRegion
+	result, err := ami.Cleanup(t.Context(), []string{testDefaultRegion}, CleanupOptions{Tags: TagFilters{{Key: "Name", FromReference: true}}, Retention: RetentionPolicy{KeepNewest: 1}})
+	if err != nil {
+		t.Fatalf("Cleanup() error = %v", err)
+	}
+
+	if got := deregistered(backend, testDefaultAccount, testDefaultRegion); len(got) != 1 || got[0] != "ami-2" {
+		t.Errorf("deregistered = %v, want [ami-2]", got)
+	}
+	if oldest := result.Regions[testDefaultRegion].Images[2]; oldest.Reason != CleanupReasonInUse || len(oldest.UsedBy) != 1 || !strings.Contains(oldest.UsedBy[0], running) {
+		t.Errorf("ami-1 = %+v, want it kept in use by instance %s", oldest, running)
+	}
+}
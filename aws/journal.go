@@ -0,0 +1,298 @@
+package aws
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	log "github.com/sirupsen/logrus"
+)
+
+// The steps recorded in a journal.
+const (
+	journalStepStarted         = "started"
+	journalStepResumed         = "resumed"
+	journalStepCopyStarted     = "copy-started"
+	journalStepCopyReused      = "copy-reused"
+	journalStepCopyAvailable   = "copy-available"
+	journalStepCopyFailed      = "copy-failed"
+	journalStepPermissions     = "permissions"
+	journalStepSnapshotsShared = "snapshots-shared"
+	journalStepTagged          = "tagged"
+	journalStepRegionDone      = "region-done"
+	journalStepRegionFailed    = "region-failed"
+	journalStepDeregistered    = "deregistered"
+	journalStepSnapshotDeleted = "snapshot-deleted"
+	journalStepFinished        = "finished"
+)
+
+// JournalRun is the command a journal records, with the flags to run it again.
+type JournalRun struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+}
+
+// JournalEntry is a single step of a journaled run, stored as one line of JSON.
+type JournalEntry struct {
+	Time time.Time `json:"time"`
+	Step string    `json:"step"`
+	// Run is set on the entry that starts or resumes a run.
+	Run *JournalRun `json:"run,omitempty"`
+
+	Region      string   `json:"region,omitempty"`
+	Account     string   `json:"account,omitempty"`
+	AmiID       string   `json:"amiId,omitempty"`
+	SnapshotIDs []string `json:"snapshotIds,omitempty"`
+	Principals  []string `json:"principals,omitempty"`
+	Error       string   `json:"error,omitempty"`
+}
+
+// Journal records the steps of a copy, remove or cleanup run in a state file as they happen, so
+// a run that dies halfway can be resumed. Every method is a no-op on a nil Journal.
+type Journal struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+
+	// previous holds the entries of the run being resumed
+	previous []JournalEntry
+}
+
+// OpenJournal starts a new journal in the state file at path. It refuses to overwrite the journal
+// of a run that did not finish, since that is the only record of the AMIs it created.
+func OpenJournal(path string) (*Journal, error) {
+	entries, err := ReadJournal(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if run := lastRun(entries); run != nil && !finished(entries) {
+		return nil, fmt.Errorf("state file %s holds an unfinished %s run; resume it with `resume --state-file %s`, or remove the file to start over", path, run.Command, path)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("opening state file: %w", err)
+	}
+	return &Journal{path: path, file: file}, nil
+}
+
+// ResumeJournal opens the state file at path to resume the unfinished run it records. New steps
+// are appended to it.
+func ResumeJournal(path string) (*Journal, error) {
+	entries, err := ReadJournal(path)
+	if err != nil {
+		return nil, err
+	}
+	if lastRun(entries) == nil {
+		return nil, fmt.Errorf("state file %s does not record a run", path)
+	}
+	if finished(entries) {
+		return nil, fmt.Errorf("the run in state file %s already finished; there is nothing to resume", path)
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("opening state file: %w", err)
+	}
+	return &Journal{path: path, file: file, previous: entries}, nil
+}
+
+// ReadJournal reads the entries of the state file at path. A last line that was cut off while it
+// was written is ignored.
+func ReadJournal(path string) ([]JournalEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading state file: %w", err)
+	}
+	defer func() { _ = file.Close() }()
+
+	var entries []JournalEntry
+	var badLine error
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		if badLine != nil {
+			return nil, badLine
+		}
+		var entry JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			badLine = fmt.Errorf("state file %s line %d: %w", path, line, err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading state file %s: %w", path, err)
+	}
+	if badLine != nil {
+		log.Warnf("Ignoring the incomplete last line of %v", badLine)
+	}
+	return entries, nil
+}
+
+// Run returns the run the journal records, or nil.
+func (j *Journal) Run() *JournalRun {
+	if j == nil {
+		return nil
+	}
+	return lastRun(j.previous)
+}
+
+// Resuming reports whether the journal continues an earlier run.
+func (j *Journal) Resuming() bool {
+	return j != nil && len(j.previous) > 0
+}
+
+// Start records the start of run, or that the run is resumed.
+func (j *Journal) Start(run JournalRun) {
+	if j.Resuming() {
+		j.record(JournalEntry{Step: journalStepResumed, Run: &run})
+		return
+	}
+	j.record(JournalEntry{Step: journalStepStarted, Run: &run})
+}
+
+// Finish records that the run completed, so it is not resumed.
+func (j *Journal) Finish() {
+	j.record(JournalEntry{Step: journalStepFinished})
+}
+
+// Close closes the state file.
+func (j *Journal) Close() error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.file.Close()
+}
+
+// record appends entry to the state file and syncs it to disk. A journal that cannot be written
+// does not stop the run, it is only logged.
+func (j *Journal) record(entry JournalEntry) {
+	if j == nil {
+		return
+	}
+	entry.Time = time.Now().UTC()
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Warnf("Unable to record %s in state file %s: %v", entry.Step, j.path, err)
+		return
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err := j.file.Write(append(line, '\n')); err == nil {
+		err = j.file.Sync()
+	}
+	if err != nil {
+		log.Warnf("Unable to record %s in state file %s: %v", entry.Step, j.path, err)
+	}
+}
+
+// completedRegion returns the AMI of the region if the run being resumed completed the region.
+func (j *Journal) completedRegion(region string) string {
+	if j == nil {
+		return ""
+	}
+	amiID := ""
+	for _, entry := range j.previous {
+		if entry.Region != region {
+			continue
+		}
+		switch entry.Step {
+		case journalStepRegionDone:
+			amiID = entry.AmiID
+		case journalStepRegionFailed:
+			amiID = ""
+		}
+	}
+	return amiID
+}
+
+// pendingSnapshotDeletions returns, per region, the snapshots of the AMIs that the run being
+// resumed deregistered but did not delete yet.
+func (j *Journal) pendingSnapshotDeletions() map[string][]JournalEntry {
+	if j == nil {
+		return nil
+	}
+	deleted := make(map[string]bool)
+	for _, entry := range j.previous {
+		if entry.Step == journalStepSnapshotDeleted {
+			for _, snapshotID := range entry.SnapshotIDs {
+				deleted[snapshotID] = true
+			}
+		}
+	}
+
+	pending := make(map[string][]JournalEntry)
+	for _, entry := range j.previous {
+		if entry.Step != journalStepDeregistered {
+			continue
+		}
+		remaining := entry
+		remaining.SnapshotIDs = nil
+		for _, snapshotID := range entry.SnapshotIDs {
+			if !deleted[snapshotID] {
+				remaining.SnapshotIDs = append(remaining.SnapshotIDs, snapshotID)
+			}
+		}
+		pending[entry.Region] = append(pending[entry.Region], remaining)
+	}
+	return pending
+}
+
+// finishSnapshotDeletions deletes the snapshots that the run being resumed left behind after
+// deregistering their AMIs, and returns the AMIs that were deregistered.
+func (ami *Ami) finishSnapshotDeletions(ctx context.Context) map[string]bool {
+	deregistered := make(map[string]bool)
+	pending := ami.Journal.pendingSnapshotDeletions()
+	for _, region := range slices.Sorted(maps.Keys(pending)) {
+		ec2Service := getEC2ServiceForAccountAndRegion(*ConfigManager.defaultAccountID, region)
+		for _, entry := range pending[region] {
+			deregistered[entry.AmiID] = true
+			for _, snapshotID := range entry.SnapshotIDs {
+				log.Infof("Deleting snapshot %s of AMI %s, which was deregistered by the resumed run", snapshotID, entry.AmiID)
+				if _, err := ec2Service.DeleteSnapshot(ctx, &ec2.DeleteSnapshotInput{SnapshotId: aws.String(snapshotID)}); err != nil {
+					log.Warnf("Failed to delete snapshot %s: %v", snapshotID, err)
+					continue
+				}
+				ami.Journal.record(JournalEntry{Step: journalStepSnapshotDeleted, Region: region, AmiID: entry.AmiID, SnapshotIDs: []string{snapshotID}})
+			}
+		}
+	}
+	return deregistered
+}
+
+func lastRun(entries []JournalEntry) *JournalRun {
+	var run *JournalRun
+	for _, entry := range entries {
+		if entry.Run != nil {
+			run = entry.Run
+		}
+	}
+	return run
+}
+
+func finished(entries []JournalEntry) bool {
+	return len(entries) > 0 && entries[len(entries)-1].Step == journalStepFinished
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -0,0 +1,158 @@
+package aws
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+)
+
+func TestOpenJournalKeepsUnfinishedRuns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.jsonl")
+	run := JournalRun{Command: "copy", Args: []string{"--amiID=ami-source"}}
+
+	journal, err := OpenJournal(path)
+	if err != nil {
+		t.Fatalf("OpenJournal() error = %v", err)
+	}
+	journal.Start(run)
+	journal.record(JournalEntry{Step: journalStepCopyStarted, Region: "us-east-1", AmiID: "ami-copy"})
+	_ = journal.Close()
+
+	if _, err := OpenJournal(path); err == nil {
+		t.Fatal("OpenJournal() over an unfinished run error = nil, want an error")
+	}
+
+	resumed, err := ResumeJournal(path)
+	if err != nil {
+		t.Fatalf("ResumeJournal() error = %v", err)
+	}
+	if got := resumed.Run(); got == nil || got.Command != "copy" || got.Args[0] != "--amiID=ami-source" {
+		t.Errorf("Run() = %+v, want %+v", got, run)
+	}
+	resumed.Start(run)
+	resumed.Finish()
+	_ = resumed.Close()
+
+	entries, err := ReadJournal(path)
+	if err != nil {
+		t.Fatalf("ReadJournal() error = %v", err)
+	}
+	var steps []string
+	for _, entry := range entries {
+		steps = append(steps, entry.Step)
+	}
+	want := []string{journalStepStarted, journalStepCopyStarted, journalStepResumed, journalStepFinished}
+	if len(steps) != len(want) {
+		t.Fatalf("steps = %v, want %v", steps, want)
+	}
+	for i := range want {
+		if steps[i] != want[i] {
+			t.Errorf("steps = %v, want %v", steps, want)
+			break
+		}
+	}
+
+	if _, err := ResumeJournal(path); err == nil {
+		t.Error("ResumeJournal() of a finished run error = nil, want an error")
+	}
+	if _, err := OpenJournal(path); err != nil {
+		t.Errorf("OpenJournal() over a finished run error = %v", err)
+	}
+}
+
+func TestReadJournalIgnoresCutOffLastLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.jsonl")
+	content := `{"step":"started","run":{"command":"copy","args":[]}}` + "\n" + `{"step":"copy-sta`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := ReadJournal(path)
+	if err != nil {
+		t.Fatalf("ReadJournal() error = %v", err)
+	}
+	if len(entries) != 1 || entries[0].Step != journalStepStarted {
+		t.Errorf("entries = %+v, want only the started entry", entries)
+	}
+
+	corrupt := `{"step":"started"` + "\n" + `{"step":"finished"}` + "\n"
+	if err := os.WriteFile(path, []byte(corrupt), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadJournal(path); err == nil {
+		t.Error("ReadJournal() with a corrupt line in the middle error = nil, want an error")
+	}
+}
+
+func TestJournalReplay(t *testing.T) {
+	journal := &Journal{previous: []JournalEntry{
+		{Step: journalStepStarted, Run: &JournalRun{Command: "copy"}},
+		{Step: journalStepRegionDone, Region: "us-east-1", AmiID: "ami-east"},
+		{Step: journalStepRegionFailed, Region: "us-west-2", AmiID: "ami-west"},
+		{Step: journalStepRegionDone, Region: "eu-central-1", AmiID: "ami-central"},
+		{Step: journalStepRegionFailed, Region: "eu-central-1", AmiID: "ami-central"},
+		{Step: journalStepDeregistered, Region: "us-east-1", AmiID: "ami-old", SnapshotIDs: []string{"snap-1", "snap-2"}},
+		{Step: journalStepSnapshotDeleted, Region: "us-east-1", AmiID: "ami-old", SnapshotIDs: []string{"snap-1"}},
+	}}
+
+	for region, want := range map[string]string{"us-east-1": "ami-east", "us-west-2": "", "eu-central-1": "", "ap-south-1": ""} {
+		if got := journal.completedRegion(region); got != want {
+			t.Errorf("completedRegion(%s) = %q, want %q", region, got, want)
+		}
+	}
+
+	pending := journal.pendingSnapshotDeletions()
+	if len(pending["us-east-1"]) != 1 || len(pending["us-east-1"][0].SnapshotIDs) != 1 || pending["us-east-1"][0].SnapshotIDs[0] != "snap-2" {
+		t.Errorf("pendingSnapshotDeletions() = %+v, want snap-2 of ami-old in us-east-1", pending)
+	}
+
+	var nilJournal *Journal
+	if nilJournal.completedRegion("us-east-1") != "" || nilJournal.pendingSnapshotDeletions() != nil || nilJournal.Resuming() {
+		t.Error("a nil journal should not record any previous run")
+	}
+}
+
+func TestCopyFinishesSnapshotDeletionsOfResumedRun(t *testing.T) {
+	backend := useFakeEC2(t, nil)
+	backend.AddImage(testDefaultAccount, testDefaultRegion, testImage("ami-source", "golden", "2024-01-01T00:00:00.000Z", nil, "snap-source"))
+
+	// the run being resumed deregistered a failed copy, but died before deleting all its snapshots
+	backend.AddImage(testDefaultAccount, "us-east-1", testImage("ami-failed", "golden", "2024-01-01T00:00:00.000Z", nil, "snap-1", "snap-2"))
+	client := backend.EC2(testDefaultAccount, "us-east-1")
+	if _, err := client.DeregisterImage(t.Context(), &ec2.DeregisterImageInput{ImageId: awsv2.String("ami-failed")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.DeleteSnapshot(t.Context(), &ec2.DeleteSnapshotInput{SnapshotId: awsv2.String("snap-1")}); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "state.jsonl")
+	content := `{"step":"started","run":{"command":"copy","args":[]}}` + "\n" +
+		`{"step":"deregistered","region":"us-east-1","amiId":"ami-failed","snapshotIds":["snap-1","snap-2"]}` + "\n" +
+		`{"step":"snapshot-deleted","region":"us-east-1","amiId":"ami-failed","snapshotIds":["snap-1"]}` + "\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	journal, err := ResumeJournal(path)
+	if err != nil {
+		t.Fatalf("ResumeJournal() error = %v", err)
+	}
+	defer journal.Close()
+
+	deletedBefore := len(backend.Calls("DeleteSnapshot"))
+	ami := NewAmiWithRegions("ami-source", testDefaultRegion, []string{"us-east-1"})
+	ami.Journal = journal
+	if _, err := ami.Copy(t.Context(), CopyOptions{}); err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+	var deleted []string
+	for _, call := range backend.Calls("DeleteSnapshot")[deletedBefore:] {
+		deleted = append(deleted, *call.Input.(*ec2.DeleteSnapshotInput).SnapshotId)
+	}
+	if !slices.Equal(deleted, []string{"snap-2"}) || backend.SnapshotExists("snap-2") {
+		t.Errorf("deleted snapshots = %v, want [snap-2]", deleted)
+	}
+}
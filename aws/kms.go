@@ -0,0 +1,99 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+)
+
+// KMSAPI is the subset of the KMS client used to check and grant access to the keys that encrypt
+// shared AMIs.
+type KMSAPI interface {
+	DescribeKey(ctx context.Context, params *kms.DescribeKeyInput, optFns ...func(*kms.Options)) (*kms.DescribeKeyOutput, error)
+	CreateGrant(ctx context.Context, params *kms.CreateGrantInput, optFns ...func(*kms.Options)) (*kms.CreateGrantOutput, error)
+}
+
+var _ KMSAPI = (*kms.Client)(nil)
+
+// KMSClientFactory builds the KMS client for an account. The config passed in already has its
+// region set to the target region.
+type KMSClientFactory func(account string, conf awsv2.Config) KMSAPI
+
+func newKMSClient(_ string, conf awsv2.Config) KMSAPI {
+	return kms.NewFromConfig(conf)
+}
+
+var (
+	kmsKeyIDPattern     = regexp.MustCompile(`^(mrk-[0-9a-f]{32}|[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12})$`)
+	kmsAliasNamePattern = regexp.MustCompile(`^alias/[a-zA-Z0-9/_-]{1,250}$`)
+	kmsArnPattern       = regexp.MustCompile(`^arn:aws[a-z-]*:kms:([a-z0-9-]+):([0-9]{12}):(key/.+|alias/.+)$`)
+)
+
+// ValidateKmsKeys checks a region to KMS key mapping for a copy from sourceRegion to regions. Every
+// region in the mapping must be a target region the AMI is copied to, every key must be a key ID,
+// alias or ARN, and ARNs must point to a key in the region they are used for, since CopyImage
+// only accepts keys from the destination region.
+func ValidateKmsKeys(sourceRegion string, regions []string, keys map[string]string) error {
+	var errs []error
+	for _, region := range slices.Sorted(maps.Keys(keys)) {
+		key := keys[region]
+
+		switch {
+		case !slices.Contains(regions, region):
+			errs = append(errs, fmt.Errorf("KMS key given for region %s, which is not one of the target regions", region))
+			continue
+		case region == sourceRegion:
+			errs = append(errs, fmt.Errorf("KMS key given for region %s, which is the source region and is not copied to", region))
+			continue
+		}
+
+		if err := validateKmsKey(region, key); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func validateKmsKey(region string, key string) error {
+	key = strings.TrimSpace(key)
+	switch {
+	case key == "":
+		return fmt.Errorf("empty KMS key for region %s", region)
+	case kmsKeyIDPattern.MatchString(key), kmsAliasNamePattern.MatchString(key):
+		return nil
+	case kmsArnPattern.MatchString(key):
+		keyRegion := kmsArnPattern.FindStringSubmatch(key)[1]
+		if keyRegion != region {
+			return fmt.Errorf("KMS key %s for region %s is in region %s; use a key from the destination region", key, region, keyRegion)
+		}
+		return nil
+	default:
+		return fmt.Errorf("invalid KMS key %q for region %s: expected a key ID, alias/<name>, or a key or alias ARN", key, region)
+	}
+}
+
+// KmsKeyRegion returns the region of a KMS key or alias ARN, or an empty string for key IDs and
+// aliases, which refer to the current region.
+func KmsKeyRegion(key string) string {
+	if match := kmsArnPattern.FindStringSubmatch(strings.TrimSpace(key)); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+// kmsKeyArn returns the ARN of the KMS key that key, a key ID, alias or ARN, refers to in the account
+// and region.
+func kmsKeyArn(ctx context.Context, account string, region string, key string) (string, error) {
+	described, err := ConfigManager.getKMSClient(account, region).DescribeKey(ctx, &kms.DescribeKeyInput{KeyId: awsv2.String(key)})
+	if err != nil {
+		return "", fmt.Errorf("describing KMS key %s in %s: %w", key, region, err)
+	}
+	return awsv2.ToString(described.KeyMetadata.Arn), nil
+}
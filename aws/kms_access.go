@@ -0,0 +1,236 @@
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmsTypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrAWSManagedKey is reported for accounts that need an AWS managed key, such as the default EBS
+// key. AWS managed keys cannot be used outside their own account, so the AMI has to be copied
+// with a customer managed key instead.
+var ErrAWSManagedKey = errors.New("the key is an AWS managed key and cannot be used by other accounts; copy the AMI with a customer managed key (--kms-key)")
+
+// kmsGrantOperations are the operations an account needs on the key to launch instances from, and
+// copy, an AMI encrypted with it.
+var kmsGrantOperations = []kmsTypes.GrantOperation{
+	kmsTypes.GrantOperationDecrypt,
+	kmsTypes.GrantOperationEncrypt,
+	kmsTypes.GrantOperationReEncryptFrom,
+	kmsTypes.GrantOperationReEncryptTo,
+	kmsTypes.GrantOperationGenerateDataKey,
+	kmsTypes.GrantOperationGenerateDataKeyWithoutPlaintext,
+	kmsTypes.GrantOperationDescribeKey,
+	kmsTypes.GrantOperationCreateGrant,
+}
+
+// keyPolicyActions are the key policy actions matching kmsGrantOperations.
+var keyPolicyActions = []string{
+	"kms:Decrypt",
+	"kms:Encrypt",
+	"kms:ReEncrypt*",
+	"kms:GenerateDataKey*",
+	"kms:DescribeKey",
+	"kms:CreateGrant",
+}
+
+// KeyAccessResult is the outcome of checking whether one account can use a KMS key.
+type KeyAccessResult struct {
+	KeyArn  string
+	Account string
+
+	// AccessErr is set when the account could not use the key when it was checked.
+	AccessErr error
+	// GrantID is the ID of the grant created for the account, when grants were requested.
+	GrantID string
+	// GrantErr is set when a requested grant could not be created.
+	GrantErr error
+}
+
+// Usable reports whether the account can use the key, either already or through a new grant.
+func (r KeyAccessResult) Usable() bool {
+	return r.AccessErr == nil || r.GrantID != ""
+}
+
+// CheckKeyAccess checks whether each account can use the KMS key in region. The key is described
+// with the credentials of every account, which only succeeds when the key policy or a grant gives
+// the account access to it. When createGrants is set, the default account creates a grant for
+// every account without access. The default account itself is not checked.
+//
+// An error is returned when the key cannot be described in the default account; per account
+// failures are reported in the results.
+func CheckKeyAccess(ctx context.Context, region string, keyID string, accounts []string, createGrants bool) ([]KeyAccessResult, error) {
+	owner := *ConfigManager.defaultAccountID
+	ownerKMS := ConfigManager.getKMSClient(owner, region)
+
+	described, err := ownerKMS.DescribeKey(ctx, &kms.DescribeKeyInput{KeyId: aws.String(keyID)})
+	if err != nil {
+		return nil, fmt.Errorf("describing KMS key %s in %s: %w", keyID, region, err)
+	}
+	keyArn := aws.ToString(described.KeyMetadata.Arn)
+	awsManaged := described.KeyMetadata.KeyManager == kmsTypes.KeyManagerTypeAws
+
+	var results []KeyAccessResult
+	for _, account := range accounts {
+		if account == owner {
+			continue
+		}
+		result := KeyAccessResult{KeyArn: keyArn, Account: account}
+
+		switch {
+		case awsManaged:
+			result.AccessErr = ErrAWSManagedKey
+		case !ConfigManager.hasConfigurationForAccount(account):
+			result.AccessErr = fmt.Errorf("no credentials for account %s to check with; pass it with --accounts and --role", account)
+		default:
+			_, result.AccessErr = ConfigManager.getKMSClient(account, region).DescribeKey(ctx, &kms.DescribeKeyInput{KeyId: aws.String(keyArn)})
+		}
+
+		if result.AccessErr != nil {
+			log.Warnf("Account %s cannot use KMS key %s: %v", account, keyArn, result.AccessErr)
+			if createGrants && !awsManaged {
+				result.GrantID, result.GrantErr = createKeyGrant(ctx, ownerKMS, keyArn, account)
+			}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// createKeyGrant lets account use the key. The grant is named after the account, so creating it
+// again returns the existing grant instead of adding a duplicate.
+func createKeyGrant(ctx context.Context, kmsService KMSAPI, keyArn string, account string) (string, error) {
+	log.Infof("Creating a grant on KMS key %s for account %s", keyArn, account)
+	output, err := kmsService.CreateGrant(ctx, &kms.CreateGrantInput{
+		KeyId:            aws.String(keyArn),
+		GranteePrincipal: aws.String(accountRootArn(keyArn, account)),
+		Operations:       kmsGrantOperations,
+		Name:             aws.String("aws-ami-manager-" + account),
+	})
+	if err != nil {
+		log.Errorf("Creating a grant on KMS key %s for account %s failed: %v", keyArn, account, err)
+		return "", err
+	}
+	return aws.ToString(output.GrantId), nil
+}
+
+// KeyPolicyStatement returns the key policy statement that lets the accounts use the key for
+// shared AMIs, as indented JSON.
+func KeyPolicyStatement(keyArn string, accounts []string) string {
+	principals := make([]string, 0, len(accounts))
+	for _, account := range accounts {
+		principals = append(principals, accountRootArn(keyArn, account))
+	}
+
+	statement := struct {
+		Sid       string
+		Effect    string
+		Principal struct{ AWS []string }
+		Action    []string
+		Resource  string
+	}{
+		Sid:      "AllowUseOfTheKeyForSharedAmis",
+		Effect:   "Allow",
+		Action:   keyPolicyActions,
+		Resource: "*",
+	}
+	statement.Principal.AWS = principals
+
+	out, _ := json.MarshalIndent(statement, "", "  ")
+	return string(out)
+}
+
+// accountRootArn returns the root principal of account in the partition of keyArn.
+func accountRootArn(keyArn string, account string) string {
+	partition := "aws"
+	if parts := strings.SplitN(keyArn, ":", 3); len(parts) == 3 && parts[0] == "arn" {
+		partition = parts[1]
+	}
+	return fmt.Sprintf("arn:%s:iam::%s:root", partition, account)
+}
+
+// kmsKeyIDs returns the KMS keys that encrypt the EBS snapshots of the AMI, ordered by key.
+func (ami *Ami) kmsKeyIDs(ctx context.Context) ([]string, error) {
+	snapshotIDs := snapshotIDsOf(ami.AWSImage)
+	if len(snapshotIDs) == 0 {
+		return nil, nil
+	}
+
+	ec2Service := getEC2ServiceForAccountAndRegion(*ConfigManager.defaultAccountID, ami.SourceRegion)
+	output, err := ec2Service.DescribeSnapshots(ctx, &ec2.DescribeSnapshotsInput{SnapshotIds: snapshotIDs})
+	if err != nil {
+		return nil, fmt.Errorf("describing the snapshots of AMI %s: %w", ami.SourceAmiID, err)
+	}
+
+	keys := make(map[string]bool)
+	for _, snapshot := range output.Snapshots {
+		if aws.ToBool(snapshot.Encrypted) && snapshot.KmsKeyId != nil {
+			keys[*snapshot.KmsKeyId] = true
+		}
+	}
+	return slices.Sorted(maps.Keys(keys)), nil
+}
+
+// checkKeyAccess checks every KMS key that encrypts the AMI's snapshots for the accounts, see
+// CheckKeyAccess.
+func (ami *Ami) checkKeyAccess(ctx context.Context, accounts []string, createGrants bool) ([]KeyAccessResult, error) {
+	if len(accounts) == 0 || (len(accounts) == 1 && accounts[0] == *ConfigManager.defaultAccountID) {
+		return nil, nil
+	}
+
+	keyIDs, err := ami.kmsKeyIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []KeyAccessResult
+	var errs []error
+	for _, keyID := range keyIDs {
+		keyResults, err := CheckKeyAccess(ctx, ami.SourceRegion, keyID, accounts, createGrants)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		results = append(results, keyResults...)
+	}
+	return results, errors.Join(errs...)
+}
+
+// KeysWithoutAccess groups the accounts that cannot use a key by key ARN, leaving out AWS managed
+// keys, whose policy cannot be changed.
+func KeysWithoutAccess(results []KeyAccessResult) map[string][]string {
+	missing := make(map[string][]string)
+	for _, result := range results {
+		if result.Usable() || errors.Is(result.AccessErr, ErrAWSManagedKey) {
+			continue
+		}
+		if !slices.Contains(missing[result.KeyArn], result.Account) {
+			missing[result.KeyArn] = append(missing[result.KeyArn], result.Account)
+		}
+	}
+	for _, accounts := range missing {
+		sort.Strings(accounts)
+	}
+	return missing
+}
+
+func keyGrantErrors(results []KeyAccessResult) []error {
+	var errs []error
+	for _, result := range results {
+		if result.GrantErr != nil {
+			errs = append(errs, fmt.Errorf("grant on KMS key %s for account %s: %w", result.KeyArn, result.Account, result.GrantErr))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,133 @@
+package aws
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// giveCredentials gives the ConfigurationManager installed by useFakeEC2 credentials for the
+// accounts.
+func giveCredentials(accounts ...string) {
+	for _, account := range accounts {
+		ConfigManager.configsPerAccount[account] = awsv2.Config{}
+	}
+}
+
+func TestCheckKeyAccessReportsAccountsWithoutAccess(t *testing.T) {
+	backend := useFakeEC2(t, nil)
+	giveCredentials("222222222222", "333333333333")
+	keyArn := backend.AddKey(testDefaultAccount, testDefaultRegion, "alias/ami")
+	backend.AllowKeyUse(keyArn, "222222222222")
+
+	results, err := CheckKeyAccess(t.Context(), testDefaultRegion, "alias/ami", []string{testDefaultAccount, "222222222222", "333333333333", "444444444444"}, false)
+	if err != nil {
+		t.Fatalf("CheckKeyAccess() error = %v", err)
+	}
+
+	if len(results) != 3 {
+		t.Fatalf("results = %+v, want one per account other than the default", results)
+	}
+	if !results[0].Usable() || results[1].Usable() || results[2].Usable() {
+		t.Errorf("results = %+v, want only 222222222222 to have access", results)
+	}
+	if !strings.Contains(results[2].AccessErr.Error(), "no credentials") {
+		t.Errorf("AccessErr for an account without credentials = %v", results[2].AccessErr)
+	}
+	if grants := backend.KeyGrants(keyArn); len(grants) != 0 {
+		t.Errorf("grants = %v, want none", grants)
+	}
+
+	missing := KeysWithoutAccess(results)
+	if got := missing[keyArn]; len(got) != 2 || got[0] != "333333333333" || got[1] != "444444444444" {
+		t.Errorf("KeysWithoutAccess() = %v", missing)
+	}
+}
+
+func TestCheckKeyAccessCreatesGrants(t *testing.T) {
+	backend := useFakeEC2(t, nil)
+	giveCredentials("222222222222")
+	keyArn := backend.AddKey(testDefaultAccount, testDefaultRegion, "alias/ami")
+
+	results, err := CheckKeyAccess(t.Context(), testDefaultRegion, keyArn, []string{"222222222222"}, true)
+	if err != nil {
+		t.Fatalf("CheckKeyAccess() error = %v", err)
+	}
+
+	if len(results) != 1 || results[0].GrantID == "" || !results[0].Usable() {
+		t.Fatalf("results = %+v, want a grant for 222222222222", results)
+	}
+	if grants := backend.KeyGrants(keyArn); !slices.Equal(grants, []string{"222222222222"}) {
+		t.Errorf("grants = %v, want one for 222222222222", grants)
+	}
+	if len(KeysWithoutAccess(results)) != 0 {
+		t.Errorf("KeysWithoutAccess() = %v, want none after the grant", KeysWithoutAccess(results))
+	}
+
+	// the grant is named after the account, so checking again does not add another one
+	if _, err := CheckKeyAccess(t.Context(), testDefaultRegion, keyArn, []string{"222222222222"}, true); err != nil {
+		t.Fatalf("CheckKeyAccess() again error = %v", err)
+	}
+	if grants := backend.KeyGrants(keyArn); len(grants) != 1 {
+		t.Errorf("grants after checking again = %v, want one", grants)
+	}
+}
+
+func TestCheckKeyAccessRejectsAWSManagedKeys(t *testing.T) {
+	backend := useFakeEC2(t, nil)
+	giveCredentials("222222222222")
+
+	results, err := CheckKeyAccess(t.Context(), testDefaultRegion, "alias/aws/ebs", []string{"222222222222"}, true)
+	if err != nil {
+		t.Fatalf("CheckKeyAccess() error = %v", err)
+	}
+
+	if len(results) != 1 || !errors.Is(results[0].AccessErr, ErrAWSManagedKey) {
+		t.Errorf("results = %+v, want ErrAWSManagedKey", results)
+	}
+	if calls := backend.Calls("CreateGrant"); len(calls) != 0 {
+		t.Errorf("CreateGrant calls = %d, want none for an AWS managed key", len(calls))
+	}
+	if len(KeysWithoutAccess(results)) != 0 {
+		t.Error("KeysWithoutAccess() lists an AWS managed key, whose policy cannot be changed")
+	}
+}
+
+func TestKeyPolicyStatement(t *testing.T) {
+	statement := KeyPolicyStatement("arn:aws-cn:kms:cn-north-1:111111111111:key/1234abcd-12ab-34cd-56ef-1234567890ab", []string{"222222222222"})
+
+	for _, want := range []string{`"arn:aws-cn:iam::222222222222:root"`, `"kms:Decrypt"`, `"kms:CreateGrant"`, `"Resource": "*"`} {
+		if !strings.Contains(statement, want) {
+			t.Errorf("KeyPolicyStatement() does not contain %s:\n%s", want, statement)
+		}
+	}
+}
+
+func TestCheckKeyAccessOfImageSnapshots(t *testing.T) {
+	consumer := "222222222222"
+	backend := useFakeEC2(t, []string{consumer})
+	giveCredentials(consumer)
+	keyArn := backend.AddKey(testDefaultAccount, "us-east-1", "alias/ami")
+	image := testImage("ami-copy", "golden", "2024-01-01T00:00:00.000Z", nil, "snap-copy")
+	image.BlockDeviceMappings[0].Ebs.Encrypted = awsv2.Bool(true)
+	image.BlockDeviceMappings[0].Ebs.KmsKeyId = awsv2.String(keyArn)
+	backend.AddImage(testDefaultAccount, "us-east-1", image)
+
+	ami := NewAmi("ami-copy")
+	ami.SourceRegion = "us-east-1"
+	ami.AWSImage = &image
+
+	results, err := ami.checkKeyAccess(t.Context(), []string{consumer}, true)
+	if err != nil {
+		t.Fatalf("checkKeyAccess() error = %v", err)
+	}
+	if len(results) != 1 || results[0].KeyArn != keyArn || results[0].GrantID == "" {
+		t.Errorf("results = %+v, want a grant on %s", results, keyArn)
+	}
+	if grants := backend.KeyGrants(keyArn); !slices.Equal(grants, []string{consumer}) {
+		t.Errorf("grants = %v, want one for %s", grants, consumer)
+	}
+}
@@ -0,0 +1,122 @@
+package aws
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+)
+
+func TestValidateKmsKeys(t *testing.T) {
+	regions := []string{"eu-west-1", "us-east-1", "eu-central-1"}
+
+	tests := []struct {
+		name    string
+		keys    map[string]string
+		wantErr string
+	}{
+		{
+			name: "no keys",
+			keys: nil,
+		},
+		{
+			name: "alias, key id and key arn",
+			keys: map[string]string{
+				"us-east-1":    "arn:aws:kms:us-east-1:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab",
+				"eu-central-1": "alias/ami",
+			},
+		},
+		{
+			name: "multi-region key id and alias arn",
+			keys: map[string]string{
+				"us-east-1":    "mrk-1234abcd12ab34cd56ef1234567890ab",
+				"eu-central-1": "arn:aws:kms:eu-central-1:123456789012:alias/ami",
+			},
+		},
+		{
+			name:    "region that is not a target",
+			keys:    map[string]string{"ap-south-1": "alias/ami"},
+			wantErr: "not one of the target regions",
+		},
+		{
+			name:    "source region",
+			keys:    map[string]string{"eu-west-1": "alias/ami"},
+			wantErr: "is the source region",
+		},
+		{
+			name:    "arn from another region",
+			keys:    map[string]string{"us-east-1": "arn:aws:kms:eu-west-1:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab"},
+			wantErr: "is in region eu-west-1",
+		},
+		{
+			name:    "malformed key",
+			keys:    map[string]string{"us-east-1": "my-key"},
+			wantErr: "invalid KMS key",
+		},
+		{
+			name:    "empty key",
+			keys:    map[string]string{"us-east-1": ""},
+			wantErr: "empty KMS key",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateKmsKeys("eu-west-1", regions, tt.keys)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("ValidateKmsKeys() error = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ValidateKmsKeys() error = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCopyRequestsEncryptionPerRegion(t *testing.T) {
+	backend := useFakeEC2(t, nil)
+	backend.AddImage(testDefaultAccount, testDefaultRegion, testImage("ami-source", "golden", "2024-01-01T00:00:00.000Z", nil, "snap-source"))
+	keyArn := backend.AddKey(testDefaultAccount, "us-east-1", "alias/ami")
+
+	opts := CopyOptions{
+		Encrypted: true,
+		KmsKeyIDs: map[string]string{"us-east-1": "alias/ami"},
+	}
+	ami := NewAmiWithRegions("ami-source", testDefaultRegion, []string{"us-east-1", "us-west-2"})
+	result, err := ami.Copy(t.Context(), opts)
+	if err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+	if err := result.Err(); err != nil {
+		t.Fatalf("Copy() result error = %v", err)
+	}
+
+	withKey := callInputs[*ec2.CopyImageInput](backend, testDefaultAccount, "us-east-1", "CopyImage")[0]
+	if !*withKey.Encrypted || *withKey.KmsKeyId != "alias/ami" {
+		t.Errorf("us-east-1 CopyImage Encrypted=%v KmsKeyId=%v, want true and alias/ami", withKey.Encrypted, withKey.KmsKeyId)
+	}
+	if copied, _ := backend.Image(testDefaultAccount, "us-east-1", result.Regions["us-east-1"].AmiID); !isEncryptedWith(&copied, keyArn) {
+		t.Errorf("us-east-1 copy = %+v, want it encrypted with %s", copied.BlockDeviceMappings, keyArn)
+	}
+	defaultKey := callInputs[*ec2.CopyImageInput](backend, testDefaultAccount, "us-west-2", "CopyImage")[0]
+	if !*defaultKey.Encrypted || defaultKey.KmsKeyId != nil {
+		t.Errorf("us-west-2 CopyImage Encrypted=%v KmsKeyId=%v, want true and no key", defaultKey.Encrypted, defaultKey.KmsKeyId)
+	}
+}
+
+func TestCopyRejectsInvalidKmsKeysBeforeCopying(t *testing.T) {
+	backend := useFakeEC2(t, nil)
+	backend.AddImage(testDefaultAccount, testDefaultRegion, testImage("ami-source", "golden", "2024-01-01T00:00:00.000Z", nil))
+
+	opts := CopyOptions{KmsKeyIDs: map[string]string{"us-east-1": "alias/ami", "us-west-2": "not-a-key"}}
+	ami := NewAmiWithRegions("ami-source", testDefaultRegion, []string{"us-east-1", "us-west-2"})
+	if _, err := ami.Copy(t.Context(), opts); err == nil {
+		t.Fatal("Copy() error = nil, want validation error")
+	}
+	if got := len(backend.Calls("CopyImage")); got != 0 {
+		t.Errorf("CopyImage calls = %d, want 0", got)
+	}
+}
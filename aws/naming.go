@@ -0,0 +1,130 @@
+package aws
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// maxAmiDescriptionLength is the longest description EC2 accepts for an AMI.
+const maxAmiDescriptionLength = 255
+
+// amiNamePattern matches the names EC2 accepts for an AMI: 3 to 128 letters, numbers, spaces and
+// ( ) [ ] . / - ' @ _ characters.
+var amiNamePattern = regexp.MustCompile(`^[a-zA-Z0-9()\[\] ./\-'@_]{3,128}$`)
+
+// NameTemplateData holds the values available to the name and description templates of the
+// regional copies, e.g. {{.SourceName}}-{{.Date}}.
+type NameTemplateData struct {
+	SourceName   string
+	SourceId     string
+	SourceRegion string
+	TargetRegion string
+	// Date is the day the copy was started, as YYYY-MM-DD in UTC.
+	Date string
+	// Tags are the tags of the source AMI by key, e.g. {{.Tags.Version}} or {{index .Tags "team:name"}}.
+	Tags map[string]string
+}
+
+// validateNameTemplates parses the name and description templates.
+func validateNameTemplates(nameTemplate string, descriptionTemplate string) error {
+	var errs []error
+	if _, err := parseNameTemplate("name", nameTemplate); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := parseNameTemplate("description", descriptionTemplate); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// nameCopies renders the name and description of the copy in every target region and checks them
+// against the EC2 rules, so an invalid name fails before any copy starts. Without a name template
+// the copies keep the name of the source AMI, which is not checked; without a description template
+// they get none.
+func (ami *Ami) nameCopies(opts CopyOptions, now time.Time) error {
+	nameTemplate, err := parseNameTemplate("name", opts.NameTemplate)
+	if err != nil {
+		return err
+	}
+	descriptionTemplate, err := parseNameTemplate("description", opts.DescriptionTemplate)
+	if err != nil {
+		return err
+	}
+
+	tags := make(map[string]string)
+	for _, tag := range ami.sourceTags() {
+		tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+
+	var errs []error
+	for _, region := range ami.targetRegions() {
+		// the source region is only copied to when the target accounts make their own copies
+		if region == ami.SourceRegion && opts.Mode != CopyModeOwnedCopy {
+			continue
+		}
+		data := NameTemplateData{
+			SourceName:   ami.SourceAmiName,
+			SourceId:     ami.SourceAmiID,
+			SourceRegion: ami.SourceRegion,
+			TargetRegion: region,
+			Date:         now.UTC().Format(time.DateOnly),
+			Tags:         tags,
+		}
+		if err := ami.AmisPerRegion[region].setName(nameTemplate, descriptionTemplate, data); err != nil {
+			errs = append(errs, fmt.Errorf("region %s: %w", region, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// setName renders the name and description of a regional copy before it is copied.
+func (ami *Ami) setName(nameTemplate *template.Template, descriptionTemplate *template.Template, data NameTemplateData) error {
+	var err error
+	ami.SourceAmiName = data.SourceName
+	// only rendered names are checked: EC2 already accepted the name of the source AMI
+	if nameTemplate != nil {
+		if ami.SourceAmiName, err = executeNameTemplate(nameTemplate, data); err != nil {
+			return err
+		}
+		if !amiNamePattern.MatchString(ami.SourceAmiName) {
+			return fmt.Errorf("invalid AMI name %q: names are 3 to 128 letters, numbers, spaces and ( ) [ ] . / - ' @ _ characters", ami.SourceAmiName)
+		}
+	}
+
+	if descriptionTemplate != nil {
+		if ami.description, err = executeNameTemplate(descriptionTemplate, data); err != nil {
+			return err
+		}
+		if len(ami.description) > maxAmiDescriptionLength {
+			return fmt.Errorf("the AMI description is longer than %d characters", maxAmiDescriptionLength)
+		}
+	}
+	return nil
+}
+
+// parseNameTemplate parses a name or description template, or returns nil when text is empty.
+// Referencing a tag the source AMI does not have is an error.
+func parseNameTemplate(name string, text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s template: %w", name, err)
+	}
+	return tmpl, nil
+}
+
+func executeNameTemplate(tmpl *template.Template, data NameTemplateData) (string, error) {
+	var rendered strings.Builder
+	if err := tmpl.Execute(&rendered, data); err != nil {
+		return "", fmt.Errorf("rendering the %s template: %w", tmpl.Name(), err)
+	}
+	return rendered.String(), nil
+}
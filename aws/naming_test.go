@@ -0,0 +1,65 @@
+package aws
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNameCopies(t *testing.T) {
+	// early in the morning in CEST is still the previous day in UTC
+	now := time.Date(2024, 5, 18, 1, 30, 0, 0, time.FixedZone("CEST", 2*60*60))
+
+	tests := []struct {
+		name            string
+		sourceName      string
+		opts            CopyOptions
+		wantName        string
+		wantDescription string
+		wantErr         bool
+	}{
+		{name: "source name", opts: CopyOptions{}, wantName: "golden"},
+		// EC2 accepted the source name, so it is not checked against amiNamePattern
+		{name: "untemplated source name", sourceName: "golden:v1+build", opts: CopyOptions{}, wantName: "golden:v1+build"},
+		{
+			name:            "templates",
+			opts:            CopyOptions{NameTemplate: "{{.SourceName}}-{{.Tags.Version}}-{{.Date}}", DescriptionTemplate: "{{.SourceId}} from {{.SourceRegion}} to {{.TargetRegion}}"},
+			wantName:        "golden-1.2.3-2024-05-17",
+			wantDescription: "ami-source from eu-west-1 to us-east-1",
+		},
+		{name: "missing tag", opts: CopyOptions{NameTemplate: "{{.Tags.Team}}"}, wantErr: true},
+		{name: "invalid characters", opts: CopyOptions{NameTemplate: "{{.SourceName}}:{{.Date}}"}, wantErr: true},
+		{name: "too short", opts: CopyOptions{NameTemplate: "ab"}, wantErr: true},
+		{name: "description too long", opts: CopyOptions{DescriptionTemplate: strings.Repeat("x", maxAmiDescriptionLength+1)}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			image := testImage("ami-source", "golden", "2024-01-01T00:00:00.000Z", map[string]string{"Version": "1.2.3"})
+			ami := NewAmiWithRegions("ami-source", testDefaultRegion, []string{testDefaultRegion, "us-east-1"})
+			ami.SourceAmiName = "golden"
+			if tt.sourceName != "" {
+				ami.SourceAmiName = tt.sourceName
+			}
+			ami.SourceAmiTags = &image.Tags
+
+			err := ami.nameCopies(tt.opts, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("nameCopies() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			copied := ami.AmisPerRegion["us-east-1"]
+			if copied.SourceAmiName != tt.wantName {
+				t.Errorf("name = %q, want %q", copied.SourceAmiName, tt.wantName)
+			}
+			if copied.description != tt.wantDescription {
+				t.Errorf("description = %q, want %q", copied.description, tt.wantDescription)
+			}
+			if source := ami.AmisPerRegion[testDefaultRegion]; source.SourceAmiName != "" {
+				t.Errorf("name in the source region = %q, want none", source.SourceAmiName)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package aws
+
+import (
+	"errors"
+	"fmt"
+)
+
+// CopyMode is the way Ami.Copy makes the AMI available to the target accounts.
+type CopyMode string
+
+const (
+	// CopyModeShare copies the AMI to every region in the default account and grants the target
+	// accounts launch permissions on the copies. It is the default.
+	CopyModeShare CopyMode = "share"
+	// CopyModeOwnedCopy shares the source AMI with the target accounts, and then has every target
+	// account copy it to every region, so each account owns its copies.
+	CopyModeOwnedCopy CopyMode = "owned-copy"
+)
+
+// CopyOptions holds the optional settings for Ami.Copy.
+type CopyOptions struct {
+	// Mode is CopyModeShare when empty.
+	Mode CopyMode
+
+	// OrganizationTargets are shared with on top of the configured accounts.
+	OrganizationTargets
+
+	// Encrypted requests encrypted regional copies. It is implied for every region that has a KMS key.
+	Encrypted bool
+	// KmsKeyIDs maps a target region to the KMS key (key ID, alias, or key/alias ARN) that encrypts
+	// the copy in that region. Regions without an entry use the account's default EBS key.
+	KmsKeyIDs map[string]string
+	// ShareSnapshots also grants the accounts createVolumePermission on the EBS snapshots of every
+	// regional copy.
+	ShareSnapshots bool
+	// CreateKmsGrants creates KMS grants for the accounts that cannot use the customer managed keys
+	// encrypting the regional copies. Without it, missing key access is only reported.
+	CreateKmsGrants bool
+	// TagRules transform the source AMI tags into the tags of the regional copies.
+	TagRules TagRules
+	// TagAfterCopy tags the regional copies and their snapshots in the default account with
+	// CreateTags once they are available, instead of in the CopyImage request. Copies are always
+	// tagged this way when a tag template uses the ID of the copy.
+	TagAfterCopy bool
+	// NameTemplate and DescriptionTemplate are text/template templates for the name and
+	// description of the regional copies, expanded with NameTemplateData. The copies keep the
+	// name of the source AMI when NameTemplate is empty.
+	NameTemplate        string
+	DescriptionTemplate string
+	// Wait controls how the regional copies are polled until they are available.
+	Wait WaitPolicy
+	// NoWait only starts the regional copies and does not wait for them. The copies are shared and
+	// tagged later by WaitForCopies; RegionCopyResult.Pending marks them.
+	NoWait bool
+	// DeregisterFailedCopies deregisters a regional copy that failed, and deletes its snapshots.
+	DeregisterFailedCopies bool
+	// CopyRetries is the number of times a regional copy that failed is copied again.
+	CopyRetries int
+	// MaxConcurrency is the number of regions that are copied to at the same time. Zero copies to
+	// every region at once.
+	MaxConcurrency int
+}
+
+// Validate checks the options against the source region and the target regions of a copy.
+func (o CopyOptions) Validate(sourceRegion string, regions []string) error {
+	return errors.Join(o.OrganizationTargets.Validate(), ValidateKmsKeys(sourceRegion, regions, o.KmsKeyIDs), o.TagRules.Validate(),
+		validateNameTemplates(o.NameTemplate, o.DescriptionTemplate), o.Wait.Validate(), o.validateCounts(), o.validateMode())
+}
+
+func (o CopyOptions) validateMode() error {
+	switch o.Mode {
+	case "", CopyModeShare:
+		return nil
+	case CopyModeOwnedCopy:
+		if !o.OrganizationTargets.IsEmpty() {
+			return errors.New("owned copies are made by the listed accounts, not by organizations or organizational units")
+		}
+		if o.NoWait {
+			return errors.New("owned copies cannot be made without waiting for them")
+		}
+		if o.CopyRetries > 0 || o.DeregisterFailedCopies {
+			return errors.New("owned copies that fail are not retried or deregistered; leave out --copy-retries and --deregister-failed-copies")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown copy mode %q: use %s or %s", o.Mode, CopyModeShare, CopyModeOwnedCopy)
+	}
+}
+
+func (o CopyOptions) validateCounts() error {
+	var errs []error
+	if o.CopyRetries < 0 {
+		errs = append(errs, fmt.Errorf("the number of copy retries cannot be negative: %d", o.CopyRetries))
+	}
+	if o.CopyRetries > 0 && !o.DeregisterFailedCopies {
+		// the name of the failed copy stays taken until it is deregistered
+		errs = append(errs, errors.New("copy retries need the failed copies to be deregistered (--deregister-failed-copies), since a retry cannot reuse the name of a failed copy"))
+	}
+	if o.MaxConcurrency < 0 {
+		errs = append(errs, fmt.Errorf("the maximum concurrency cannot be negative: %d", o.MaxConcurrency))
+	}
+	return errors.Join(errs...)
+}
+
+// encryptionFor reports whether the copy to region must be encrypted, and with which KMS key.
+func (o CopyOptions) encryptionFor(region string) (bool, string) {
+	keyID := o.KmsKeyIDs[region]
+	return o.Encrypted || keyID != "", keyID
+}
+
+// CleanupOptions holds the settings for Ami.Cleanup.
+type CleanupOptions struct {
+	// Tags select the versions of the AMI, usually by the values of tags of the AMI that its
+	// versions share.
+	Tags TagFilters
+	// Retention decides which versions are kept.
+	Retention RetentionPolicy
+	// ProtectTags maps the key of a tag to the value that keeps older versions with the tag. An
+	// empty value keeps them whatever the value is.
+	ProtectTags map[string]string
+	// Owners are the owners of the images to consider, as in DescribeImages: self, an account ID
+	// or amazon. It is self when empty. Images of other accounts than the default account are
+	// skipped.
+	Owners []string
+	// DryRun only plans which versions would be deregistered.
+	DryRun bool
+}
+
+func (o CleanupOptions) owners() []string {
+	if len(o.Owners) == 0 {
+		return []string{"self"}
+	}
+	return o.Owners
+}
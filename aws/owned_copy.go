@@ -0,0 +1,153 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	log "github.com/sirupsen/logrus"
+)
+
+// ownedCopyAccounts returns the target accounts that make their own copies with
+// CopyModeOwnedCopy: every account but the default account.
+func ownedCopyAccounts() []string {
+	var accounts []string
+	for _, account := range ConfigManager.getAccounts() {
+		if account != *ConfigManager.defaultAccountID && !slices.Contains(accounts, account) {
+			accounts = append(accounts, account)
+		}
+	}
+	return accounts
+}
+
+// shareSource shares the source AMI and its snapshots with the accounts, so they can copy it, and
+// returns whether they can use the KMS keys encrypting it.
+func (ami *Ami) shareSource(ctx context.Context, accounts []string, opts CopyOptions) ([]KeyAccessResult, error) {
+	err := ami.setOwners(ctx, accounts, OrganizationTargets{})
+	ami.Journal.record(JournalEntry{
+		Step: journalStepPermissions, Region: ami.SourceRegion, AmiID: ami.SourceAmiID, Error: errorString(err),
+		Principals: accounts,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("sharing source AMI %s with the target accounts: %w", ami.SourceAmiID, err)
+	}
+
+	// an account can only copy a shared AMI when it can read the snapshots backing it
+	var errs []error
+	for _, snapshot := range ami.shareSnapshots(ctx, accounts) {
+		if snapshot.Err != nil {
+			errs = append(errs, fmt.Errorf("snapshot %s: %w", snapshot.SnapshotID, snapshot.Err))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, fmt.Errorf("sharing the snapshots of source AMI %s with the target accounts: %w", ami.SourceAmiID, err)
+	}
+
+	keyAccess, err := ami.checkKeyAccess(ctx, accounts, opts.CreateKmsGrants)
+	if err != nil {
+		log.Warnf("Checking KMS key access for source AMI %s failed: %v", ami.SourceAmiID, err)
+	}
+	return keyAccess, nil
+}
+
+// copyToAccounts has every target account copy the shared source AMI to the region of
+// regionResult, waits for the copies and tags them. The copies are started first, so the accounts
+// copy at the same time.
+func (ami *Ami) copyToAccounts(ctx context.Context, regionResult *RegionCopyResult, opts CopyOptions, copiedAt time.Time) {
+	region := regionResult.Region
+	name := ami.SourceAmiName
+	description := ""
+	if target, ok := ami.AmisPerRegion[region]; ok && target.SourceAmiName != "" {
+		name, description = target.SourceAmiName, target.description
+	}
+
+	accounts := ownedCopyAccounts()
+	copies := make([]*Ami, len(accounts))
+	regionResult.AccountCopies = make([]AccountCopyResult, len(accounts))
+	taggedAtCopy := make([]bool, len(accounts))
+	for i, account := range accounts {
+		accountResult := &regionResult.AccountCopies[i]
+		accountResult.Account = account
+		copies[i], taggedAtCopy[i], accountResult.CopyErr = ami.startAccountCopy(ctx, accountResult, region, name, description, opts, copiedAt)
+		if accountResult.CopyErr != nil {
+			log.Errorf("Copying AMI %s to region %s in account %s failed: %v", ami.SourceAmiID, region, account, accountResult.CopyErr)
+		}
+	}
+
+	for i, accountCopy := range copies {
+		accountResult := &regionResult.AccountCopies[i]
+		if accountCopy == nil {
+			continue
+		}
+		if err := accountCopy.waitUntilAvailable(ctx, opts.Wait); err != nil {
+			accountResult.CopyErr = err
+			log.Errorf("Copy %s of AMI %s in region %s in account %s failed: %v", accountCopy.SourceAmiID, ami.SourceAmiID, region, accountResult.Account, err)
+			continue
+		}
+		ami.Journal.record(JournalEntry{Step: journalStepCopyAvailable, Region: region, Account: accountResult.Account, AmiID: accountCopy.SourceAmiID})
+		if !taggedAtCopy[i] {
+			accountResult.TagErr = ami.tagAccountCopy(ctx, accountCopy, opts.TagRules, copiedAt)
+		}
+	}
+}
+
+// startAccountCopy reuses an earlier copy of the AMI that the account of accountResult owns in
+// region, or else has the account start a new one.
+func (ami *Ami) startAccountCopy(ctx context.Context, accountResult *AccountCopyResult, region string, name string, description string, opts CopyOptions, copiedAt time.Time) (*Ami, bool, error) {
+	account := accountResult.Account
+	existing, err := ami.existingCopy(ctx, account, region, opts)
+	if err != nil {
+		return nil, false, err
+	}
+	if existing != nil {
+		accountResult.Reused = true
+		accountResult.AmiID = aws.ToString(existing.ImageId)
+		log.Infof("Reusing copy %s (%s) of AMI %s in region %s in account %s", accountResult.AmiID, existing.State, ami.SourceAmiID, region, account)
+		ami.Journal.record(JournalEntry{Step: journalStepCopyReused, Region: region, Account: account, AmiID: accountResult.AmiID})
+		return &Ami{SourceAmiID: accountResult.AmiID, SourceRegion: region, AWSImage: existing, owner: account}, false, nil
+	}
+
+	var tags *copyTags
+	if !opts.TagAfterCopy {
+		tags = opts.TagRules.atCopy(ami.sourceTags(), ami.tagTemplateData(region, "", copiedAt))
+	}
+	if tags != nil {
+		// the tags of an AMI shared by another account are not visible, so CopyImageTags
+		// would copy none of them
+		tags.copySourceTags = false
+		tags.image = tags.snapshots
+	}
+
+	log.Infof("Copying AMI %s to region %s in account %s", ami.SourceAmiID, region, account)
+	ec2Service := getEC2ServiceForAccountAndRegion(account, region)
+	output, err := ec2Service.CopyImage(ctx, ami.copyImageInput(region, name, description, opts, tags))
+	if err != nil {
+		return nil, false, err
+	}
+	accountResult.AmiID = aws.ToString(output.ImageId)
+	log.Infof("New AMI ID in account %s: %s", account, accountResult.AmiID)
+	ami.Journal.record(JournalEntry{Step: journalStepCopyStarted, Region: region, Account: account, AmiID: accountResult.AmiID})
+	return &Ami{SourceAmiID: accountResult.AmiID, SourceRegion: region, owner: account}, tags != nil, nil
+}
+
+// tagAccountCopy tags a copy owned by a target account, and its snapshots, in that account.
+func (ami *Ami) tagAccountCopy(ctx context.Context, accountCopy *Ami, rules TagRules, copiedAt time.Time) error {
+	tags, err := rules.Apply(ami.sourceTags(), ami.tagTemplateData(accountCopy.SourceRegion, accountCopy.SourceAmiID, copiedAt))
+	if err != nil || len(tags) == 0 {
+		return err
+	}
+
+	snapshotIDs := snapshotIDsOf(accountCopy.AWSImage)
+	err = accountCopy.setTagsForAccount(ctx, accountCopy.owner, tags, snapshotIDs...)
+	ami.Journal.record(JournalEntry{
+		Step: journalStepTagged, Region: accountCopy.SourceRegion, Account: accountCopy.owner, AmiID: accountCopy.SourceAmiID,
+		SnapshotIDs: snapshotIDs, Error: errorString(err),
+	})
+	if err != nil {
+		log.Errorf("Setting tags on AMI %s in account %s failed: %v", accountCopy.SourceAmiID, accountCopy.owner, err)
+	}
+	return err
+}
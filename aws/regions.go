@@ -0,0 +1,151 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// RegionsAll selects every region that is enabled in the default account.
+	RegionsAll = "all"
+	// RegionsAllExcept selects every enabled region except the ones that follow it, e.g.
+	// all-except=us-west-1,us-west-2.
+	RegionsAllExcept = "all-except="
+)
+
+// regionGroupPattern matches a region group, such as eu or us: the first part of the region names
+// in the group. Region names themselves always contain a dash.
+var regionGroupPattern = regexp.MustCompile(`^[a-z]+$`)
+
+// ResolveRegions turns region specs into region names. A spec is a region name, a region group
+// such as eu or us, RegionsAll, or RegionsAllExcept, after which every spec is excluded instead.
+// Groups and RegionsAll are resolved with DescribeRegions in the default account, so they only
+// select the enabled regions of its partition; a list of region names alone is returned as is.
+func ResolveRegions(ctx context.Context, specs []string) ([]string, error) {
+	if !slices.ContainsFunc(specs, isRegionSelector) {
+		return specs, nil
+	}
+
+	enabled, err := enabledRegions(ctx, *ConfigManager.defaultAccountID)
+	if err != nil {
+		return nil, err
+	}
+
+	selected := make(map[string]bool)
+	excluding := false
+	var errs []error
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == RegionsAll || strings.HasPrefix(spec, RegionsAllExcept) {
+			for _, region := range enabled {
+				selected[region] = true
+			}
+			if spec == RegionsAll {
+				continue
+			}
+			excluding = true
+			if spec = strings.TrimPrefix(spec, RegionsAllExcept); spec == "" {
+				continue
+			}
+		}
+
+		regions, err := expandRegionSpec(spec, enabled)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, region := range regions {
+			selected[region] = !excluding
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	var resolved []string
+	for _, region := range slices.Sorted(maps.Keys(selected)) {
+		if selected[region] {
+			resolved = append(resolved, region)
+		}
+	}
+	if len(resolved) == 0 {
+		return nil, fmt.Errorf("regions %s select no region", strings.Join(specs, ","))
+	}
+	log.Infof("Resolved regions %s to %s", strings.Join(specs, ","), strings.Join(resolved, ","))
+	return resolved, nil
+}
+
+// RegionsEnabledInAccounts returns the regions that are enabled in every account. Regions that an
+// account did not opt in to are left out with a warning, since the account could not use the AMI
+// there.
+func RegionsEnabledInAccounts(ctx context.Context, regions []string, accounts []string) ([]string, error) {
+	kept := slices.Clone(regions)
+	for _, account := range accounts {
+		enabled, err := enabledRegions(ctx, account)
+		if err != nil {
+			return nil, err
+		}
+		kept = slices.DeleteFunc(kept, func(region string) bool {
+			if slices.Contains(enabled, region) {
+				return false
+			}
+			log.Warnf("Skipping region %s, which is not enabled in account %s", region, account)
+			return true
+		})
+	}
+	if len(kept) == 0 {
+		return nil, fmt.Errorf("none of the regions %s is enabled in every account", strings.Join(regions, ","))
+	}
+	return kept, nil
+}
+
+// enabledRegions returns the regions that are enabled in account, sorted by name.
+func enabledRegions(ctx context.Context, account string) ([]string, error) {
+	ec2Service := getEC2ServiceForAccountAndRegion(account, ConfigManager.defaultRegion)
+	output, err := ec2Service.DescribeRegions(ctx, &ec2.DescribeRegionsInput{})
+	if err != nil {
+		return nil, fmt.Errorf("listing the enabled regions of account %s: %w", account, err)
+	}
+	regions := make([]string, 0, len(output.Regions))
+	for _, region := range output.Regions {
+		regions = append(regions, aws.ToString(region.RegionName))
+	}
+	slices.Sort(regions)
+	return regions, nil
+}
+
+// expandRegionSpec returns the enabled regions of a region group, or the region itself when it is
+// enabled.
+func expandRegionSpec(spec string, enabled []string) ([]string, error) {
+	if !regionGroupPattern.MatchString(spec) {
+		if !slices.Contains(enabled, spec) {
+			return nil, fmt.Errorf("region %s is not enabled in account %s", spec, *ConfigManager.defaultAccountID)
+		}
+		return []string{spec}, nil
+	}
+
+	var regions []string
+	for _, region := range enabled {
+		if strings.HasPrefix(region, spec+"-") {
+			regions = append(regions, region)
+		}
+	}
+	if len(regions) == 0 {
+		return nil, fmt.Errorf("region group %s matches none of the enabled regions", spec)
+	}
+	return regions, nil
+}
+
+func isRegionSelector(spec string) bool {
+	spec = strings.TrimSpace(spec)
+	return spec == RegionsAll || strings.HasPrefix(spec, RegionsAllExcept) || regionGroupPattern.MatchString(spec)
+}
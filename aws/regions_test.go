@@ -0,0 +1,58 @@
+package aws
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestResolveRegions(t *testing.T) {
+	backend := useFakeEC2(t, nil)
+	backend.Regions = []string{"eu-central-1", "eu-west-1", "us-east-1", "us-west-2", "ap-south-1"}
+
+	tests := []struct {
+		name    string
+		specs   []string
+		want    string
+		wantErr bool
+	}{
+		{name: "region names", specs: []string{"us-east-1", "mars-north-1"}, want: "us-east-1,mars-north-1"},
+		{name: "all", specs: []string{"all"}, want: "ap-south-1,eu-central-1,eu-west-1,us-east-1,us-west-2"},
+		{name: "all except", specs: []string{"all-except=us-west-2", "ap-south-1"}, want: "eu-central-1,eu-west-1,us-east-1"},
+		{name: "all except a group", specs: []string{"all-except=eu"}, want: "ap-south-1,us-east-1,us-west-2"},
+		{name: "groups and names", specs: []string{"eu", "us-east-1"}, want: "eu-central-1,eu-west-1,us-east-1"},
+		{name: "unknown group", specs: []string{"me"}, wantErr: true},
+		{name: "disabled region next to a group", specs: []string{"eu", "me-south-1"}, wantErr: true},
+		{name: "nothing left", specs: []string{"all-except=eu", "us", "ap"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolveRegions(t.Context(), tt.specs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolveRegions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && strings.Join(got, ",") != tt.want {
+				t.Errorf("ResolveRegions() = %s, want %s", strings.Join(got, ","), tt.want)
+			}
+		})
+	}
+}
+
+func TestRegionsEnabledInAccounts(t *testing.T) {
+	backend := useFakeEC2(t, []string{"222222222222", "333333333333"})
+	backend.Regions = []string{"eu-west-1", "us-east-1", "ap-east-1"}
+	// ap-east-1 is an opt-in region that only 222222222222 opted in to
+	backend.DisableRegion("333333333333", "ap-east-1")
+
+	got, err := RegionsEnabledInAccounts(t.Context(), []string{"ap-east-1", "eu-west-1", "us-east-1"}, []string{"222222222222", "333333333333"})
+	if err != nil {
+		t.Fatalf("RegionsEnabledInAccounts() error = %v", err)
+	}
+	if strings.Join(got, ",") != "eu-west-1,us-east-1" {
+		t.Errorf("RegionsEnabledInAccounts() = %v, want [eu-west-1 us-east-1]", got)
+	}
+
+	if _, err := RegionsEnabledInAccounts(t.Context(), []string{"ap-east-1"}, []string{"333333333333"}); err == nil {
+		t.Error("RegionsEnabledInAccounts() error = nil, want an error when no region is left")
+	}
+}
@@ -0,0 +1,280 @@
+package aws
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// CopyResult summarizes a Copy operation per target region.
+type CopyResult struct {
+	SourceAmiID  string
+	SourceRegion string
+
+	// SourceKeyAccess holds, per account, whether the KMS keys encrypting the source AMI can be
+	// used, with CopyModeOwnedCopy.
+	SourceKeyAccess []KeyAccessResult
+
+	Regions map[string]*RegionCopyResult
+}
+
+// RegionCopyResult is the outcome of copying the AMI to a single region.
+type RegionCopyResult struct {
+	Region string
+	// AmiID is the ID of the regional AMI. It is also set when the copy was started but failed
+	// afterwards, so the caller can report or clean up the orphan.
+	AmiID string
+	// Reused is set when an earlier copy of the AMI in the region was reused instead of copying
+	// it again.
+	Reused bool
+	// Resumed is set when the run being resumed already completed the region, so nothing was done.
+	Resumed bool
+	// Retries is the number of times the copy failed and was copied again.
+	Retries int
+	// Pending is set when the copy was started without waiting for it, so it is not shared or
+	// tagged yet.
+	Pending bool
+
+	// CopyErr is set when the regional copy could not be created or did not become available.
+	CopyErr error
+	// PermissionErr is set when launch permissions could not be granted to the accounts.
+	PermissionErr error
+	// Snapshots holds the result per EBS snapshot, when snapshots were shared.
+	Snapshots []SnapshotShareResult
+	// KeyAccess holds, per account, whether the KMS keys encrypting the copy can be used.
+	KeyAccess []KeyAccessResult
+	// KeyAccessErr is set when the KMS keys of the copy could not be checked.
+	KeyAccessErr error
+	// TagErrors holds the tagging failures per account.
+	TagErrors map[string]error
+	// AccountCopies holds the copy every target account made in the region, with
+	// CopyModeOwnedCopy. AmiID is then empty, since the default account makes no copy.
+	AccountCopies []AccountCopyResult
+}
+
+// AccountCopyResult is the copy of the AMI that a target account owns in a region.
+type AccountCopyResult struct {
+	Account string
+	// AmiID is the ID of the account's copy. It is also set when the copy was started but failed
+	// afterwards.
+	AmiID string
+	// Reused is set when an earlier copy in the account was reused.
+	Reused bool
+
+	// CopyErr is set when the copy could not be created or did not become available.
+	CopyErr error
+	// TagErr is set when the copy could not be tagged.
+	TagErr error
+}
+
+// Err joins the failures of the account's copy into a single error, or returns nil.
+func (r AccountCopyResult) Err() error {
+	var errs []error
+	if r.CopyErr != nil {
+		errs = append(errs, fmt.Errorf("copy: %w", r.CopyErr))
+	}
+	if r.TagErr != nil {
+		errs = append(errs, fmt.Errorf("tags: %w", r.TagErr))
+	}
+	return errors.Join(errs...)
+}
+
+func newCopyResult(ami *Ami) *CopyResult {
+	result := &CopyResult{
+		SourceAmiID:  ami.SourceAmiID,
+		SourceRegion: ami.SourceRegion,
+		Regions:      make(map[string]*RegionCopyResult, len(ami.AmisPerRegion)),
+	}
+	for region := range ami.AmisPerRegion {
+		result.Regions[region] = &RegionCopyResult{
+			Region:    region,
+			TagErrors: make(map[string]error),
+		}
+	}
+	return result
+}
+
+// Failed returns true if anything went wrong in the region. Missing KMS key access is only a
+// warning, unless a grant to fix it failed.
+func (r *RegionCopyResult) Failed() bool {
+	return r.CopyErr != nil || r.PermissionErr != nil || len(snapshotShareErrors(r.Snapshots)) > 0 ||
+		len(keyGrantErrors(r.KeyAccess)) > 0 || len(r.TagErrors) > 0 || len(accountCopyErrors(r.AccountCopies)) > 0
+}
+
+// Err joins every failure in the region into a single error, or returns nil.
+func (r *RegionCopyResult) Err() error {
+	var errs []error
+	if r.CopyErr != nil {
+		errs = append(errs, fmt.Errorf("copy: %w", r.CopyErr))
+	}
+	if r.PermissionErr != nil {
+		errs = append(errs, fmt.Errorf("launch permissions: %w", r.PermissionErr))
+	}
+	errs = append(errs, snapshotShareErrors(r.Snapshots)...)
+	errs = append(errs, keyGrantErrors(r.KeyAccess)...)
+	for _, account := range slices.Sorted(maps.Keys(r.TagErrors)) {
+		errs = append(errs, fmt.Errorf("tags for account %s: %w", account, r.TagErrors[account]))
+	}
+	errs = append(errs, accountCopyErrors(r.AccountCopies)...)
+	return errors.Join(errs...)
+}
+
+func accountCopyErrors(results []AccountCopyResult) []error {
+	var errs []error
+	for _, result := range results {
+		if err := result.Err(); err != nil {
+			errs = append(errs, fmt.Errorf("copy of account %s: %w", result.Account, err))
+		}
+	}
+	return errs
+}
+
+// SortedRegions returns the per-region results ordered by region name.
+func (r *CopyResult) SortedRegions() []*RegionCopyResult {
+	results := make([]*RegionCopyResult, 0, len(r.Regions))
+	for _, region := range slices.Sorted(maps.Keys(r.Regions)) {
+		results = append(results, r.Regions[region])
+	}
+	return results
+}
+
+// FailedRegions returns the regions that had at least one failure, ordered by region name.
+func (r *CopyResult) FailedRegions() []string {
+	var failed []string
+	for _, regionResult := range r.SortedRegions() {
+		if regionResult.Failed() {
+			failed = append(failed, regionResult.Region)
+		}
+	}
+	return failed
+}
+
+// PendingCopies returns the ID of every copy that was started without waiting for it, by region.
+func (r *CopyResult) PendingCopies() map[string]string {
+	pending := make(map[string]string)
+	for region, regionResult := range r.Regions {
+		if regionResult.Pending {
+			pending[region] = regionResult.AmiID
+		}
+	}
+	return pending
+}
+
+// Err joins the failures of every region into a single error, or returns nil when all regions succeeded.
+func (r *CopyResult) Err() error {
+	var errs []error
+	for _, regionResult := range r.SortedRegions() {
+		if err := regionResult.Err(); err != nil {
+			errs = append(errs, fmt.Errorf("region %s: %w", regionResult.Region, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// CleanupResult summarizes a Cleanup operation per region. It is also the plan of a dry run, and
+// is marshalled as such by cleanup --output json.
+type CleanupResult struct {
+	SourceAmiID string          `json:"sourceAmiId"`
+	DryRun      bool            `json:"dryRun"`
+	Retention   RetentionPolicy `json:"retention"`
+
+	Regions map[string]*RegionCleanupResult `json:"regions"`
+}
+
+// RegionCleanupResult is the outcome of the cleanup of a single region.
+type RegionCleanupResult struct {
+	Region string `json:"region"`
+	// Images holds every version of the AMI in the region, newest first.
+	Images []CleanupImage `json:"images"`
+}
+
+// CleanupAction is what Cleanup does with a version of the AMI.
+type CleanupAction string
+
+const (
+	CleanupActionKeep       CleanupAction = "keep"
+	CleanupActionDeregister CleanupAction = "deregister"
+	// CleanupActionSkip is set for images that are not versions Cleanup can remove.
+	CleanupActionSkip CleanupAction = "skip"
+)
+
+// CleanupReason is why Cleanup keeps a version of the AMI, or skips an image.
+type CleanupReason string
+
+const (
+	// CleanupReasonNewest is set for the newest versions, up to RetentionPolicy.KeepNewest.
+	CleanupReasonNewest CleanupReason = "newest"
+	// CleanupReasonMinAge is set for versions younger than RetentionPolicy.MinAge.
+	CleanupReasonMinAge CleanupReason = "min-age"
+	// CleanupReasonWithin is set for versions younger than RetentionPolicy.KeepWithin.
+	CleanupReasonWithin CleanupReason = "within"
+	// CleanupReasonMonthly is set for the newest version of a month within
+	// RetentionPolicy.KeepMonthly.
+	CleanupReasonMonthly CleanupReason = "monthly"
+	// CleanupReasonProtected is set for versions with a protect tag that the policy does not keep.
+	CleanupReasonProtected CleanupReason = "protected"
+	// CleanupReasonInUse is set for versions that the policy does not keep but something still
+	// uses.
+	CleanupReasonInUse CleanupReason = "in-use"
+	// CleanupReasonNotOwned is set for images of another account, which cannot be deregistered.
+	CleanupReasonNotOwned CleanupReason = "not-owned"
+)
+
+// CleanupImage is what Cleanup does, or plans to do, with a single version of the AMI.
+type CleanupImage struct {
+	AmiID        string `json:"amiId"`
+	Name         string `json:"name,omitempty"`
+	CreationDate string `json:"creationDate,omitempty"`
+	// OwnerID is set for images of another account than the default account.
+	OwnerID string `json:"ownerId,omitempty"`
+	// Group holds the values of the RetentionPolicy.GroupBy tags of the AMI, as key=value.
+	Group string `json:"group,omitempty"`
+	// SnapshotIDs are the EBS snapshots that are deleted with the AMI when it is deregistered.
+	SnapshotIDs []string `json:"snapshotIds,omitempty"`
+
+	Action CleanupAction `json:"action"`
+	Reason CleanupReason `json:"reason,omitempty"`
+	// ProtectTag is the tag, as key=value, that protects the AMI.
+	ProtectTag string `json:"protectTag,omitempty"`
+	// UsedBy lists what uses the AMI.
+	UsedBy []string `json:"usedBy,omitempty"`
+	// Deregistered is set once the AMI is deregistered. It is never set in a dry run.
+	Deregistered bool `json:"deregistered"`
+	// Error is why the AMI could not be deregistered.
+	Error string `json:"error,omitempty"`
+}
+
+// AmiIDs returns the IDs of the versions with action, newest first.
+func (r *RegionCleanupResult) AmiIDs(action CleanupAction) []string {
+	var amiIDs []string
+	for _, image := range r.Images {
+		if image.Action == action {
+			amiIDs = append(amiIDs, image.AmiID)
+		}
+	}
+	return amiIDs
+}
+
+// Err joins the versions that could not be deregistered in every region into a single error, or
+// returns nil.
+func (r *CleanupResult) Err() error {
+	var errs []error
+	for _, regionResult := range r.SortedRegions() {
+		for _, image := range regionResult.Images {
+			if image.Error != "" {
+				errs = append(errs, fmt.Errorf("region %s: AMI %s: %s", regionResult.Region, image.AmiID, image.Error))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SortedRegions returns the region results ordered by region name.
+func (r *CleanupResult) SortedRegions() []*RegionCleanupResult {
+	results := make([]*RegionCleanupResult, 0, len(r.Regions))
+	for _, region := range slices.Sorted(maps.Keys(r.Regions)) {
+		results = append(results, r.Regions[region])
+	}
+	return results
+}
@@ -0,0 +1,222 @@
+package aws
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// RetentionPolicy decides which versions of an AMI Cleanup keeps. A version is kept when any of the
+// rules keeps it. The rules are evaluated per region, and per group of versions with the same
+// values for the GroupBy tags.
+type RetentionPolicy struct {
+	// KeepNewest keeps the newest versions.
+	KeepNewest int
+	// KeepWithin keeps the versions created less than this long ago.
+	KeepWithin time.Duration
+	// KeepMonthly keeps the newest version of every calendar month, for this many months up to and
+	// including the current month.
+	KeepMonthly int
+	// MinAge never removes versions created less than this long ago, whatever the other rules
+	// decide.
+	MinAge time.Duration
+	// GroupBy are the keys of the tags whose values group the versions. Versions without one of
+	// the tags share a group with an empty value for it.
+	GroupBy []string
+}
+
+// retentionFile is the JSON form of a RetentionPolicy, with the durations as strings that
+// ParseRetentionDuration accepts.
+type retentionFile struct {
+	KeepNewest  *int     `json:"keepNewest,omitempty"`
+	KeepWithin  string   `json:"keepWithin,omitempty"`
+	KeepMonthly int      `json:"keepMonthly,omitempty"`
+	MinAge      string   `json:"minAge,omitempty"`
+	GroupBy     []string `json:"groupBy,omitempty"`
+}
+
+// LoadRetentionPolicy reads a retention policy from a JSON file, e.g.
+//
+//	{"keepNewest": 3, "keepWithin": "30d", "keepMonthly": 6, "minAge": "7d", "groupBy": ["Role"]}
+//
+// The rules that are left out are not applied, except keepNewest, which defaults to
+// defaultKeepNewest.
+func LoadRetentionPolicy(path string, defaultKeepNewest int) (RetentionPolicy, error) {
+	policy := RetentionPolicy{KeepNewest: defaultKeepNewest}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return policy, fmt.Errorf("reading retention policy: %w", err)
+	}
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return policy, fmt.Errorf("parsing retention policy %s: %w", path, err)
+	}
+	return policy, policy.Validate()
+}
+
+// MarshalJSON writes the policy in the form LoadRetentionPolicy reads.
+func (p RetentionPolicy) MarshalJSON() ([]byte, error) {
+	return json.Marshal(retentionFile{
+		KeepNewest:  &p.KeepNewest,
+		KeepWithin:  formatRetentionDuration(p.KeepWithin),
+		KeepMonthly: p.KeepMonthly,
+		MinAge:      formatRetentionDuration(p.MinAge),
+		GroupBy:     p.GroupBy,
+	})
+}
+
+// UnmarshalJSON reads the policy in the form LoadRetentionPolicy reads. Unknown fields are an error,
+// so a misspelled rule is not silently ignored. keepNewest keeps its value when it is left out.
+func (p *RetentionPolicy) UnmarshalJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var file retentionFile
+	if err := decoder.Decode(&file); err != nil {
+		return err
+	}
+	if file.KeepNewest != nil {
+		p.KeepNewest = *file.KeepNewest
+	}
+	var err error
+	if file.KeepWithin != "" {
+		if p.KeepWithin, err = ParseRetentionDuration(file.KeepWithin); err != nil {
+			return fmt.Errorf("keepWithin: %w", err)
+		}
+	}
+	if file.MinAge != "" {
+		if p.MinAge, err = ParseRetentionDuration(file.MinAge); err != nil {
+			return fmt.Errorf("minAge: %w", err)
+		}
+	}
+	p.KeepMonthly = file.KeepMonthly
+	p.GroupBy = file.GroupBy
+	return nil
+}
+
+// ParseRetentionDuration parses a duration such as 720h, 30d or 2w. On top of the units of
+// time.ParseDuration it accepts whole days (d) and weeks (w).
+func ParseRetentionDuration(value string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if number, ok := strings.CutSuffix(value, suffix); ok {
+			count, err := strconv.Atoi(number)
+			if err != nil {
+				return 0, fmt.Errorf("invalid duration %q", value)
+			}
+			return time.Duration(count) * unit, nil
+		}
+	}
+	return time.ParseDuration(value)
+}
+
+// formatRetentionDuration formats d in days when it is a whole number of days.
+func formatRetentionDuration(d time.Duration) string {
+	switch {
+	case d == 0:
+		return ""
+	case d%(24*time.Hour) == 0:
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	default:
+		return d.String()
+	}
+}
+
+// Validate returns an error when a rule of the policy is negative.
+func (p RetentionPolicy) Validate() error {
+	var errs []error
+	if p.KeepNewest < 0 {
+		errs = append(errs, fmt.Errorf("the number of newest versions to keep must not be negative, got %d", p.KeepNewest))
+	}
+	if p.KeepWithin < 0 {
+		errs = append(errs, fmt.Errorf("the age to keep versions within must not be negative, got %s", p.KeepWithin))
+	}
+	if p.KeepMonthly < 0 {
+		errs = append(errs, fmt.Errorf("the number of months to keep a version of must not be negative, got %d", p.KeepMonthly))
+	}
+	if p.MinAge < 0 {
+		errs = append(errs, fmt.Errorf("the minimum age must not be negative, got %s", p.MinAge))
+	}
+	return errors.Join(errs...)
+}
+
+// retention is what the policy decides for a single version.
+type retention struct {
+	// group holds the values of the GroupBy tags, as key=value, separated by commas.
+	group string
+	// reason is why the version is kept, or empty when the policy does not keep it.
+	reason CleanupReason
+}
+
+// evaluate applies the policy to images, per group, at now. It returns the decision for every image
+// by ID.
+func (p RetentionPolicy) evaluate(images []ec2Types.Image, now time.Time) (map[string]retention, error) {
+	type version struct {
+		id      string
+		created time.Time
+	}
+	groups := make(map[string][]version)
+	for _, image := range images {
+		created, err := time.Parse(time.RFC3339, aws.ToString(image.CreationDate))
+		if err != nil {
+			return nil, fmt.Errorf("creation date of image %s: %w", aws.ToString(image.ImageId), err)
+		}
+		group := p.group(image)
+		groups[group] = append(groups[group], version{id: aws.ToString(image.ImageId), created: created})
+	}
+
+	decisions := make(map[string]retention, len(images))
+	for group, versions := range groups {
+		sort.SliceStable(versions, func(i, j int) bool { return versions[i].created.After(versions[j].created) })
+		keptMonths := make(map[string]bool)
+		for i, v := range versions {
+			age := now.Sub(v.created)
+			month := v.created.UTC().Format("2006-01")
+			monthsAgo := monthsBetween(v.created, now)
+
+			decision := retention{group: group}
+			switch {
+			case i < p.KeepNewest:
+				decision.reason = CleanupReasonNewest
+			case age < p.MinAge:
+				decision.reason = CleanupReasonMinAge
+			case age < p.KeepWithin:
+				decision.reason = CleanupReasonWithin
+			case monthsAgo < p.KeepMonthly && !keptMonths[month]:
+				decision.reason = CleanupReasonMonthly
+			}
+			// the newest version of a month counts for the month whichever rule keeps it
+			if decision.reason != "" {
+				keptMonths[month] = true
+			}
+			decisions[v.id] = decision
+		}
+	}
+	return decisions, nil
+}
+
+// group returns the values of the GroupBy tags of image, as key=value separated by commas.
+func (p RetentionPolicy) group(image ec2Types.Image) string {
+	if len(p.GroupBy) == 0 {
+		return ""
+	}
+	tags := convertTagSliceToMap(image.Tags)
+	parts := make([]string, 0, len(p.GroupBy))
+	for _, key := range p.GroupBy {
+		parts = append(parts, key+"="+aws.ToString(tags[key].Value))
+	}
+	return strings.Join(parts, ",")
+}
+
+// monthsBetween returns the number of calendar months from the month of then to the month of now,
+// in UTC.
+func monthsBetween(then time.Time, now time.Time) int {
+	then, now = then.UTC(), now.UTC()
+	return (now.Year()-then.Year())*12 + int(now.Month()) - int(then.Month())
+}
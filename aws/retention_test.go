@@ -0,0 +1,194 @@
+package aws
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func TestRetentionPolicyEvaluate(t *testing.T) {
+	now := time.Date(2024, 7, 15, 12, 0, 0, 0, time.UTC)
+	images := []ec2Types.Image{
+		testImage("ami-2023", "golden-2023", "2023-12-01T00:00:00.000Z", nil),
+		testImage("ami-jan10", "golden-jan10", "2024-01-10T00:00:00.000Z", nil),
+		testImage("ami-mar01", "golden-mar01", "2024-03-01T00:00:00.000Z", nil),
+		testImage("ami-may15", "golden-may15", "2024-05-15T00:00:00.000Z", nil),
+		testImage("ami-jun05", "golden-jun05", "2024-06-05T00:00:00.000Z", nil),
+		testImage("ami-jun20", "golden-jun20", "2024-06-20T00:00:00.000Z", nil),
+		testImage("ami-jul01", "golden-jul01", "2024-07-01T00:00:00.000Z", nil),
+		testImage("ami-jul10", "golden-jul10", "2024-07-10T00:00:00.000Z", nil),
+	}
+
+	tests := []struct {
+		name   string
+		policy RetentionPolicy
+		// want holds the kept images with the reason they are kept
+		want map[string]CleanupReason
+	}{
+		{name: "nothing", policy: RetentionPolicy{}, want: map[string]CleanupReason{}},
+		{
+			name:   "newest",
+			policy: RetentionPolicy{KeepNewest: 2},
+			want:   map[string]CleanupReason{"ami-jul10": CleanupReasonNewest, "ami-jul01": CleanupReasonNewest},
+		},
+		{
+			name:   "within",
+			policy: RetentionPolicy{KeepWithin: 30 * 24 * time.Hour},
+			want:   map[string]CleanupReason{"ami-jul10": CleanupReasonWithin, "ami-jul01": CleanupReasonWithin, "ami-jun20": CleanupReasonWithin},
+		},
+		{
+			name:   "monthly",
+			policy: RetentionPolicy{KeepMonthly: 6},
+			want: map[string]CleanupReason{
+				"ami-jul10": CleanupReasonMonthly, "ami-jun20": CleanupReasonMonthly,
+				"ami-may15": CleanupReasonMonthly, "ami-mar01": CleanupReasonMonthly,
+			},
+		},
+		{
+			name:   "monthly counts months kept by other rules",
+			policy: RetentionPolicy{KeepNewest: 2, KeepMonthly: 2},
+			want:   map[string]CleanupReason{"ami-jul10": CleanupReasonNewest, "ami-jul01": CleanupReasonNewest, "ami-jun20": CleanupReasonMonthly},
+		},
+		{
+			name:   "min age",
+			policy: RetentionPolicy{MinAge: 7 * 24 * time.Hour},
+			want:   map[string]CleanupReason{"ami-jul10": CleanupReasonMinAge},
+		},
+		{
+			name:   "combined",
+			policy: RetentionPolicy{KeepNewest: 1, KeepWithin: 20 * 24 * time.Hour, KeepMonthly: 3, MinAge: 7 * 24 * time.Hour},
+			want: map[string]CleanupReason{
+				"ami-jul10": CleanupReasonNewest, "ami-jul01": CleanupReasonWithin,
+				"ami-jun20": CleanupReasonMonthly, "ami-may15": CleanupReasonMonthly,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decisions, err := tt.policy.evaluate(images, now)
+			if err != nil {
+				t.Fatalf("evaluate() error = %v", err)
+			}
+			if len(decisions) != len(images) {
+				t.Fatalf("evaluate() decided on %d images, want %d", len(decisions), len(images))
+			}
+			got := make(map[string]CleanupReason)
+			for amiID, decision := range decisions {
+				if decision.reason != "" {
+					got[amiID] = decision.reason
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("kept = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetentionPolicyEvaluatesPerGroup(t *testing.T) {
+	now := time.Date(2024, 7, 15, 12, 0, 0, 0, time.UTC)
+	web := map[string]string{"Role": "web"}
+	worker := map[string]string{"Role": "worker"}
+	images := []ec2Types.Image{
+		testImage("ami-web-1", "web-1", "2024-01-01T00:00:00.000Z", web),
+		testImage("ami-web-2", "web-2", "2024-02-01T00:00:00.000Z", web),
+		testImage("ami-worker-1", "worker-1", "2023-01-01T00:00:00.000Z", worker),
+		testImage("ami-worker-2", "worker-2", "2023-02-01T00:00:00.000Z", worker),
+		testImage("ami-untagged", "untagged", "2022-01-01T00:00:00.000Z", nil),
+	}
+
+	decisions, err := RetentionPolicy{KeepNewest: 1, GroupBy: []string{"Role"}}.evaluate(images, now)
+	if err != nil {
+		t.Fatalf("evaluate() error = %v", err)
+	}
+	want := map[string]retention{
+		"ami-web-1":    {group: "Role=web"},
+		"ami-web-2":    {group: "Role=web", reason: CleanupReasonNewest},
+		"ami-worker-1": {group: "Role=worker"},
+		"ami-worker-2": {group: "Role=worker", reason: CleanupReasonNewest},
+		"ami-untagged": {group: "Role=", reason: CleanupReasonNewest},
+	}
+	if !reflect.DeepEqual(decisions, want) {
+		t.Errorf("evaluate() = %v, want %v", decisions, want)
+	}
+}
+
+func TestRetentionPolicyEvaluateRejectsInvalidCreationDate(t *testing.T) {
+	images := []ec2Types.Image{testImage("ami-1", "golden", "yesterday", nil)}
+	if _, err := (RetentionPolicy{KeepNewest: 1}).evaluate(images, time.Now()); err == nil {
+		t.Fatal("evaluate() error = nil, want error for an invalid creation date")
+	}
+}
+
+func TestParseRetentionDuration(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{value: "36h", want: 36 * time.Hour},
+		{value: "30d", want: 30 * 24 * time.Hour},
+		{value: "2w", want: 14 * 24 * time.Hour},
+		{value: "1.5d", wantErr: true},
+		{value: "month", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseRetentionDuration(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseRetentionDuration(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseRetentionDuration(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestLoadRetentionPolicy(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	policy, err := LoadRetentionPolicy(write("policy.json", `{"keepWithin": "30d", "keepMonthly": 6, "minAge": "12h", "groupBy": ["Role"]}`), 5)
+	if err != nil {
+		t.Fatalf("LoadRetentionPolicy() error = %v", err)
+	}
+	want := RetentionPolicy{KeepNewest: 5, KeepWithin: 30 * 24 * time.Hour, KeepMonthly: 6, MinAge: 12 * time.Hour, GroupBy: []string{"Role"}}
+	if !reflect.DeepEqual(policy, want) {
+		t.Errorf("LoadRetentionPolicy() = %+v, want %+v", policy, want)
+	}
+
+	// the policy in a plan reads back the same
+	data, err := json.Marshal(policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded RetentionPolicy
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal(%s) error = %v", data, err)
+	}
+	if !reflect.DeepEqual(decoded, want) {
+		t.Errorf("Unmarshal(%s) = %+v, want %+v", data, decoded, want)
+	}
+
+	for name, content := range map[string]string{
+		"unknown rule":     `{"keepYearly": 2}`,
+		"invalid duration": `{"keepWithin": "a month"}`,
+		"negative":         `{"keepNewest": -1}`,
+	} {
+		if _, err := LoadRetentionPolicy(write(name+".json", content), 5); err == nil {
+			t.Errorf("LoadRetentionPolicy(%s) error = nil, want error", content)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package aws
+
+import (
+	"time"
+
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/ratelimit"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+)
+
+const (
+	// maxRetryAttempts is the number of attempts of an API call, including the first one.
+	maxRetryAttempts = 10
+	// maxRetryBackoff is the longest wait between two attempts.
+	maxRetryBackoff = 30 * time.Second
+)
+
+// throttleErrorCodes are the EC2 errors, on top of the SDK's default retryable errors, that mean a
+// call was refused because too many were made at once. CopyImage fails with ResourceLimitExceeded
+// when too many copies to a region are in progress.
+var throttleErrorCodes = map[string]struct{}{
+	"ResourceLimitExceeded": {},
+	"RequestLimitExceeded":  {},
+}
+
+// newRetryer returns the SDK's standard retryer, which retries with exponential backoff and
+// jitter, set up to also retry the throttleErrorCodes and to try harder. The client-side retry
+// quota is disabled: a fan-out over many regions and accounts would use it up, and then fail
+// calls that a retry would fix.
+func newRetryer() awsv2.Retryer {
+	return retry.NewStandard(func(o *retry.StandardOptions) {
+		o.MaxAttempts = maxRetryAttempts
+		o.MaxBackoff = maxRetryBackoff
+		o.Retryables = append(o.Retryables, retry.RetryableErrorCode{Codes: throttleErrorCodes})
+		o.RateLimiter = ratelimit.None
+	})
+}
@@ -0,0 +1,26 @@
+package aws
+
+import (
+	"testing"
+
+	"github.com/aws/smithy-go"
+)
+
+func TestRetryerRetriesThrottling(t *testing.T) {
+	retryer := newRetryer()
+
+	if got := retryer.MaxAttempts(); got != maxRetryAttempts {
+		t.Errorf("MaxAttempts() = %d, want %d", got, maxRetryAttempts)
+	}
+	for code, want := range map[string]bool{
+		"ResourceLimitExceeded": true,
+		"RequestLimitExceeded":  true,
+		"Throttling":            true,
+		"InvalidAMIID.NotFound": false,
+		"UnauthorizedOperation": false,
+	} {
+		if got := retryer.IsErrorRetryable(&smithy.GenericAPIError{Code: code}); got != want {
+			t.Errorf("IsErrorRetryable(%s) = %v, want %v", code, got, want)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	organizationArnPattern       = regexp.MustCompile(`^arn:aws[a-z-]*:organizations::[0-9]{12}:organization/o-[a-z0-9]{10,32}$`)
+	organizationalUnitArnPattern = regexp.MustCompile(`^arn:aws[a-z-]*:organizations::[0-9]{12}:ou/o-[a-z0-9]{10,32}/ou-[0-9a-z]{4,32}-[a-z0-9]{8,32}$`)
+)
+
+// OrganizationTargets share an AMI with every account in an AWS Organization or in organizational
+// units, on top of the accounts it is shared with.
+type OrganizationTargets struct {
+	// OrganizationArn is the ARN of the organization, e.g. arn:aws:organizations::123456789012:organization/o-abcde12345.
+	OrganizationArn string
+	// OrganizationalUnitArns are the ARNs of the organizational units, e.g.
+	// arn:aws:organizations::123456789012:ou/o-abcde12345/ou-ab12-abcdefgh.
+	OrganizationalUnitArns []string
+}
+
+// Validate checks the organization and organizational unit ARNs.
+func (t OrganizationTargets) Validate() error {
+	var errs []error
+	if t.OrganizationArn != "" && !organizationArnPattern.MatchString(t.OrganizationArn) {
+		errs = append(errs, fmt.Errorf("invalid organization ARN %q: expected arn:aws:organizations::<account>:organization/o-<id>", t.OrganizationArn))
+	}
+	for _, arn := range t.OrganizationalUnitArns {
+		if !organizationalUnitArnPattern.MatchString(arn) {
+			errs = append(errs, fmt.Errorf("invalid organizational unit ARN %q: expected arn:aws:organizations::<account>:ou/o-<id>/ou-<id>", arn))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// IsEmpty reports whether no organization or organizational unit is set.
+func (t OrganizationTargets) IsEmpty() bool {
+	return t.OrganizationArn == "" && len(t.OrganizationalUnitArns) == 0
+}
+
+func (t OrganizationTargets) launchPermissions() []ec2Types.LaunchPermission {
+	var launchPermissions []ec2Types.LaunchPermission
+	if t.OrganizationArn != "" {
+		launchPermissions = append(launchPermissions, ec2Types.LaunchPermission{
+			OrganizationArn: aws.String(t.OrganizationArn),
+		})
+	}
+	for _, arn := range t.OrganizationalUnitArns {
+		launchPermissions = append(launchPermissions, ec2Types.LaunchPermission{
+			OrganizationalUnitArn: aws.String(arn),
+		})
+	}
+	return launchPermissions
+}
+
+// ShareOptions holds the optional settings for Ami.Share.
+type ShareOptions struct {
+	OrganizationTargets
+
+	// ShareSnapshots also grants createVolumePermission on the EBS snapshots of the AMI, so the
+	// accounts can copy the AMI and create volumes from it.
+	ShareSnapshots bool
+}
+
+// SnapshotShareResult is the outcome of sharing a single EBS snapshot.
+type SnapshotShareResult struct {
+	SnapshotID string
+	Err        error
+}
+
+// ShareResult is the outcome of sharing an AMI in its region.
+type ShareResult struct {
+	AmiID  string
+	Region string
+
+	// PermissionErr is set when launch permissions could not be granted to the accounts.
+	PermissionErr error
+	// Snapshots holds the result per EBS snapshot, when snapshots were shared.
+	Snapshots []SnapshotShareResult
+}
+
+// Err joins every failure into a single error, or returns nil.
+func (r *ShareResult) Err() error {
+	var errs []error
+	if r.PermissionErr != nil {
+		errs = append(errs, fmt.Errorf("launch permissions: %w", r.PermissionErr))
+	}
+	errs = append(errs, snapshotShareErrors(r.Snapshots)...)
+	return errors.Join(errs...)
+}
+
+// Share grants launch permissions on the AMI in its region to the accounts and, when requested,
+// createVolumePermission on its EBS snapshots.
+func (ami *Ami) Share(ctx context.Context, accounts []string, opts ShareOptions) (*ShareResult, error) {
+	if err := opts.OrganizationTargets.Validate(); err != nil {
+		return nil, err
+	}
+	if err := ami.fetchMetadata(ctx); err != nil {
+		return nil, err
+	}
+
+	result := &ShareResult{AmiID: ami.SourceAmiID, Region: ami.SourceRegion}
+	result.PermissionErr = ami.setOwners(ctx, accounts, opts.OrganizationTargets)
+	if result.PermissionErr != nil {
+		log.Errorf("Setting launch permissions on AMI %s failed: %v", ami.SourceAmiID, result.PermissionErr)
+	}
+	if opts.ShareSnapshots {
+		warnSnapshotsNotSharedWithOrganizations(opts.OrganizationTargets)
+		result.Snapshots = ami.shareSnapshots(ctx, accounts)
+	}
+	return result, nil
+}
+
+// warnSnapshotsNotSharedWithOrganizations warns that snapshots, unlike AMIs, can only be shared
+// with accounts.
+func warnSnapshotsNotSharedWithOrganizations(orgs OrganizationTargets) {
+	if !orgs.IsEmpty() {
+		log.Warn("EBS snapshots cannot be shared with organizations or organizational units; they are only shared with the listed accounts")
+	}
+}
+
+// shareSnapshots grants createVolumePermission to the accounts on every EBS snapshot of the AMI.
+// A failure on one snapshot does not stop the others.
+func (ami *Ami) shareSnapshots(ctx context.Context, accounts []string) []SnapshotShareResult {
+	ec2Service := getEC2ServiceForAccountAndRegion(*ConfigManager.defaultAccountID, ami.SourceRegion)
+
+	if len(accounts) == 0 {
+		return nil
+	}
+	snapshotIDs := snapshotIDsOf(ami.AWSImage)
+	if len(snapshotIDs) == 0 {
+		log.Warnf("AMI %s has no EBS snapshots to share", ami.SourceAmiID)
+		return nil
+	}
+
+	results := make([]SnapshotShareResult, 0, len(snapshotIDs))
+	for _, snapshotID := range snapshotIDs {
+		log.Infof("Sharing snapshot %s of AMI %s", snapshotID, ami.SourceAmiID)
+		_, err := ec2Service.ModifySnapshotAttribute(ctx, &ec2.ModifySnapshotAttributeInput{
+			SnapshotId: aws.String(snapshotID),
+			Attribute:  ec2Types.SnapshotAttributeNameCreateVolumePermission,
+			CreateVolumePermission: &ec2Types.CreateVolumePermissionModifications{
+				Add: createVolumePermissionsForOwners(accounts),
+			},
+		})
+		if err != nil {
+			log.Errorf("Sharing snapshot %s failed: %v", snapshotID, err)
+		}
+		results = append(results, SnapshotShareResult{SnapshotID: snapshotID, Err: err})
+	}
+	return results
+}
+
+func createVolumePermissionsForOwners(owners []string) []ec2Types.CreateVolumePermission {
+	permissions := make([]ec2Types.CreateVolumePermission, 0, len(owners))
+	for _, owner := range owners {
+		permissions = append(permissions, ec2Types.CreateVolumePermission{
+			UserId: aws.String(owner),
+		})
+	}
+	return permissions
+}
+
+// snapshotIDsOf returns the IDs of the EBS snapshots backing the image.
+func snapshotIDsOf(image *ec2Types.Image) []string {
+	snapshotIDs := []string{}
+	if image == nil {
+		return snapshotIDs
+	}
+	for _, mapping := range image.BlockDeviceMappings {
+		if mapping.Ebs != nil && mapping.Ebs.SnapshotId != nil {
+			snapshotIDs = append(snapshotIDs, *mapping.Ebs.SnapshotId)
+		}
+	}
+	return snapshotIDs
+}
+
+func snapshotShareErrors(results []SnapshotShareResult) []error {
+	var errs []error
+	for _, result := range results {
+		if result.Err != nil {
+			errs = append(errs, fmt.Errorf("snapshot %s: %w", result.SnapshotID, result.Err))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,152 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/cloudnatives/aws-ami-manager/internal/ec2fake"
+)
+
+// failingSnapshotEC2 fails ModifySnapshotAttribute for a single snapshot.
+type failingSnapshotEC2 struct {
+	*ec2fake.Client
+	snapshotID string
+}
+
+func (f *failingSnapshotEC2) ModifySnapshotAttribute(ctx context.Context, params *ec2.ModifySnapshotAttributeInput, optFns ...func(*ec2.Options)) (*ec2.ModifySnapshotAttributeOutput, error) {
+	if *params.SnapshotId == f.snapshotID {
+		return nil, errors.New("InvalidSnapshot.NotFound")
+	}
+	return f.Client.ModifySnapshotAttribute(ctx, params, optFns...)
+}
+
+func TestShareGrantsLaunchAndVolumePermissions(t *testing.T) {
+	consumers := []string{"222222222222", "333333333333"}
+	backend := useFakeEC2(t, nil)
+	backend.AddImage(testDefaultAccount, testDefaultRegion, testImage("ami-shared", "golden", "2024-01-01T00:00:00.000Z", nil, "snap-root", "snap-data"))
+
+	ami := NewAmi("ami-shared")
+	ami.SourceRegion = testDefaultRegion
+
+	result, err := ami.Share(t.Context(), consumers, ShareOptions{ShareSnapshots: true})
+	if err != nil {
+		t.Fatalf("Share() error = %v", err)
+	}
+	if err := result.Err(); err != nil {
+		t.Fatalf("Share() result error = %v", err)
+	}
+
+	if modified := callInputs[*ec2.ModifyImageAttributeInput](backend, testDefaultAccount, testDefaultRegion, "ModifyImageAttribute"); len(modified) != 1 {
+		t.Errorf("ModifyImageAttribute calls = %d, want one call adding every account", len(modified))
+	}
+	if got := backend.LaunchPermissions("ami-shared"); !slices.Equal(got, consumers) {
+		t.Errorf("launch permissions = %v, want %v", got, consumers)
+	}
+	for _, snapshot := range []string{"snap-root", "snap-data"} {
+		if got := backend.SnapshotPermissions(snapshot); !slices.Equal(got, consumers) {
+			t.Errorf("createVolumePermission of %s = %v, want %v", snapshot, got, consumers)
+		}
+	}
+}
+
+func TestShareReportsPerSnapshotFailures(t *testing.T) {
+	backend := useFakeEC2(t, nil)
+	backend.AddImage(testDefaultAccount, testDefaultRegion, testImage("ami-shared", "golden", "2024-01-01T00:00:00.000Z", nil, "snap-root", "snap-data"))
+	ConfigManager.SetEC2ClientFactory(func(account string, conf awsv2.Config) EC2API {
+		return &failingSnapshotEC2{Client: backend.EC2(account, conf.Region), snapshotID: "snap-root"}
+	})
+
+	ami := NewAmi("ami-shared")
+	ami.SourceRegion = testDefaultRegion
+
+	result, err := ami.Share(t.Context(), []string{"222222222222"}, ShareOptions{ShareSnapshots: true})
+	if err != nil {
+		t.Fatalf("Share() error = %v", err)
+	}
+
+	if len(result.Snapshots) != 2 {
+		t.Fatalf("snapshot results = %d, want 2", len(result.Snapshots))
+	}
+	if result.Snapshots[0].Err == nil || result.Snapshots[1].Err != nil {
+		t.Errorf("snapshot results = %+v, want only snap-root to fail", result.Snapshots)
+	}
+	if result.Err() == nil {
+		t.Error("Err() = nil, want the snapshot failure")
+	}
+}
+
+func TestShareWithoutSnapshots(t *testing.T) {
+	backend := useFakeEC2(t, nil)
+	backend.AddImage(testDefaultAccount, testDefaultRegion, testImage("ami-shared", "golden", "2024-01-01T00:00:00.000Z", nil, "snap-root"))
+
+	ami := NewAmi("ami-shared")
+	ami.SourceRegion = testDefaultRegion
+
+	if _, err := ami.Share(t.Context(), []string{"222222222222"}, ShareOptions{}); err != nil {
+		t.Fatalf("Share() error = %v", err)
+	}
+	if got := backend.SnapshotPermissions("snap-root"); len(got) != 0 {
+		t.Errorf("createVolumePermission of snap-root = %v, want none", got)
+	}
+}
+
+const (
+	testOrganizationArn = "arn:aws:organizations::111111111111:organization/o-abcde12345"
+	testOUArn           = "arn:aws:organizations::111111111111:ou/o-abcde12345/ou-ab12-abcdefgh"
+)
+
+func TestOrganizationTargetsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		targets OrganizationTargets
+		wantErr bool
+	}{
+		{name: "empty", targets: OrganizationTargets{}},
+		{name: "organization and unit", targets: OrganizationTargets{OrganizationArn: testOrganizationArn, OrganizationalUnitArns: []string{testOUArn}}},
+		{name: "account id as organization", targets: OrganizationTargets{OrganizationArn: "222222222222"}, wantErr: true},
+		{name: "organization as unit", targets: OrganizationTargets{OrganizationalUnitArns: []string{testOrganizationArn}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.targets.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestShareWithOrganizations(t *testing.T) {
+	backend := useFakeEC2(t, nil)
+	backend.AddImage(testDefaultAccount, testDefaultRegion, testImage("ami-shared", "golden", "2024-01-01T00:00:00.000Z", nil, "snap-root"))
+
+	ami := NewAmi("ami-shared")
+	ami.SourceRegion = testDefaultRegion
+
+	opts := ShareOptions{
+		OrganizationTargets: OrganizationTargets{OrganizationArn: testOrganizationArn, OrganizationalUnitArns: []string{testOUArn}},
+		ShareSnapshots:      true,
+	}
+	if _, err := ami.Share(t.Context(), []string{"222222222222"}, opts); err != nil {
+		t.Fatalf("Share() error = %v", err)
+	}
+
+	modified := callInputs[*ec2.ModifyImageAttributeInput](backend, testDefaultAccount, testDefaultRegion, "ModifyImageAttribute")
+	if len(modified) != 1 {
+		t.Fatalf("ModifyImageAttribute calls = %d, want 1", len(modified))
+	}
+	added := modified[0].LaunchPermission.Add
+	if len(added) != 3 || *added[0].UserId != "222222222222" || *added[1].OrganizationArn != testOrganizationArn || *added[2].OrganizationalUnitArn != testOUArn {
+		t.Errorf("launch permissions added = %+v, want the account, the organization and the unit", added)
+	}
+	if got := backend.OrganizationLaunchPermissions("ami-shared"); !slices.Equal(got, []string{testOrganizationArn, testOUArn}) {
+		t.Errorf("organization launch permissions = %v, want the organization and the unit", got)
+	}
+	if got := backend.SnapshotPermissions("snap-root"); !slices.Equal(got, []string{"222222222222"}) {
+		t.Errorf("snapshots were not shared with the account only: %v", got)
+	}
+}
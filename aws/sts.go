@@ -0,0 +1,25 @@
+package aws
+
+import (
+	"context"
+
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// STSAPI is the subset of the STS client used by this package. AssumeRole is called by the
+// credential providers of the assumed roles.
+type STSAPI interface {
+	GetCallerIdentity(ctx context.Context, params *sts.GetCallerIdentityInput, optFns ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error)
+	AssumeRole(ctx context.Context, params *sts.AssumeRoleInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleOutput, error)
+}
+
+var _ STSAPI = (*sts.Client)(nil)
+
+// STSClientFactory builds the STS clients used to resolve caller identities and to assume roles.
+// The account is empty when the identity of the default credentials is being resolved.
+type STSClientFactory func(account string, conf awsv2.Config) STSAPI
+
+func newSTSClient(_ string, conf awsv2.Config) STSAPI {
+	return sts.NewFromConfig(conf)
+}
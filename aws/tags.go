@@ -0,0 +1,215 @@
+package aws
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+const (
+	// reservedTagPrefix marks tag keys that only AWS can set. They are never copied.
+	reservedTagPrefix = "aws:"
+
+	maxTagsPerResource = 50
+	maxTagKeyLength    = 128
+	maxTagValueLength  = 256
+)
+
+// TagTemplateData holds the values available to tag value templates, e.g. {{.SourceAmiID}}.
+type TagTemplateData struct {
+	SourceAmiID   string
+	SourceAmiName string
+	SourceRegion  string
+	// AmiID and Region are those of the regional copy being tagged.
+	AmiID  string
+	Region string
+	// CopiedAt is the time the copy was started, in RFC 3339 format.
+	CopiedAt string
+}
+
+// TagRules transform the tags of the source AMI into the tags of its regional copies. Tags are
+// dropped first, then renamed, and then added, so added tags win. Keys with the reserved aws:
+// prefix are always dropped, since they cannot be set.
+type TagRules struct {
+	// Add sets tags. Values are text/template templates expanded with TagTemplateData, e.g.
+	// "SourceAmiId": "{{.SourceAmiID}}".
+	Add map[string]string
+	// Rename maps a source tag key to the key it is copied as.
+	Rename map[string]string
+	// Drop lists the tag keys that are not copied. A trailing * matches every key with that prefix.
+	Drop []string
+}
+
+// Validate checks the tag keys and parses the value templates.
+func (r TagRules) Validate() error {
+	var errs []error
+	for _, key := range slices.Sorted(maps.Keys(r.Add)) {
+		if err := validateTagKey(key); err != nil {
+			errs = append(errs, err)
+		}
+		if _, err := expandTagTemplate(key, r.Add[key], TagTemplateData{}); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, from := range slices.Sorted(maps.Keys(r.Rename)) {
+		if err := validateTagKey(r.Rename[from]); err != nil {
+			errs = append(errs, fmt.Errorf("renaming tag %s: %w", from, err))
+		}
+	}
+	for _, pattern := range r.Drop {
+		if strings.TrimSuffix(pattern, "*") == "" {
+			errs = append(errs, fmt.Errorf("invalid tag to drop %q: expected a key or a key prefix followed by *", pattern))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Apply returns the tags for a regional copy, sorted by key.
+func (r TagRules) Apply(tags []ec2Types.Tag, data TagTemplateData) ([]ec2Types.Tag, error) {
+	values := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		key := aws.ToString(tag.Key)
+		if strings.HasPrefix(key, reservedTagPrefix) || r.drops(key) {
+			continue
+		}
+		if renamed, ok := r.Rename[key]; ok {
+			key = renamed
+		}
+		values[key] = aws.ToString(tag.Value)
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(r.Add)) {
+		value, err := expandTagTemplate(key, r.Add[key], data)
+		if err != nil {
+			return nil, err
+		}
+		if len(value) > maxTagValueLength {
+			return nil, fmt.Errorf("the value of tag %s is longer than %d characters", key, maxTagValueLength)
+		}
+		values[key] = value
+	}
+
+	if len(values) > maxTagsPerResource {
+		return nil, fmt.Errorf("the copy would get %d tags, more than the %d EC2 allows", len(values), maxTagsPerResource)
+	}
+
+	result := make([]ec2Types.Tag, 0, len(values))
+	for _, key := range slices.Sorted(maps.Keys(values)) {
+		result = append(result, ec2Types.Tag{Key: aws.String(key), Value: aws.String(values[key])})
+	}
+	return result, nil
+}
+
+func (r TagRules) drops(key string) bool {
+	for _, pattern := range r.Drop {
+		if prefix, isPrefix := strings.CutSuffix(pattern, "*"); pattern == key || (isPrefix && strings.HasPrefix(key, prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+func validateTagKey(key string) error {
+	switch {
+	case key == "":
+		return errors.New("empty tag key")
+	case len(key) > maxTagKeyLength:
+		return fmt.Errorf("tag key %q is longer than %d characters", key, maxTagKeyLength)
+	case strings.HasPrefix(key, reservedTagPrefix):
+		return fmt.Errorf("tag key %q uses the reserved %s prefix", key, reservedTagPrefix)
+	}
+	return nil
+}
+
+// expandTagTemplate executes the value template of the tag with key against data.
+func expandTagTemplate(key string, value string, data TagTemplateData) (string, error) {
+	tmpl, err := template.New(key).Parse(value)
+	if err != nil {
+		return "", fmt.Errorf("invalid template for tag %s: %w", key, err)
+	}
+	var expanded strings.Builder
+	if err := tmpl.Execute(&expanded, data); err != nil {
+		return "", fmt.Errorf("invalid template for tag %s: %w", key, err)
+	}
+	return expanded.String(), nil
+}
+
+// copyTags are the tags set on a regional copy and its snapshots in the CopyImage request.
+type copyTags struct {
+	// copySourceTags copies the tags of the source AMI with CopyImageTags, so image only holds
+	// the tags the source does not have.
+	copySourceTags bool
+	image          []ec2Types.Tag
+	snapshots      []ec2Types.Tag
+}
+
+// atCopy returns the tags to set in the CopyImage request, or nil when the copy has to be tagged
+// once it is available: when a tag value depends on the ID of the copy, which CopyImage only
+// returns, or when the tags cannot be built, so the error is reported by the later tagging.
+func (r TagRules) atCopy(tags []ec2Types.Tag, data TagTemplateData) *copyTags {
+	data.AmiID = ""
+	snapshotTags, err := r.Apply(tags, data)
+	if err != nil || r.usesCopyID(data) {
+		return nil
+	}
+	if len(snapshotTags) == 0 {
+		return &copyTags{}
+	}
+
+	source := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		source[aws.ToString(tag.Key)] = true
+	}
+	// CopyImageTags copies every tag of the source AMI, so it only fits when no tag is dropped,
+	// renamed or overwritten
+	copySourceTags := len(r.Drop) == 0 && len(r.Rename) == 0
+	for key := range r.Add {
+		copySourceTags = copySourceTags && !source[key]
+	}
+	for _, tag := range tags {
+		copySourceTags = copySourceTags && !strings.HasPrefix(aws.ToString(tag.Key), reservedTagPrefix)
+	}
+
+	result := &copyTags{copySourceTags: copySourceTags, image: snapshotTags, snapshots: snapshotTags}
+	if copySourceTags {
+		result.image = nil
+		for _, tag := range snapshotTags {
+			if !source[aws.ToString(tag.Key)] {
+				result.image = append(result.image, tag)
+			}
+		}
+	}
+	return result
+}
+
+// usesCopyID reports whether any added tag value changes with the ID of the copy.
+func (r TagRules) usesCopyID(data TagTemplateData) bool {
+	for _, key := range slices.Sorted(maps.Keys(r.Add)) {
+		data.AmiID = "ami-a"
+		first, _ := expandTagTemplate(key, r.Add[key], data)
+		data.AmiID = "ami-b"
+		second, _ := expandTagTemplate(key, r.Add[key], data)
+		if first != second {
+			return true
+		}
+	}
+	return false
+}
+
+// tagSpecifications returns the TagSpecifications of a CopyImage request.
+func (t *copyTags) tagSpecifications() []ec2Types.TagSpecification {
+	var specs []ec2Types.TagSpecification
+	if len(t.image) > 0 {
+		specs = append(specs, ec2Types.TagSpecification{ResourceType: ec2Types.ResourceTypeImage, Tags: t.image})
+	}
+	if len(t.snapshots) > 0 {
+		specs = append(specs, ec2Types.TagSpecification{ResourceType: ec2Types.ResourceTypeSnapshot, Tags: t.snapshots})
+	}
+	return specs
+}
@@ -0,0 +1,141 @@
+package aws
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func TestTagRulesValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   TagRules
+		wantErr bool
+	}{
+		{name: "empty", rules: TagRules{}},
+		{name: "template", rules: TagRules{Add: map[string]string{"SourceAmiId": "{{.SourceAmiID}}"}}},
+		{name: "unknown field", rules: TagRules{Add: map[string]string{"SourceAmiId": "{{.Unknown}}"}}, wantErr: true},
+		{name: "unparsable template", rules: TagRules{Add: map[string]string{"SourceAmiId": "{{.SourceAmiID"}}, wantErr: true},
+		{name: "reserved key", rules: TagRules{Add: map[string]string{"aws:owner": "me"}}, wantErr: true},
+		{name: "rename to reserved key", rules: TagRules{Rename: map[string]string{"Owner": "aws:owner"}}, wantErr: true},
+		{name: "drop everything", rules: TagRules{Drop: []string{"*"}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.rules.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTagRulesApply(t *testing.T) {
+	source := []ec2Types.Tag{
+		{Key: aws.String("Name"), Value: aws.String("golden")},
+		{Key: aws.String("Build"), Value: aws.String("42")},
+		{Key: aws.String("ci:pipeline"), Value: aws.String("nightly")},
+		{Key: aws.String("ci:runner"), Value: aws.String("runner-7")},
+		{Key: aws.String("aws:cloudformation:stack-name"), Value: aws.String("images")},
+	}
+	data := TagTemplateData{SourceAmiID: "ami-source", SourceRegion: "eu-west-1", AmiID: "ami-copy", Region: "us-east-1"}
+
+	tests := []struct {
+		name  string
+		rules TagRules
+		want  string
+	}{
+		{name: "no rules", rules: TagRules{}, want: "Build=42,Name=golden,ci:pipeline=nightly,ci:runner=runner-7"},
+		{name: "drop prefix", rules: TagRules{Drop: []string{"ci:*", "Build"}}, want: "Name=golden"},
+		{name: "rename", rules: TagRules{Rename: map[string]string{"Build": "SourceBuild"}, Drop: []string{"ci:*"}}, want: "Name=golden,SourceBuild=42"},
+		{
+			name:  "template",
+			rules: TagRules{Add: map[string]string{"CopiedFrom": "{{.SourceAmiID}} in {{.SourceRegion}}"}, Drop: []string{"ci:*", "Build"}},
+			want:  "CopiedFrom=ami-source in eu-west-1,Name=golden",
+		},
+		{name: "added tags win", rules: TagRules{Add: map[string]string{"Name": "{{.AmiID}}"}, Drop: []string{"ci:*", "Build"}}, want: "Name=ami-copy"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tags, err := tt.rules.Apply(source, data)
+			if err != nil {
+				t.Fatalf("Apply() error = %v", err)
+			}
+			var got []string
+			for _, tag := range tags {
+				got = append(got, aws.ToString(tag.Key)+"="+aws.ToString(tag.Value))
+			}
+			if strings.Join(got, ",") != tt.want {
+				t.Errorf("Apply() = %s, want %s", strings.Join(got, ","), tt.want)
+			}
+		})
+	}
+}
+
+func TestTagRulesApplyRejectsTooManyTags(t *testing.T) {
+	var source []ec2Types.Tag
+	for i := range maxTagsPerResource {
+		source = append(source, ec2Types.Tag{Key: aws.String(strings.Repeat("k", i+1)), Value: aws.String("v")})
+	}
+	rules := TagRules{Add: map[string]string{"SourceAmiId": "{{.SourceAmiID}}"}}
+
+	if _, err := rules.Apply(source, TagTemplateData{SourceAmiID: "ami-source"}); err == nil {
+		t.Error("Apply() error = nil, want an error for more than 50 tags")
+	}
+}
+
+func TestTagRulesAtCopy(t *testing.T) {
+	source := []ec2Types.Tag{{Key: aws.String("Name"), Value: aws.String("golden")}}
+	data := TagTemplateData{SourceAmiID: "ami-source"}
+
+	tests := []struct {
+		name               string
+		rules              TagRules
+		wantCopySourceTags bool
+		wantImage          string
+		wantSnapshots      string
+	}{
+		{name: "source tags", rules: TagRules{}, wantCopySourceTags: true, wantSnapshots: "Name=golden"},
+		{
+			name:               "added tag",
+			rules:              TagRules{Add: map[string]string{"SourceAmiId": "{{.SourceAmiID}}"}},
+			wantCopySourceTags: true,
+			wantImage:          "SourceAmiId=ami-source",
+			wantSnapshots:      "Name=golden,SourceAmiId=ami-source",
+		},
+		{name: "overwritten tag", rules: TagRules{Add: map[string]string{"Name": "copy"}}, wantImage: "Name=copy", wantSnapshots: "Name=copy"},
+		{name: "renamed tag", rules: TagRules{Rename: map[string]string{"Name": "SourceName"}}, wantImage: "SourceName=golden", wantSnapshots: "SourceName=golden"},
+	}
+
+	format := func(tags []ec2Types.Tag) string {
+		var pairs []string
+		for _, tag := range tags {
+			pairs = append(pairs, aws.ToString(tag.Key)+"="+aws.ToString(tag.Value))
+		}
+		return strings.Join(pairs, ",")
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.rules.atCopy(source, data)
+			if got == nil {
+				t.Fatal("atCopy() = nil, want tags")
+			}
+			if got.copySourceTags != tt.wantCopySourceTags {
+				t.Errorf("copySourceTags = %v, want %v", got.copySourceTags, tt.wantCopySourceTags)
+			}
+			if format(got.image) != tt.wantImage {
+				t.Errorf("image tags = %s, want %s", format(got.image), tt.wantImage)
+			}
+			if format(got.snapshots) != tt.wantSnapshots {
+				t.Errorf("snapshot tags = %s, want %s", format(got.snapshots), tt.wantSnapshots)
+			}
+		})
+	}
+
+	if got := (TagRules{Add: map[string]string{"Self": "{{.AmiID}}"}}).atCopy(source, data); got != nil {
+		t.Errorf("atCopy() with a template using the copy id = %+v, want nil", got)
+	}
+}
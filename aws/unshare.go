@@ -0,0 +1,314 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// publicLaunchPermission is how a public launch permission is listed in a permission set.
+const publicLaunchPermission = "all"
+
+// UnshareOptions holds the optional settings for Ami.Unshare.
+type UnshareOptions struct {
+	OrganizationTargets
+
+	// Snapshots also revokes createVolumePermission on the EBS snapshots of the AMI.
+	Snapshots bool
+	// DryRun only reports which permissions would be revoked.
+	DryRun bool
+}
+
+// SnapshotUnshareResult is the outcome of revoking createVolumePermission on a single snapshot.
+// Before and After list the accounts with permission.
+type SnapshotUnshareResult struct {
+	SnapshotID string
+	Before     []string
+	After      []string
+	Err        error
+}
+
+// ImageUnshareResult is the outcome of revoking permissions on a single AMI. Before and After list
+// the accounts, organization and organizational unit ARNs with launch permission.
+type ImageUnshareResult struct {
+	AmiID string
+
+	Before    []string
+	After     []string
+	Err       error
+	Snapshots []SnapshotUnshareResult
+}
+
+// Failed returns true if anything went wrong for the AMI.
+func (r *ImageUnshareResult) Failed() bool {
+	if r.Err != nil {
+		return true
+	}
+	for _, snapshot := range r.Snapshots {
+		if snapshot.Err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// RegionUnshareResult is the outcome of revoking permissions in a single region.
+type RegionUnshareResult struct {
+	Region string
+	// Images holds the AMI, or its copies, in the region. It is empty when the AMI has no copy in
+	// the region.
+	Images []*ImageUnshareResult
+	// Err is why the copies in the region could not be found.
+	Err error
+}
+
+// Failed returns true if anything went wrong in the region.
+func (r *RegionUnshareResult) Failed() bool {
+	if r.Err != nil {
+		return true
+	}
+	for _, image := range r.Images {
+		if image.Failed() {
+			return true
+		}
+	}
+	return false
+}
+
+// UnshareResult summarizes an Unshare operation per region.
+type UnshareResult struct {
+	SourceAmiID string
+	DryRun      bool
+
+	Regions map[string]*RegionUnshareResult
+}
+
+// SortedRegions returns the per-region results ordered by region name.
+func (r *UnshareResult) SortedRegions() []*RegionUnshareResult {
+	results := make([]*RegionUnshareResult, 0, len(r.Regions))
+	for _, region := range slices.Sorted(maps.Keys(r.Regions)) {
+		results = append(results, r.Regions[region])
+	}
+	return results
+}
+
+// Err joins the failures of every region into a single error, or returns nil.
+func (r *UnshareResult) Err() error {
+	var errs []error
+	for _, regionResult := range r.SortedRegions() {
+		if regionResult.Err != nil {
+			errs = append(errs, fmt.Errorf("region %s: %w", regionResult.Region, regionResult.Err))
+		}
+		for _, image := range regionResult.Images {
+			if image.Err != nil {
+				errs = append(errs, fmt.Errorf("region %s: AMI %s: %w", regionResult.Region, image.AmiID, image.Err))
+			}
+			for _, snapshot := range image.Snapshots {
+				if snapshot.Err != nil {
+					errs = append(errs, fmt.Errorf("region %s: AMI %s: snapshot %s: %w", regionResult.Region, image.AmiID, snapshot.SnapshotID, snapshot.Err))
+				}
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Unshare revokes the launch permissions of the accounts, organizations and organizational units on
+// the AMI in each region and, when requested, createVolumePermission on its snapshots. In the
+// source region the AMI itself is used; in the other regions its copies are found by their source
+// image and region, and every copy is unshared. Regions without a copy are skipped.
+func (ami *Ami) Unshare(ctx context.Context, regions []string, accounts []string, opts UnshareOptions) (*UnshareResult, error) {
+	if err := opts.OrganizationTargets.Validate(); err != nil {
+		return nil, err
+	}
+	if err := ami.fetchMetadata(ctx); err != nil {
+		return nil, err
+	}
+
+	result := &UnshareResult{
+		SourceAmiID: ami.SourceAmiID,
+		DryRun:      opts.DryRun,
+		Regions:     make(map[string]*RegionUnshareResult, len(regions)),
+	}
+	for _, region := range regions {
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+		regionResult := &RegionUnshareResult{Region: region}
+		result.Regions[region] = regionResult
+
+		images, err := ami.imagesInRegion(ctx, region)
+		if err != nil {
+			regionResult.Err = err
+			continue
+		}
+		if len(images) == 0 {
+			log.Infof("AMI %s has no copy in region %s", ami.SourceAmiID, region)
+			continue
+		}
+		for i := range images {
+			regionResult.Images = append(regionResult.Images, unshareImage(ctx, region, &images[i], accounts, opts))
+		}
+	}
+	return result, nil
+}
+
+// imagesInRegion returns the AMI in its own region, or its copies in another region.
+func (ami *Ami) imagesInRegion(ctx context.Context, region string) ([]ec2Types.Image, error) {
+	if region == ami.SourceRegion {
+		return []ec2Types.Image{*ami.AWSImage}, nil
+	}
+
+	ec2Service := getEC2ServiceForAccountAndRegion(*ConfigManager.defaultAccountID, region)
+	output, err := ec2Service.DescribeImages(ctx, &ec2.DescribeImagesInput{
+		Owners:  []string{"self"},
+		Filters: ami.copyFilters(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("finding the copies of AMI %s: %w", ami.SourceAmiID, err)
+	}
+	sort.Slice(output.Images, func(i, j int) bool {
+		return aws.ToString(output.Images[i].ImageId) < aws.ToString(output.Images[j].ImageId)
+	})
+	return output.Images, nil
+}
+
+func unshareImage(ctx context.Context, region string, image *ec2Types.Image, accounts []string, opts UnshareOptions) *ImageUnshareResult {
+	ec2Service := getEC2ServiceForAccountAndRegion(*ConfigManager.defaultAccountID, region)
+	imageID := aws.ToString(image.ImageId)
+	result := &ImageUnshareResult{AmiID: imageID}
+
+	before, err := launchPermissionsOf(ctx, ec2Service, imageID)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	result.Before = before
+
+	revoked := append(createLaunchPermissionsForOwners(accounts), opts.OrganizationTargets.launchPermissions()...)
+	if opts.DryRun {
+		log.Infof("[dry-run] Would revoke launch permissions %v on AMI %s in region %s", launchPermissionPrincipals(revoked), imageID, region)
+		result.After = withoutPrincipals(before, launchPermissionPrincipals(revoked))
+	} else {
+		log.Infof("Revoking launch permissions %v on AMI %s in region %s", launchPermissionPrincipals(revoked), imageID, region)
+		_, err := ec2Service.ModifyImageAttribute(ctx, &ec2.ModifyImageAttributeInput{
+			ImageId:          aws.String(imageID),
+			LaunchPermission: &ec2Types.LaunchPermissionModifications{Remove: revoked},
+		})
+		if err != nil {
+			result.Err = fmt.Errorf("revoking launch permissions: %w", err)
+			return result
+		}
+		if result.After, err = launchPermissionsOf(ctx, ec2Service, imageID); err != nil {
+			result.Err = err
+			return result
+		}
+	}
+
+	if opts.Snapshots && len(accounts) > 0 {
+		for _, snapshotID := range snapshotIDsOf(image) {
+			result.Snapshots = append(result.Snapshots, unshareSnapshot(ctx, ec2Service, snapshotID, accounts, opts.DryRun))
+		}
+	}
+	return result
+}
+
+func unshareSnapshot(ctx context.Context, ec2Service EC2API, snapshotID string, accounts []string, dryRun bool) SnapshotUnshareResult {
+	result := SnapshotUnshareResult{SnapshotID: snapshotID}
+
+	result.Before, result.Err = createVolumePermissionsOf(ctx, ec2Service, snapshotID)
+	if result.Err != nil {
+		return result
+	}
+	if dryRun {
+		log.Infof("[dry-run] Would revoke createVolumePermission for %v on snapshot %s", accounts, snapshotID)
+		result.After = withoutPrincipals(result.Before, accounts)
+		return result
+	}
+
+	log.Infof("Revoking createVolumePermission for %v on snapshot %s", accounts, snapshotID)
+	_, err := ec2Service.ModifySnapshotAttribute(ctx, &ec2.ModifySnapshotAttributeInput{
+		SnapshotId: aws.String(snapshotID),
+		Attribute:  ec2Types.SnapshotAttributeNameCreateVolumePermission,
+		CreateVolumePermission: &ec2Types.CreateVolumePermissionModifications{
+			Remove: createVolumePermissionsForOwners(accounts),
+		},
+	})
+	if err != nil {
+		result.Err = fmt.Errorf("revoking createVolumePermission: %w", err)
+		return result
+	}
+	result.After, result.Err = createVolumePermissionsOf(ctx, ec2Service, snapshotID)
+	return result
+}
+
+func launchPermissionsOf(ctx context.Context, ec2Service EC2API, imageID string) ([]string, error) {
+	output, err := ec2Service.DescribeImageAttribute(ctx, &ec2.DescribeImageAttributeInput{
+		ImageId:   aws.String(imageID),
+		Attribute: ec2Types.ImageAttributeNameLaunchPermission,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("describing launch permissions of AMI %s: %w", imageID, err)
+	}
+	return launchPermissionPrincipals(output.LaunchPermissions), nil
+}
+
+func createVolumePermissionsOf(ctx context.Context, ec2Service EC2API, snapshotID string) ([]string, error) {
+	output, err := ec2Service.DescribeSnapshotAttribute(ctx, &ec2.DescribeSnapshotAttributeInput{
+		SnapshotId: aws.String(snapshotID),
+		Attribute:  ec2Types.SnapshotAttributeNameCreateVolumePermission,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("describing createVolumePermission of snapshot %s: %w", snapshotID, err)
+	}
+
+	accounts := []string{}
+	for _, permission := range output.CreateVolumePermissions {
+		if permission.UserId != nil {
+			accounts = append(accounts, *permission.UserId)
+		}
+		if permission.Group == ec2Types.PermissionGroupAll {
+			accounts = append(accounts, publicLaunchPermission)
+		}
+	}
+	sort.Strings(accounts)
+	return accounts, nil
+}
+
+// launchPermissionPrincipals lists the account IDs, organization and organizational unit ARNs and
+// public group of the launch permissions, sorted.
+func launchPermissionPrincipals(permissions []ec2Types.LaunchPermission) []string {
+	principals := []string{}
+	for _, permission := range permissions {
+		switch {
+		case permission.UserId != nil:
+			principals = append(principals, *permission.UserId)
+		case permission.OrganizationArn != nil:
+			principals = append(principals, *permission.OrganizationArn)
+		case permission.OrganizationalUnitArn != nil:
+			principals = append(principals, *permission.OrganizationalUnitArn)
+		case permission.Group == ec2Types.PermissionGroupAll:
+			principals = append(principals, publicLaunchPermission)
+		}
+	}
+	sort.Strings(principals)
+	return principals
+}
+
+func withoutPrincipals(principals []string, removed []string) []string {
+	remaining := []string{}
+	for _, principal := range principals {
+		if !slices.Contains(removed, principal) {
+			remaining = append(remaining, principal)
+		}
+	}
+	return remaining
+}
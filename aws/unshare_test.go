@@ -0,0 +1,147 @@
+package aws
+
+import (
+	"slices"
+	"testing"
+
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/cloudnatives/aws-ami-manager/internal/ec2fake"
+)
+
+// seedSharedImage adds image to region of the default account, shared with 222222222222,
+// 333333333333 and testOUArn, with its snapshots shared with 222222222222.
+func seedSharedImage(t *testing.T, backend *ec2fake.Backend, region string, image ec2Types.Image) {
+	t.Helper()
+
+	backend.AddImage(testDefaultAccount, region, image)
+	client := backend.EC2(testDefaultAccount, region)
+	if _, err := client.ModifyImageAttribute(t.Context(), &ec2.ModifyImageAttributeInput{
+		ImageId: image.ImageId,
+		LaunchPermission: &ec2Types.LaunchPermissionModifications{
+			Add: append(createLaunchPermissionsForOwners([]string{"222222222222", "333333333333"}),
+				OrganizationTargets{OrganizationalUnitArns: []string{testOUArn}}.launchPermissions()...),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, snapshot := range snapshotIDsOf(&image) {
+		if _, err := client.ModifySnapshotAttribute(t.Context(), &ec2.ModifySnapshotAttributeInput{
+			SnapshotId:             awsv2.String(snapshot),
+			CreateVolumePermission: &ec2Types.CreateVolumePermissionModifications{Add: createVolumePermissionsForOwners([]string{"222222222222"})},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestUnshareRevokesPermissionsInEveryRegion(t *testing.T) {
+	backend := useFakeEC2(t, nil)
+	seedSharedImage(t, backend, testDefaultRegion, testImage("ami-source", "golden", "2024-01-01T00:00:00.000Z", nil, "snap-source"))
+	seedSharedImage(t, backend, "us-east-1", copyOf(testImage("ami-copy", "golden", "2024-01-01T00:00:00.000Z", nil, "snap-copy"), "ami-source", testDefaultRegion))
+	// an image with the same name that is not a copy, and a copy from another source region, are left alone
+	seedSharedImage(t, backend, "us-east-1", testImage("ami-another", "golden", "2024-01-01T00:00:00.000Z", nil, "snap-another"))
+	seedSharedImage(t, backend, "us-east-1", copyOf(testImage("ami-other-region", "golden", "2024-01-01T00:00:00.000Z", nil, "snap-other-region"), "ami-source", "us-west-2"))
+
+	ami := NewAmi("ami-source")
+	ami.SourceRegion = testDefaultRegion
+
+	opts := UnshareOptions{
+		OrganizationTargets: OrganizationTargets{OrganizationalUnitArns: []string{testOUArn}},
+		Snapshots:           true,
+	}
+	result, err := ami.Unshare(t.Context(), []string{testDefaultRegion, "us-east-1", "us-west-2"}, []string{"222222222222"}, opts)
+	if err != nil {
+		t.Fatalf("Unshare() error = %v", err)
+	}
+	if err := result.Err(); err != nil {
+		t.Fatalf("Unshare() result error = %v", err)
+	}
+
+	wantBefore := []string{"222222222222", "333333333333", testOUArn}
+	for region, amiID := range map[string]string{testDefaultRegion: "ami-source", "us-east-1": "ami-copy"} {
+		images := result.Regions[region].Images
+		if len(images) != 1 || images[0].AmiID != amiID {
+			t.Errorf("%s: images = %+v, want only %s", region, images, amiID)
+			continue
+		}
+		image := images[0]
+		if !slices.Equal(image.Before, wantBefore) || !slices.Equal(image.After, []string{"333333333333"}) {
+			t.Errorf("%s: launch permissions %v -> %v, want %v -> [333333333333]", region, image.Before, image.After, wantBefore)
+		}
+		if len(image.Snapshots) != 1 || !slices.Equal(image.Snapshots[0].Before, []string{"222222222222"}) || len(image.Snapshots[0].After) != 0 {
+			t.Errorf("%s: snapshot results = %+v, want 222222222222 revoked", region, image.Snapshots)
+		}
+		if got := backend.LaunchPermissions(amiID); !slices.Equal(got, []string{"333333333333"}) {
+			t.Errorf("%s: launch permissions of %s = %v, want [333333333333]", region, amiID, got)
+		}
+	}
+	for _, untouched := range []string{"ami-another", "ami-other-region"} {
+		if got := backend.LaunchPermissions(untouched); !slices.Equal(got, []string{"222222222222", "333333333333"}) {
+			t.Errorf("launch permissions of %s = %v, want them left alone", untouched, got)
+		}
+	}
+	if got := result.Regions["us-west-2"]; len(got.Images) != 0 || got.Failed() {
+		t.Errorf("us-west-2 without a copy = %+v, want it skipped", got)
+	}
+}
+
+func TestUnshareDryRunMakesNoChanges(t *testing.T) {
+	backend := useFakeEC2(t, nil)
+	seedSharedImage(t, backend, testDefaultRegion, testImage("ami-source", "golden", "2024-01-01T00:00:00.000Z", nil, "snap-source"))
+
+	ami := NewAmi("ami-source")
+	ami.SourceRegion = testDefaultRegion
+
+	result, err := ami.Unshare(t.Context(), []string{testDefaultRegion}, []string{"222222222222"}, UnshareOptions{Snapshots: true, DryRun: true})
+	if err != nil {
+		t.Fatalf("Unshare() error = %v", err)
+	}
+
+	image := result.Regions[testDefaultRegion].Images[0]
+	if !slices.Equal(image.After, []string{"333333333333", testOUArn}) {
+		t.Errorf("dry run After = %v, want the permissions that would remain", image.After)
+	}
+	if !slices.Equal(backend.LaunchPermissions("ami-source"), []string{"222222222222", "333333333333"}) ||
+		!slices.Equal(backend.OrganizationLaunchPermissions("ami-source"), []string{testOUArn}) ||
+		!slices.Equal(backend.SnapshotPermissions("snap-source"), []string{"222222222222"}) {
+		t.Errorf("dry run modified permissions: image %v %v, snapshot %v", backend.LaunchPermissions("ami-source"),
+			backend.OrganizationLaunchPermissions("ami-source"), backend.SnapshotPermissions("snap-source"))
+	}
+}
+
+func TestUnshareRevokesPermissionsOnEveryCopy(t *testing.T) {
+	backend := useFakeEC2(t, nil)
+	seedSharedImage(t, backend, testDefaultRegion, testImage("ami-source", "golden", "2024-01-01T00:00:00.000Z", nil, "snap-source"))
+	for _, id := range []string{"ami-copy-2", "ami-copy-1"} {
+		seedSharedImage(t, backend, "us-east-1", copyOf(testImage(id, "golden", "2024-01-01T00:00:00.000Z", nil, "snap-"+id), "ami-source", testDefaultRegion))
+	}
+
+	ami := NewAmi("ami-source")
+	ami.SourceRegion = testDefaultRegion
+
+	result, err := ami.Unshare(t.Context(), []string{"us-east-1"}, []string{"222222222222"}, UnshareOptions{Snapshots: true})
+	if err != nil {
+		t.Fatalf("Unshare() error = %v", err)
+	}
+	if err := result.Err(); err != nil {
+		t.Fatalf("Unshare() result error = %v", err)
+	}
+
+	images := result.Regions["us-east-1"].Images
+	if len(images) != 2 || images[0].AmiID != "ami-copy-1" || images[1].AmiID != "ami-copy-2" {
+		t.Fatalf("us-east-1 images = %+v, want ami-copy-1 and ami-copy-2", images)
+	}
+	for _, image := range images {
+		if !slices.Contains(image.Before, "222222222222") || slices.Contains(image.After, "222222222222") {
+			t.Errorf("%s: launch permissions %v -> %v, want 222222222222 revoked", image.AmiID, image.Before, image.After)
+		}
+		if got := backend.LaunchPermissions(image.AmiID); slices.Contains(got, "222222222222") {
+			t.Errorf("launch permissions of %s = %v, want 222222222222 revoked", image.AmiID, got)
+		}
+		if len(image.Snapshots) != 1 || len(image.Snapshots[0].After) != 0 {
+			t.Errorf("%s: snapshot results = %+v, want 222222222222 revoked", image.AmiID, image.Snapshots)
+		}
+	}
+}
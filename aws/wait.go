@@ -0,0 +1,244 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxDescribeErrors is the number of times in a row a copy cannot be described before waiting for
+// it fails.
+const maxDescribeErrors = 3
+
+// CopyFailedError is returned when a regional copy ends up in a state it does not recover from,
+// such as failed or error, instead of becoming available.
+type CopyFailedError struct {
+	AmiID  string
+	Region string
+	State  ec2Types.ImageState
+	// Code and Message are the StateReason EC2 gives for the failure, when it has one.
+	Code    string
+	Message string
+}
+
+func (e *CopyFailedError) Error() string {
+	msg := fmt.Sprintf("copy %s in region %s is %s", e.AmiID, e.Region, e.State)
+	if e.Code != "" || e.Message != "" {
+		msg += fmt.Sprintf(": %s: %s", e.Code, e.Message)
+	}
+	return msg
+}
+
+// copyFailure returns a CopyFailedError when the image is in a terminal failure state, or nil.
+func copyFailure(image *ec2Types.Image, region string) error {
+	switch image.State {
+	case ec2Types.ImageStateFailed, ec2Types.ImageStateError, ec2Types.ImageStateInvalid, ec2Types.ImageStateDeregistered:
+	default:
+		return nil
+	}
+	failure := &CopyFailedError{AmiID: aws.ToString(image.ImageId), Region: region, State: image.State}
+	if image.StateReason != nil {
+		failure.Code = aws.ToString(image.StateReason.Code)
+		failure.Message = aws.ToString(image.StateReason.Message)
+	}
+	return failure
+}
+
+// WaitPolicy controls how regional copies are polled until they are available. Zero fields take
+// the value of DefaultWaitPolicy.
+type WaitPolicy struct {
+	// Interval is the first wait between polls. Each following wait is Interval longer, up to
+	// MaxInterval.
+	Interval    time.Duration
+	MaxInterval time.Duration
+	// WarnAfter logs a warning when a copy is still not available after this long.
+	WarnAfter time.Duration
+	// Timeout fails the region when the copy is still not available after this long.
+	Timeout time.Duration
+	// UseSDKWaiter waits with the SDK's ImageAvailableWaiter, which polls with exponential backoff
+	// between Interval and MaxInterval and stops early when the copy fails.
+	UseSDKWaiter bool
+}
+
+// DefaultWaitPolicy polls after 5 seconds, and then up to every 30 seconds, for at most 30 minutes.
+func DefaultWaitPolicy() WaitPolicy {
+	return WaitPolicy{
+		Interval:    5 * time.Second,
+		MaxInterval: 30 * time.Second,
+		WarnAfter:   5 * time.Minute,
+		Timeout:     30 * time.Minute,
+	}
+}
+
+// Validate checks that the durations are not negative and that the intervals are in order.
+func (p WaitPolicy) Validate() error {
+	var errs []error
+	for name, value := range map[string]time.Duration{"interval": p.Interval, "maximum interval": p.MaxInterval, "warning threshold": p.WarnAfter, "timeout": p.Timeout} {
+		if value < 0 {
+			errs = append(errs, fmt.Errorf("the wait %s cannot be negative: %s", name, value))
+		}
+	}
+	if p = p.withDefaults(); p.Interval > p.MaxInterval {
+		errs = append(errs, fmt.Errorf("the wait interval %s is longer than the maximum interval %s", p.Interval, p.MaxInterval))
+	}
+	return errors.Join(errs...)
+}
+
+func (p WaitPolicy) withDefaults() WaitPolicy {
+	defaults := DefaultWaitPolicy()
+	if p.Interval == 0 {
+		p.Interval = defaults.Interval
+	}
+	if p.MaxInterval == 0 {
+		p.MaxInterval = max(defaults.MaxInterval, p.Interval)
+	}
+	if p.WarnAfter == 0 {
+		p.WarnAfter = defaults.WarnAfter
+	}
+	if p.Timeout == 0 {
+		p.Timeout = defaults.Timeout
+	}
+	return p
+}
+
+// waitUntilAvailable waits until the regional AMI is available, following policy.
+func (ami *Ami) waitUntilAvailable(ctx context.Context, policy WaitPolicy) error {
+	policy = policy.withDefaults()
+	region := ami.SourceRegion
+	start := time.Now()
+
+	warning := time.AfterFunc(policy.WarnAfter, func() {
+		log.Warnf("AMI %s has not become available after %s. This may indicate a regional issue.", ami.SourceAmiID, time.Since(start).Round(time.Second))
+	})
+	defer warning.Stop()
+
+	var err error
+	if policy.UseSDKWaiter {
+		err = ami.waitWithSDKWaiter(ctx, policy)
+	} else {
+		err = ami.poll(ctx, policy)
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Infof("AMI %s took %s to become available in region %s", ami.SourceAmiID, time.Since(start), region)
+	return nil
+}
+
+// poll describes the AMI until it is available, waiting longer between each attempt. It stops as
+// soon as the copy fails.
+func (ami *Ami) poll(ctx context.Context, policy WaitPolicy) error {
+	region := ami.SourceRegion
+	duration := policy.Interval
+	start := time.Now()
+	describeErrors := 0
+
+	for {
+		if err := ami.fetchMetadata(ctx); err != nil {
+			if describeErrors++; describeErrors >= maxDescribeErrors {
+				return fmt.Errorf("describing AMI %s in region %s: %w", ami.SourceAmiID, region, err)
+			}
+			log.Warnf("Unable to describe AMI %s in region %s: %v", ami.SourceAmiID, region, err)
+		} else {
+			describeErrors = 0
+			log.Debugf("Current AMI state is %s", ami.AWSImage.State)
+			if ami.AWSImage.State == ec2Types.ImageStateAvailable {
+				log.Infof("AMI %s is available.", ami.SourceAmiID)
+				return nil
+			}
+			if err := copyFailure(ami.AWSImage, region); err != nil {
+				return err
+			}
+		}
+
+		// Check if total polling duration exceeded
+		if elapsed := time.Since(start); elapsed > policy.Timeout {
+			return fmt.Errorf("timeout waiting for AMI %s to become available after %s in region %s", ami.SourceAmiID, elapsed, region)
+		}
+
+		log.Infof("AMI %s is not available yet. Waiting %f seconds.", ami.SourceAmiID, duration.Seconds())
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("stopped waiting for AMI %s in region %s: %w", ami.SourceAmiID, region, ctx.Err())
+		case <-time.After(duration):
+		}
+
+		// Increase wait duration up to max
+		duration = min(duration+policy.Interval, policy.MaxInterval)
+	}
+}
+
+// waitWithSDKWaiter waits for the AMI with the SDK's ImageAvailableWaiter.
+func (ami *Ami) waitWithSDKWaiter(ctx context.Context, policy WaitPolicy) error {
+	region := ami.SourceRegion
+	ec2Service := getEC2ServiceForAccountAndRegion(ami.ownerAccount(), region)
+	waiter := ec2.NewImageAvailableWaiter(ec2Service, func(o *ec2.ImageAvailableWaiterOptions) {
+		o.MinDelay = policy.Interval
+		o.MaxDelay = policy.MaxInterval
+	})
+
+	log.Infof("Waiting for AMI %s to become available in region %s", ami.SourceAmiID, region)
+	err := waiter.Wait(ctx, &ec2.DescribeImagesInput{ImageIds: []string{ami.SourceAmiID}}, policy.Timeout)
+	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("stopped waiting for AMI %s in region %s: %w", ami.SourceAmiID, region, ctx.Err())
+		}
+		// the waiter gives up on a failed copy without saying why, so look up the reason
+		if ami.fetchMetadata(ctx) == nil {
+			if failure := copyFailure(ami.AWSImage, region); failure != nil {
+				return failure
+			}
+		}
+		return fmt.Errorf("waiting for AMI %s to become available in region %s: %w", ami.SourceAmiID, region, err)
+	}
+	return ami.fetchMetadata(ctx)
+}
+
+// WaitForCopies waits until the copies, started by a copy that did not wait, are available, and
+// then shares and tags them like Copy does. copies maps a region to the ID of the copy in that
+// region. The source AMI is found from the copies, which must all come from the same AMI.
+func WaitForCopies(ctx context.Context, copies map[string]string, opts CopyOptions) (*CopyResult, error) {
+	opts.NoWait = false
+	if len(copies) == 0 {
+		return nil, errors.New("no copies to wait for")
+	}
+
+	var sourceAmiID, sourceRegion string
+	for _, region := range slices.Sorted(maps.Keys(copies)) {
+		ec2Service := getEC2ServiceForAccountAndRegion(*ConfigManager.defaultAccountID, region)
+		output, err := ec2Service.DescribeImages(ctx, &ec2.DescribeImagesInput{ImageIds: []string{copies[region]}})
+		if err != nil {
+			return nil, fmt.Errorf("describing AMI %s in region %s: %w", copies[region], region, err)
+		}
+		if len(output.Images) == 0 || output.Images[0].SourceImageId == nil {
+			return nil, fmt.Errorf("AMI %s in region %s is not a copy of another AMI", copies[region], region)
+		}
+		imageSource, imageSourceRegion := aws.ToString(output.Images[0].SourceImageId), aws.ToString(output.Images[0].SourceImageRegion)
+		if sourceAmiID != "" && (imageSource != sourceAmiID || imageSourceRegion != sourceRegion) {
+			return nil, fmt.Errorf("AMI %s in region %s is a copy of %s in %s, not of %s in %s", copies[region], region, imageSource, imageSourceRegion, sourceAmiID, sourceRegion)
+		}
+		sourceAmiID, sourceRegion = imageSource, imageSourceRegion
+	}
+
+	if err := opts.Validate(sourceRegion, slices.Sorted(maps.Keys(copies))); err != nil {
+		return nil, err
+	}
+
+	ami := NewAmiWithRegions(sourceAmiID, sourceRegion, slices.Sorted(maps.Keys(copies)))
+	for region, amiID := range copies {
+		ami.AmisPerRegion[region].SourceAmiID = amiID
+	}
+	if err := ami.fetchMetadata(ctx); err != nil {
+		return nil, err
+	}
+	return ami.copyAll(ctx, opts), nil
+}
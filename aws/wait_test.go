@@ -0,0 +1,158 @@
+package aws
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func TestWaitPolicyValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  WaitPolicy
+		wantErr bool
+	}{
+		{name: "defaults", policy: WaitPolicy{}},
+		{name: "custom", policy: WaitPolicy{Interval: time.Second, MaxInterval: time.Minute, WarnAfter: time.Minute, Timeout: time.Hour}},
+		{name: "interval above default maximum", policy: WaitPolicy{Interval: time.Minute}},
+		{name: "negative timeout", policy: WaitPolicy{Timeout: -time.Second}, wantErr: true},
+		{name: "interval above maximum", policy: WaitPolicy{Interval: time.Minute, MaxInterval: time.Second}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.policy.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCopyTimesOutWithWaitPolicy(t *testing.T) {
+	for _, useSDKWaiter := range []bool{false, true} {
+		backend := useFakeEC2(t, nil)
+		backend.AddImage(testDefaultAccount, testDefaultRegion, testImage("ami-source", "golden", "2024-01-01T00:00:00.000Z", nil))
+		backend.PendingPolls = 1 << 20
+
+		opts := CopyOptions{Wait: WaitPolicy{Interval: time.Millisecond, MaxInterval: 5 * time.Millisecond, Timeout: 50 * time.Millisecond, UseSDKWaiter: useSDKWaiter}}
+		ami := NewAmiWithRegions("ami-source", testDefaultRegion, []string{"us-east-1"})
+		result, err := ami.Copy(t.Context(), opts)
+		if err != nil {
+			t.Fatalf("Copy() error = %v", err)
+		}
+		if regionResult := result.Regions["us-east-1"]; regionResult.CopyErr == nil || regionResult.AmiID == "" {
+			t.Errorf("SDK waiter %v: us-east-1 result = %+v, want a timeout for the started copy", useSDKWaiter, regionResult)
+		}
+	}
+}
+
+func TestCopyWithoutWaitingThenWaitForCopies(t *testing.T) {
+	consumer := "222222222222"
+	backend := useFakeEC2(t, []string{consumer})
+	backend.AddImage(testDefaultAccount, testDefaultRegion, testImage("ami-source", "golden", "2024-01-01T00:00:00.000Z", map[string]string{"Name": "golden"}))
+	// the copy is still pending the first time WaitForCopies describes it
+	backend.PendingPolls = 1
+
+	ami := NewAmiWithRegions("ami-source", testDefaultRegion, []string{testDefaultRegion, "us-east-1"})
+	result, err := ami.Copy(t.Context(), CopyOptions{NoWait: true})
+	if err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+	pending := result.PendingCopies()
+	if len(pending) != 1 || pending["us-east-1"] == "" || result.Regions[testDefaultRegion].Pending {
+		t.Fatalf("PendingCopies() = %v, want only the copy in us-east-1", pending)
+	}
+	copyID := pending["us-east-1"]
+	if got := backend.LaunchPermissions(copyID); len(got) != 0 {
+		t.Errorf("launch permissions were set on a pending copy: %v", got)
+	}
+
+	waited, err := WaitForCopies(t.Context(), pending, CopyOptions{Wait: WaitPolicy{Interval: time.Millisecond}})
+	if err != nil {
+		t.Fatalf("WaitForCopies() error = %v", err)
+	}
+	if waited.SourceAmiID != "ami-source" || waited.Regions["us-east-1"].AmiID != copyID || waited.Regions["us-east-1"].Failed() {
+		t.Errorf("WaitForCopies() = %+v, want a successful result for %s", waited.Regions["us-east-1"], copyID)
+	}
+	if got := len(callInputs[*ec2.CopyImageInput](backend, testDefaultAccount, "us-east-1", "CopyImage")); got != 1 {
+		t.Errorf("copied %d times, want the copy to be started only once", got)
+	}
+	if got := backend.LaunchPermissions(copyID); !slices.Equal(got, []string{consumer}) {
+		t.Errorf("launch permissions = %v, want them set for %s on %s", got, consumer, copyID)
+	}
+	if image, _ := backend.Image(consumer, "us-east-1", copyID); len(image.Tags) == 0 {
+		t.Errorf("the copy was not tagged for account %s", consumer)
+	}
+}
+
+func TestCopyStopsOnFailedCopy(t *testing.T) {
+	backend := useFakeEC2(t, nil)
+	backend.AddImage(testDefaultAccount, testDefaultRegion, testImage("ami-source", "golden", "2024-01-01T00:00:00.000Z", nil))
+	backend.FailedCopies = 1
+
+	ami := NewAmiWithRegions("ami-source", testDefaultRegion, []string{"us-east-1"})
+	result, err := ami.Copy(t.Context(), CopyOptions{})
+	if err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+
+	var failure *CopyFailedError
+	regionResult := result.Regions["us-east-1"]
+	if !errors.As(regionResult.CopyErr, &failure) {
+		t.Fatalf("CopyErr = %v, want a CopyFailedError", regionResult.CopyErr)
+	}
+	if failure.State != ec2Types.ImageStateFailed || failure.Code != "Client.InternalError" || failure.Message == "" {
+		t.Errorf("CopyFailedError = %+v, want the failed state and its reason", failure)
+	}
+	if _, kept := backend.Image(testDefaultAccount, "us-east-1", failure.AmiID); regionResult.AmiID != failure.AmiID || !kept {
+		t.Errorf("failed copy %s was not kept and reported, kept = %v", failure.AmiID, kept)
+	}
+}
+
+func TestCopyRetriesFailedCopies(t *testing.T) {
+	backend := useFakeEC2(t, nil)
+	backend.AddImage(testDefaultAccount, testDefaultRegion, testImage("ami-source", "golden", "2024-01-01T00:00:00.000Z", nil, "snap-source"))
+	backend.FailedCopies = 2
+
+	ami := NewAmiWithRegions("ami-source", testDefaultRegion, []string{"us-east-1"})
+	result, err := ami.Copy(t.Context(), CopyOptions{DeregisterFailedCopies: true, CopyRetries: 2})
+	if err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+
+	regionResult := result.Regions["us-east-1"]
+	if regionResult.Failed() || regionResult.Retries != 2 {
+		t.Fatalf("us-east-1 result = %+v, want a successful copy after 2 retries", regionResult)
+	}
+	copied := callInputs[*ec2.CopyImageInput](backend, testDefaultAccount, "us-east-1", "CopyImage")
+	removed := deregistered(backend, testDefaultAccount, "us-east-1")
+	if len(copied) != 3 || len(removed) != 2 {
+		t.Errorf("copied %d times and deregistered %v, want 3 copies and the 2 failed ones deregistered", len(copied), removed)
+	}
+	if slices.Contains(removed, regionResult.AmiID) {
+		t.Errorf("the successful copy %s was deregistered", regionResult.AmiID)
+	}
+	// the snapshots of the failed copies are deleted with them
+	if images := backend.Images(testDefaultAccount, "us-east-1"); len(images) != 1 || len(deletedSnapshots(backend, testDefaultAccount, "us-east-1")) != 2 {
+		t.Errorf("images left = %+v, deleted snapshots = %v, want only the successful copy left", images, deletedSnapshots(backend, testDefaultAccount, "us-east-1"))
+	}
+}
+
+func TestCopyRejectsRetriesWithoutDeregisteringFailedCopies(t *testing.T) {
+	backend := useFakeEC2(t, nil)
+	backend.AddImage(testDefaultAccount, testDefaultRegion, testImage("ami-source", "golden", "2024-01-01T00:00:00.000Z", nil, "snap-source"))
+	backend.FailedCopies = 1
+
+	// a retry would reuse the name of the failed copy, which EC2 rejects while the failed copy exists
+	ami := NewAmiWithRegions("ami-source", testDefaultRegion, []string{"us-east-1"})
+	if _, err := ami.Copy(t.Context(), CopyOptions{CopyRetries: 1}); err == nil {
+		t.Fatal("Copy() error = nil, want an error for retries without deregistering failed copies")
+	}
+	if got := len(backend.Calls("CopyImage")); got != 0 {
+		t.Errorf("CopyImage calls = %d, want none", got)
+	}
+}
@@ -15,14 +15,31 @@
 package cmd
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
 	"github.com/cloudnatives/aws-ami-manager/aws"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 var (
 	tagsToMatch    []string
 	versionsToKeep int
+	keepWithin     string
+	keepMonthly    int
+	minAge         string
+	groupBy        []string
+	retentionFile  string
+	protectTags    []string
+	cleanupOwners  []string
+	cleanupDryRun  bool
+	cleanupOutput  string
 )
 
 // cleanupCmd represents the cleanup command
@@ -31,44 +48,234 @@ var cleanupCmd = &cobra.Command{
 	Short: "Cleanup earlier versions of the AMI",
 	Long: `Cleanup earlier versions in the different regions. 
 
-It keeps the most recent version with the same tags and AMI's that are currently in use.		
+It keeps the most recent version with the same tags and AMI's that are currently in use.
+
+The versions of the AMI are the AMI's that match every filter in --tags, written as [!]key[=value].
+A key alone matches the value of the tag on --amiID, which must have the tag. key=value matches
+that value, with the * and ? wildcards, key=* any AMI with the tag, and ! the AMI's that do not
+match, e.g. --tags=Name,Team=platform-*,!Stage=test
+
+An AMI is in use when a running or stopped instance, the default or latest version of a launch
+template, a launch configuration or an Auto Scaling group in the same region uses it. The accounts
+in --accounts are checked as well, through the role assumed in them:
+aws-ami-manager cleanup --amiID=ami-0e38977fc6310ea8b --regions=eu-west-1 --tags=Name --accounts=123456789
+
+The retention policy combines rules, and keeps every AMI that any rule keeps: the newest
+--versions-to-keep, those younger than --keep-within, the newest of each of the last --keep-monthly
+months, and those younger than --min-age. With --group-by, the policy applies per value of the tags.
+The policy can also be read from a JSON file with --retention-file:
+{"keepNewest": 3, "keepWithin": "30d", "keepMonthly": 6, "minAge": "7d", "groupBy": ["Role"]}
+
+Only the AMI's owned by the account are cleaned up. With --owners, the AMI's of other owners that
+match are listed in the summary as skipped.
+
+Older AMI's with a tag in --protect-tags are kept as well. A key keeps every value of the tag,
+key=value only that value.
+
+Use --dry-run to print the plan, which AMI's are kept and why, and which are deregistered with
+which snapshots, without changing anything. With --output=json the plan is printed as JSON, e.g.
+to have it reviewed before the real run:
+aws-ami-manager cleanup --amiID=ami-0e38977fc6310ea8b --regions=eu-west-1 --tags=Name --protect-tags=Release --dry-run --output=json > plan.json
 	`,
 	Run: func(cmd *cobra.Command, args []string) {
-		runCleanup()
+		journal := openJournal(cmd)
+		defer func() { _ = journal.Close() }()
+		runCleanup(cmd.Context(), cmd.Flags(), cmd.OutOrStdout(), journal)
 	},
 }
 
-func runCleanup() {
-	cm, err := aws.NewConfigurationManager()
+func runCleanup(ctx context.Context, flags *pflag.FlagSet, out io.Writer, journal *aws.Journal) {
+	if cleanupOutput != "text" && cleanupOutput != "json" {
+		log.Fatalf("Invalid --output %q: expected text or json", cleanupOutput)
+	}
+	retention, err := retentionPolicy(flags)
+	if err != nil {
+		log.Fatal(err)
+	}
+	protected, err := parseProtectTags(protectTags)
+	if err != nil {
+		log.Fatal(err)
+	}
+	filters, err := aws.ParseTagFilters(tagsToMatch)
 	if err != nil {
-		log.Fatalf("Failed to initialize AWS configuration: %v", err)
+		log.Fatalf("Invalid --tags: %v", err)
 	}
 
-	ami := aws.NewAmi(amiID)
-	ami.SourceRegion = cm.GetDefaultRegion()
+	loadAWSConfigForProfiles(ctx)
 
-	aws.ConfigManager = cm
+	ami := aws.NewAmi(amiID)
+	ami.SourceRegion = aws.ConfigManager.GetDefaultRegion()
+	ami.Journal = journal
 
-	err = ami.Cleanup(regions, tagsToMatch, versionsToKeep)
+	result, err := ami.Cleanup(ctx, resolveRegions(ctx), aws.CleanupOptions{
+		Tags:        filters,
+		Retention:   retention,
+		ProtectTags: protected,
+		Owners:      cleanupOwners,
+		DryRun:      cleanupDryRun,
+	})
+	if result != nil {
+		if cleanupOutput == "json" {
+			encoder := json.NewEncoder(out)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(result); err != nil {
+				log.Fatalf("Unable to write the cleanup plan: %v", err)
+			}
+		} else {
+			printCleanupSummary(out, result)
+		}
+	}
 
 	if err != nil {
+		exitIfInterrupted(ctx, "Cleanup")
 		log.Fatal(err)
 	}
+	// the versions that were not deregistered are found again by the next cleanup
+	journal.Finish()
+	if err := result.Err(); err != nil {
+		log.Fatalf("Cleaning up AMI %s failed:\n%v", ami.SourceAmiID, err)
+	}
 
+	if cleanupDryRun {
+		log.Infof("[dry-run] Completed successfully; no changes made for AMI %s", ami.SourceAmiID)
+		return
+	}
 	log.Infof("Older AMI's related to %s has been cleaned up successfully", ami.SourceAmiID)
 }
 
+// retentionPolicy returns the policy of --retention-file, if any, with the retention flags that are
+// set on top of it.
+func retentionPolicy(flags *pflag.FlagSet) (aws.RetentionPolicy, error) {
+	policy := aws.RetentionPolicy{}
+	if retentionFile != "" {
+		var err error
+		if policy, err = aws.LoadRetentionPolicy(retentionFile, versionsToKeep); err != nil {
+			return policy, err
+		}
+	}
+	// without a file, the defaults of the flags are the policy
+	set := func(name string) bool { return retentionFile == "" || flags.Changed(name) }
+
+	var err error
+	if set("versions-to-keep") {
+		policy.KeepNewest = versionsToKeep
+	}
+	if set("keep-within") {
+		if policy.KeepWithin, err = parseRetentionFlag("keep-within", keepWithin); err != nil {
+			return policy, err
+		}
+	}
+	if set("keep-monthly") {
+		policy.KeepMonthly = keepMonthly
+	}
+	if set("min-age") {
+		if policy.MinAge, err = parseRetentionFlag("min-age", minAge); err != nil {
+			return policy, err
+		}
+	}
+	if set("group-by") {
+		policy.GroupBy = groupBy
+	}
+	return policy, policy.Validate()
+}
+
+func parseRetentionFlag(flagName string, value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	duration, err := aws.ParseRetentionDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid --%s: %w", flagName, err)
+	}
+	return duration, nil
+}
+
+// parseProtectTags turns --protect-tags values, key or key=value, into a map of key to value, with
+// an empty value for a key alone.
+func parseProtectTags(values []string) (map[string]string, error) {
+	protected := make(map[string]string, len(values))
+	for _, value := range values {
+		key, val, _ := strings.Cut(value, "=")
+		key = strings.TrimSpace(key)
+		if key == "" {
+			return nil, fmt.Errorf("invalid --protect-tags value %q: expected key or key=value", value)
+		}
+		protected[key] = strings.TrimSpace(val)
+	}
+	return protected, nil
+}
+
+// printCleanupSummary writes one line per AMI with what happened, or in a dry run what would
+// happen, to it: why it is kept, or the snapshots deleted with it.
+func printCleanupSummary(out io.Writer, result *aws.CleanupResult) {
+	title := "Cleanup summary"
+	if result.DryRun {
+		title = "Cleanup plan (dry run, no changes made)"
+	}
+	_, _ = fmt.Fprintf(out, "%s for %s:\n", title, result.SourceAmiID)
+	for _, regionResult := range result.SortedRegions() {
+		for _, image := range regionResult.Images {
+			_, _ = fmt.Fprintf(out, "  %-16s %-22s %s\n", regionResult.Region, image.AmiID, cleanupStatus(image, result.DryRun))
+		}
+	}
+}
+
+func cleanupStatus(image aws.CleanupImage, dryRun bool) string {
+	verb := "kept"
+	if dryRun {
+		verb = "keep"
+	}
+	switch {
+	case image.Reason == aws.CleanupReasonNotOwned:
+		return fmt.Sprintf("skipped (owned by account %s)", image.OwnerID)
+	case image.Reason == aws.CleanupReasonProtected:
+		return fmt.Sprintf("%s (protected by tag %s)", verb, image.ProtectTag)
+	case image.Reason == aws.CleanupReasonInUse:
+		return fmt.Sprintf("%s (in use by %s)", verb, strings.Join(image.UsedBy, ", "))
+	case image.Reason != "" && image.Group != "":
+		return fmt.Sprintf("%s (%s in %s)", verb, image.Reason, image.Group)
+	case image.Reason != "":
+		return fmt.Sprintf("%s (%s)", verb, image.Reason)
+	case image.Error != "":
+		return fmt.Sprintf("not deleted: %s", image.Error)
+	case dryRun:
+		verb = "would be deleted"
+	case image.Deregistered:
+		verb = "deleted"
+	default:
+		return "not deleted"
+	}
+	if len(image.SnapshotIDs) == 0 {
+		return verb
+	}
+	return fmt.Sprintf("%s, with snapshots %s", verb, strings.Join(image.SnapshotIDs, ", "))
+}
+
 func init() {
 	rootCmd.AddCommand(cleanupCmd)
 
 	cleanupCmd.Flags().StringVar(&amiID, "amiID", "", "The source AMI ID, e.g. aws-0e38957fc6310ea8b")
 	_ = cleanupCmd.MarkFlagRequired("amiID")
 
-	cleanupCmd.Flags().StringSliceVar(&regions, "regions", []string{}, "The regions to copy this AMI to. Can be multiple flags, or a comma-separated value")
+	cleanupCmd.Flags().StringSliceVar(&regions, "regions", []string{}, "The regions to clean up: region names, region groups such as eu or us, all, or all-except= followed by the regions or groups to leave out. Can be multiple flags, or a comma-separated value")
 	_ = cleanupCmd.MarkFlagRequired("regions")
 
-	cleanupCmd.Flags().StringSliceVar(&tagsToMatch, "tags", []string{}, "The tags to filter the AMI's on. Can be multiple flags, or a comma-separated value")
+	cleanupCmd.Flags().StringSliceVar(&tagsToMatch, "tags", []string{}, "The tags to filter the AMI's on, as [!]key[=value]. A key alone matches the value of the tag on --amiID, a value can use the * and ? wildcards, and ! selects the AMI's that do not match. Can be multiple flags, or a comma-separated value")
 	_ = cleanupCmd.MarkFlagRequired("regions")
 
+	cleanupCmd.Flags().StringSliceVar(&accounts, "accounts", []string{}, "The account ID's, e.g. those the AMI's are shared with, in which AMI's in use are kept as well. Can be multiple flags, or a comma-separated value")
+	cleanupCmd.Flags().StringVar(&role, "role", aws.DefaultAssumeRole, fmt.Sprintf("The AWS IAM role to assume in the accounts to find the AMI's in use. Defaults to '%s'.", aws.DefaultAssumeRole))
+
+	cleanupCmd.Flags().StringVar(&keepWithin, "keep-within", "", "Also keep every AMI younger than this, e.g. 30d, 2w or 36h.")
+	cleanupCmd.Flags().IntVar(&keepMonthly, "keep-monthly", 0, "Also keep the newest AMI of every month, for this many months up to and including the current one.")
+	cleanupCmd.Flags().StringVar(&minAge, "min-age", "", "Never delete an AMI younger than this, e.g. 7d.")
+	cleanupCmd.Flags().StringSliceVar(&groupBy, "group-by", []string{}, "The tag keys whose values group the AMI's. The retention policy applies to every group on its own. Can be multiple flags, or a comma-separated value")
+	cleanupCmd.Flags().StringVar(&retentionFile, "retention-file", "", "A JSON file with the retention policy. The retention flags that are set override it.")
+	cleanupCmd.Flags().StringSliceVar(&protectTags, "protect-tags", []string{}, "The tags, as key or key=value, that keep older AMI's that have them. Can be multiple flags, or a comma-separated value")
+	cleanupCmd.Flags().StringSliceVar(&cleanupOwners, "owners", []string{}, "The owners of the AMI's to look for: self, account ID's or amazon. Defaults to self. AMI's of other accounts are reported and skipped. Can be multiple flags, or a comma-separated value")
+	cleanupCmd.Flags().BoolVar(&cleanupDryRun, "dry-run", false, "Print the cleanup plan without deregistering AMI's or deleting snapshots.")
+	cleanupCmd.Flags().StringVar(&cleanupOutput, "output", "text", "The format of the summary or dry run plan: text or json.")
+
+	addStateFileFlag(cleanupCmd)
 	cleanupCmd.Flags().IntVar(&versionsToKeep, "versions-to-keep", 5, "The number of AMI's you would like to keep. Defaults to 5.")
 }
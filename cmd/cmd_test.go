@@ -0,0 +1,1021 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+	asTypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/cloudnatives/aws-ami-manager/aws"
+	"github.com/cloudnatives/aws-ami-manager/internal/ec2fake"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+const (
+	testDefaultAccount = "111111111111"
+	testConsumer       = "222222222222"
+	testRegion         = "eu-west-1"
+)
+
+// newTestBackend returns a fake EC2 backend wired into every ConfigurationManager the commands
+// create, with static credentials in the environment so no real AWS configuration is used.
+func newTestBackend(t *testing.T) *ec2fake.Backend {
+	t.Helper()
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+	t.Setenv("AWS_PROFILE", "")
+	t.Setenv("AWS_REGION", testRegion)
+	t.Setenv("AWS_CONFIG_FILE", t.TempDir()+"/config")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", t.TempDir()+"/credentials")
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	t.Setenv("AWS_AMI_MANAGER_ROLE", "")
+
+	// Execute applies --loglevel before flags are parsed, so quiet the logger directly
+	previousLevel := log.GetLevel()
+	log.SetLevel(log.WarnLevel)
+
+	backend := ec2fake.New(testDefaultAccount)
+	previous := configurationOptions
+	configurationOptions = []aws.Option{
+		aws.WithEC2ClientFactory(func(account string, conf awsv2.Config) aws.EC2API {
+			return backend.EC2(account, conf.Region)
+		}),
+		aws.WithSTSClientFactory(func(account string, _ awsv2.Config) aws.STSAPI {
+			return backend.STS(account)
+		}),
+		aws.WithKMSClientFactory(func(account string, conf awsv2.Config) aws.KMSAPI {
+			return backend.KMS(account, conf.Region)
+		}),
+		aws.WithAutoScalingClientFactory(func(account string, conf awsv2.Config) aws.AutoScalingAPI {
+			return backend.AutoScaling(account, conf.Region)
+		}),
+	}
+	t.Cleanup(func() {
+		configurationOptions = previous
+		aws.ConfigManager = nil
+		log.SetLevel(previousLevel)
+	})
+
+	return backend
+}
+
+// runCommand executes the root command with args after resetting the command tree, so flag values
+// do not leak between tests.
+func runCommand(t *testing.T, args ...string) {
+	t.Helper()
+
+	resetCommand(rootCmd)
+	rootCmd.SetArgs(args)
+	if err := rootCmd.ExecuteContext(t.Context()); err != nil {
+		t.Fatalf("%v: %v", args, err)
+	}
+}
+
+// exitCalled is raised through panic when a command calls log.Fatal during a test.
+type exitCalled struct {
+	code int
+}
+
+// runCommandExpectingExit executes the root command with args, expects it to exit through
+// logrus with a non-zero status and returns what the command printed and the exit code.
+func runCommandExpectingExit(t *testing.T, ctx context.Context, args ...string) (output string, code int) {
+	t.Helper()
+
+	var out bytes.Buffer
+	logger := log.StandardLogger()
+	previousExit := logger.ExitFunc
+	logger.ExitFunc = func(code int) { panic(exitCalled{code: code}) }
+	defer func() {
+		logger.ExitFunc = previousExit
+		rootCmd.SetOut(nil)
+		output = out.String()
+
+		recovered := recover()
+		exit, ok := recovered.(exitCalled)
+		if !ok {
+			if recovered != nil {
+				panic(recovered)
+			}
+			t.Fatalf("%v: command did not exit", args)
+		}
+		if exit.code == 0 {
+			t.Errorf("%v: exit code = 0, want non-zero", args)
+		}
+		code = exit.code
+	}()
+
+	resetCommand(rootCmd)
+	rootCmd.SetOut(&out)
+	rootCmd.SetArgs(args)
+	_ = rootCmd.ExecuteContext(ctx)
+
+	return "", 0
+}
+
+// resetCommand restores every flag to its default and drops the context cobra keeps on
+// subcommands from a previous execution.
+func resetCommand(command *cobra.Command) {
+	reset := func(flag *pflag.Flag) {
+		if slice, ok := flag.Value.(pflag.SliceValue); ok {
+			_ = slice.Replace(nil)
+		} else {
+			_ = flag.Value.Set(flag.DefValue)
+		}
+		flag.Changed = false
+	}
+	command.Flags().VisitAll(reset)
+	command.PersistentFlags().VisitAll(reset)
+	//nolint:staticcheck // a nil context makes cobra inherit the one passed to ExecuteContext
+	command.SetContext(nil)
+	for _, child := range command.Commands() {
+		resetCommand(child)
+	}
+}
+
+func seedImage(backend *ec2fake.Backend, id, name, created string, tags map[string]string, snapshots ...string) {
+	image := ec2Types.Image{
+		ImageId:      awsv2.String(id),
+		Name:         awsv2.String(name),
+		CreationDate: awsv2.String(created),
+	}
+	for key, value := range tags {
+		image.Tags = append(image.Tags, ec2Types.Tag{Key: awsv2.String(key), Value: awsv2.String(value)})
+	}
+	for _, snapshot := range snapshots {
+		image.BlockDeviceMappings = append(image.BlockDeviceMappings, ec2Types.BlockDeviceMapping{
+			DeviceName: awsv2.String("/dev/xvda"),
+			Ebs:        &ec2Types.EbsBlockDevice{SnapshotId: awsv2.String(snapshot)},
+		})
+	}
+	backend.AddImage(testDefaultAccount, testRegion, image)
+}
+
+func tagValue(image ec2Types.Image, key string) string {
+	for _, tag := range image.Tags {
+		if awsv2.ToString(tag.Key) == key {
+			return awsv2.ToString(tag.Value)
+		}
+	}
+	return ""
+}
+
+func TestCopyCommand(t *testing.T) {
+	backend := newTestBackend(t)
+	backend.PendingPolls = 1
+	seedImage(backend, "ami-source", "golden", "2024-01-01T00:00:00.000Z", map[string]string{"Name": "golden"}, "snap-source")
+
+	runCommand(t, "copy", "--amiID", "ami-source", "--regions", "us-east-1,eu-central-1", "--accounts", testConsumer)
+
+	for _, region := range []string{"us-east-1", "eu-central-1"} {
+		images := backend.Images(testDefaultAccount, region)
+		if len(images) != 1 {
+			t.Fatalf("images in %s = %d, want 1", region, len(images))
+		}
+		copied := images[0]
+		if copied.State != ec2Types.ImageStateAvailable {
+			t.Errorf("copy in %s has state %s, want available", region, copied.State)
+		}
+		if awsv2.ToString(copied.Name) != "golden" {
+			t.Errorf("copy in %s has name %q, want golden", region, awsv2.ToString(copied.Name))
+		}
+		if got := backend.LaunchPermissions(*copied.ImageId); len(got) != 1 || got[0] != testConsumer {
+			t.Errorf("launch permissions in %s = %v, want [%s]", region, got, testConsumer)
+		}
+
+		seenByConsumer, _ := backend.Image(testConsumer, region, *copied.ImageId)
+		if tagValue(seenByConsumer, "Name") != "golden" {
+			t.Errorf("consumer tags in %s = %v, want Name=golden", region, seenByConsumer.Tags)
+		}
+	}
+}
+
+func TestCopyCommandReportsFailedRegions(t *testing.T) {
+	backend := newTestBackend(t)
+	seedImage(backend, "ami-source", "golden", "2024-01-01T00:00:00.000Z", map[string]string{"Name": "golden"}, "snap-source")
+	backend.SetError(testDefaultAccount, "eu-central-1", "CopyImage", errors.New("ResourceLimitExceeded"))
+
+	out, _ := runCommandExpectingExit(t, t.Context(), "copy", "--amiID", "ami-source", "--regions", "us-east-1,eu-central-1", "--accounts", testConsumer)
+
+	if !strings.Contains(out, "eu-central-1") || !strings.Contains(out, "FAILED") || !strings.Contains(out, "ResourceLimitExceeded") {
+		t.Errorf("summary does not report the failed region:\n%s", out)
+	}
+	if images := backend.Images(testDefaultAccount, "us-east-1"); len(images) != 1 {
+		t.Errorf("images in us-east-1 = %d, want 1: other regions should still be copied", len(images))
+	}
+}
+
+func TestCopyCommandReusesCopiesWhenRerun(t *testing.T) {
+	backend := newTestBackend(t)
+	seedImage(backend, "ami-source", "golden", "2024-01-01T00:00:00.000Z", map[string]string{"Name": "golden"}, "snap-source")
+	backend.SetError(testDefaultAccount, "eu-central-1", "ModifyImageAttribute", errors.New("RequestLimitExceeded"))
+
+	runCommandExpectingExit(t, t.Context(), "copy", "--amiID", "ami-source", "--regions", "us-east-1,eu-central-1", "--accounts", testConsumer)
+
+	backend.SetError(testDefaultAccount, "eu-central-1", "ModifyImageAttribute", nil)
+	var out bytes.Buffer
+	rootCmd.SetOut(&out)
+	defer rootCmd.SetOut(nil)
+	runCommand(t, "copy", "--amiID", "ami-source", "--regions", "us-east-1,eu-central-1", "--accounts", testConsumer)
+
+	if calls := backend.Calls("CopyImage"); len(calls) != 2 {
+		t.Errorf("CopyImage calls = %d, want 2: the second run should reuse the copies", len(calls))
+	}
+	for _, region := range []string{"us-east-1", "eu-central-1"} {
+		images := backend.Images(testDefaultAccount, region)
+		if len(images) != 1 {
+			t.Fatalf("images in %s = %d, want 1", region, len(images))
+		}
+		if got := backend.LaunchPermissions(*images[0].ImageId); len(got) != 1 || got[0] != testConsumer {
+			t.Errorf("launch permissions in %s = %v, want [%s]", region, got, testConsumer)
+		}
+		seenByConsumer, _ := backend.Image(testConsumer, region, *images[0].ImageId)
+		if tagValue(seenByConsumer, "Name") != "golden" {
+			t.Errorf("consumer tags in %s = %v, want Name=golden", region, seenByConsumer.Tags)
+		}
+	}
+	if got := strings.Count(out.String(), "ok (existing copy)"); got != 2 {
+		t.Errorf("summary reports %d reused copies, want 2:\n%s", got, out.String())
+	}
+}
+
+func TestCopyCommandWithoutWaitingThenWaitCommand(t *testing.T) {
+	backend := newTestBackend(t)
+	backend.PendingPolls = 2
+	seedImage(backend, "ami-source", "golden", "2024-01-01T00:00:00.000Z", map[string]string{"Name": "golden"}, "snap-source")
+
+	var out bytes.Buffer
+	rootCmd.SetOut(&out)
+	defer rootCmd.SetOut(nil)
+	runCommand(t, "copy", "--amiID", "ami-source", "--regions", "us-east-1,eu-central-1", "--accounts", testConsumer, "--no-wait")
+
+	if calls := backend.Calls("ModifyImageAttribute"); len(calls) != 0 {
+		t.Errorf("ModifyImageAttribute calls = %d, want none before the copies are available", len(calls))
+	}
+	_, images, ok := strings.Cut(out.String(), "wait --images=")
+	if !ok || strings.Count(out.String(), "copying") != 2 {
+		t.Fatalf("summary does not list the started copies:\n%s", out.String())
+	}
+	images = strings.TrimSpace(images)
+
+	out.Reset()
+	runCommand(t, "wait", "--images", images, "--accounts", testConsumer, "--wait-interval", "1ms", "--wait-max-interval", "2ms")
+
+	for _, region := range []string{"us-east-1", "eu-central-1"} {
+		copies := backend.Images(testDefaultAccount, region)
+		if len(copies) != 1 {
+			t.Fatalf("images in %s = %d, want 1", region, len(copies))
+		}
+		if got := backend.LaunchPermissions(*copies[0].ImageId); len(got) != 1 || got[0] != testConsumer {
+			t.Errorf("launch permissions in %s = %v, want [%s]", region, got, testConsumer)
+		}
+		seenByConsumer, _ := backend.Image(testConsumer, region, *copies[0].ImageId)
+		if tagValue(seenByConsumer, "Name") != "golden" {
+			t.Errorf("consumer tags in %s = %v, want Name=golden", region, seenByConsumer.Tags)
+		}
+	}
+	if calls := backend.Calls("CopyImage"); len(calls) != 2 {
+		t.Errorf("CopyImage calls = %d, want 2", len(calls))
+	}
+	if strings.Count(out.String(), " ok") != 2 {
+		t.Errorf("wait summary does not report both copies as ok:\n%s", out.String())
+	}
+}
+
+func TestCopyCommandRetriesFailedCopies(t *testing.T) {
+	backend := newTestBackend(t)
+	backend.FailedCopies = 1
+	seedImage(backend, "ami-source", "golden", "2024-01-01T00:00:00.000Z", map[string]string{"Name": "golden"}, "snap-source")
+
+	var out bytes.Buffer
+	rootCmd.SetOut(&out)
+	defer rootCmd.SetOut(nil)
+	runCommand(t, "copy", "--amiID", "ami-source", "--regions", "us-east-1", "--accounts", testConsumer, "--copy-retries", "1", "--deregister-failed-copies")
+
+	if calls := backend.Calls("CopyImage"); len(calls) != 2 {
+		t.Errorf("CopyImage calls = %d, want 2", len(calls))
+	}
+	images := backend.Images(testDefaultAccount, "us-east-1")
+	if len(images) != 1 || images[0].State != ec2Types.ImageStateAvailable {
+		t.Fatalf("images in us-east-1 = %+v, want only the available copy", images)
+	}
+	if !strings.Contains(out.String(), "ok (copied again after 1 failed copies)") {
+		t.Errorf("summary does not report the retry:\n%s", out.String())
+	}
+
+	backend.FailedCopies = 1
+	output, _ := runCommandExpectingExit(t, t.Context(), "copy", "--amiID", "ami-source", "--regions", "eu-central-1", "--accounts", testConsumer)
+	if !strings.Contains(output, "FAILED") || !strings.Contains(output, "Client.InternalError") {
+		t.Errorf("summary does not report the reason of the failed copy:\n%s", output)
+	}
+}
+
+func TestCopyCommandResumesFromStateFile(t *testing.T) {
+	backend := newTestBackend(t)
+	seedImage(backend, "ami-source", "golden", "2024-01-01T00:00:00.000Z", map[string]string{"Name": "golden"}, "snap-source")
+	stateFile := filepath.Join(t.TempDir(), "state.jsonl")
+	args := []string{"copy", "--amiID", "ami-source", "--regions", "us-east-1,eu-central-1", "--accounts", testConsumer, "--state-file", stateFile}
+
+	backend.SetError(testDefaultAccount, "eu-central-1", "ModifyImageAttribute", errors.New("RequestLimitExceeded"))
+	runCommandExpectingExit(t, t.Context(), args...)
+	backend.SetError(testDefaultAccount, "eu-central-1", "ModifyImageAttribute", nil)
+
+	// the state file of the unfinished run is not overwritten by a new run
+	runCommandExpectingExit(t, t.Context(), args...)
+
+	var out bytes.Buffer
+	rootCmd.SetOut(&out)
+	defer rootCmd.SetOut(nil)
+	runCommand(t, "resume", "--state-file", stateFile)
+
+	if calls := backend.Calls("CopyImage"); len(calls) != 2 {
+		t.Errorf("CopyImage calls = %d, want 2: resume should not copy again", len(calls))
+	}
+	completed := 0
+	for _, call := range backend.Calls("ModifyImageAttribute") {
+		if call.Region == "us-east-1" {
+			completed++
+		}
+	}
+	if completed != 1 {
+		t.Errorf("ModifyImageAttribute calls in us-east-1 = %d, want 1: the completed region should be skipped", completed)
+	}
+	images := backend.Images(testDefaultAccount, "eu-central-1")
+	if got := backend.LaunchPermissions(*images[0].ImageId); len(got) != 1 || got[0] != testConsumer {
+		t.Errorf("launch permissions in eu-central-1 = %v, want [%s]", got, testConsumer)
+	}
+	if !strings.Contains(out.String(), "completed by the resumed run") {
+		t.Errorf("summary does not report the completed region:\n%s", out.String())
+	}
+
+	entries, err := aws.ReadJournal(stateFile)
+	if err != nil {
+		t.Fatalf("ReadJournal() error = %v", err)
+	}
+	steps := make(map[string]int)
+	for _, entry := range entries {
+		steps[entry.Step]++
+	}
+	if steps["copy-started"] != 2 || steps["region-failed"] != 1 || steps["region-done"] != 2 || steps["resumed"] != 1 {
+		t.Errorf("journal steps = %v", steps)
+	}
+	if last := entries[len(entries)-1].Step; last != "finished" {
+		t.Errorf("last journal step = %s, want finished", last)
+	}
+}
+
+func TestCopyCommandInterrupted(t *testing.T) {
+	backend := newTestBackend(t)
+	backend.PendingPolls = 1000
+	seedImage(backend, "ami-source", "golden", "2024-01-01T00:00:00.000Z", map[string]string{"Name": "golden"}, "snap-source")
+
+	ctx, cancel := context.WithTimeout(t.Context(), 100*time.Millisecond)
+	defer cancel()
+
+	out, code := runCommandExpectingExit(t, ctx, "copy", "--amiID", "ami-source", "--regions", "us-east-1", "--accounts", testConsumer)
+
+	if code != exitCodeInterrupted {
+		t.Errorf("exit code = %d, want %d", code, exitCodeInterrupted)
+	}
+	images := backend.Images(testDefaultAccount, "us-east-1")
+	if len(images) != 1 {
+		t.Fatalf("images in us-east-1 = %d, want the copy that was started", len(images))
+	}
+	if !strings.Contains(out, *images[0].ImageId) {
+		t.Errorf("summary does not report the started copy %s:\n%s", *images[0].ImageId, out)
+	}
+	if len(backend.Calls("ModifyImageAttribute")) != 0 {
+		t.Error("launch permissions were set after the copy was interrupted")
+	}
+}
+
+func TestCopyCommandWithKmsKeys(t *testing.T) {
+	backend := newTestBackend(t)
+	seedImage(backend, "ami-source", "golden", "2024-01-01T00:00:00.000Z", map[string]string{"Name": "golden"}, "snap-source")
+
+	runCommand(t, "copy", "--amiID", "ami-source", "--regions", "us-east-1", "--accounts", testConsumer,
+		"--kms-key", "us-east-1=arn:aws:kms:us-east-1:111111111111:key/1234abcd-12ab-34cd-56ef-1234567890ab")
+
+	images := backend.Images(testDefaultAccount, "us-east-1")
+	if len(images) != 1 {
+		t.Fatalf("images in us-east-1 = %d, want 1", len(images))
+	}
+	ebs := images[0].BlockDeviceMappings[0].Ebs
+	if !awsv2.ToBool(ebs.Encrypted) || !strings.HasSuffix(awsv2.ToString(ebs.KmsKeyId), "key/1234abcd-12ab-34cd-56ef-1234567890ab") {
+		t.Errorf("copy snapshot Encrypted=%v KmsKeyId=%s, want encrypted with the given key", awsv2.ToBool(ebs.Encrypted), awsv2.ToString(ebs.KmsKeyId))
+	}
+}
+
+func TestCopyCommandRejectsInvalidKmsKeys(t *testing.T) {
+	backend := newTestBackend(t)
+	seedImage(backend, "ami-source", "golden", "2024-01-01T00:00:00.000Z", nil, "snap-source")
+
+	runCommandExpectingExit(t, t.Context(), "copy", "--amiID", "ami-source", "--regions", "us-east-1,us-west-2", "--accounts", testConsumer,
+		"--kms-key", "us-east-1=alias/ami,us-west-2=arn:aws:kms:eu-west-1:111111111111:alias/ami")
+
+	if calls := backend.Calls("CopyImage"); len(calls) != 0 {
+		t.Errorf("CopyImage calls = %d, want none before the mapping is valid", len(calls))
+	}
+}
+
+func TestCopyCommandReportsMissingKmsKeyAccess(t *testing.T) {
+	backend := newTestBackend(t)
+	seedImage(backend, "ami-source", "golden", "2024-01-01T00:00:00.000Z", nil, "snap-source")
+	keyArn := backend.AddKey(testDefaultAccount, "us-east-1", "alias/ami")
+
+	var out bytes.Buffer
+	rootCmd.SetOut(&out)
+	defer rootCmd.SetOut(nil)
+	runCommand(t, "copy", "--amiID", "ami-source", "--regions", "us-east-1", "--accounts", testConsumer, "--kms-key", "us-east-1=alias/ami")
+
+	for _, want := range []string{keyArn + " account " + testConsumer + " NO ACCESS", "arn:aws:iam::" + testConsumer + ":root", "kms:CreateGrant"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("summary does not contain %q:\n%s", want, out.String())
+		}
+	}
+	if grants := backend.KeyGrants(keyArn); len(grants) != 0 {
+		t.Errorf("grants = %v, want none without --kms-grants", grants)
+	}
+}
+
+func TestCopyCommandCreatesKmsGrants(t *testing.T) {
+	backend := newTestBackend(t)
+	seedImage(backend, "ami-source", "golden", "2024-01-01T00:00:00.000Z", nil, "snap-source")
+	keyArn := backend.AddKey(testDefaultAccount, "us-east-1", "alias/ami")
+
+	var out bytes.Buffer
+	rootCmd.SetOut(&out)
+	defer rootCmd.SetOut(nil)
+	runCommand(t, "copy", "--amiID", "ami-source", "--regions", "us-east-1", "--accounts", testConsumer, "--kms-key", "us-east-1=alias/ami", "--kms-grants")
+
+	if grants := backend.KeyGrants(keyArn); len(grants) != 1 || grants[0] != testConsumer {
+		t.Errorf("grants = %v, want [%s]", grants, testConsumer)
+	}
+	if strings.Contains(out.String(), "key policy") {
+		t.Errorf("summary asks for a key policy change after the grant:\n%s", out.String())
+	}
+}
+
+func TestDiagnoseChecksKmsKeyAccess(t *testing.T) {
+	backend := newTestBackend(t)
+	keyArn := backend.AddKey(testDefaultAccount, testRegion, "alias/ami")
+	backend.AllowKeyUse(keyArn, "333333333333")
+
+	var out bytes.Buffer
+	rootCmd.SetOut(&out)
+	defer rootCmd.SetOut(nil)
+	runCommand(t, "diagnose", "--kms-key", keyArn, "--accounts", testConsumer+",333333333333")
+
+	for _, want := range []string{"account " + testConsumer + ": NO ACCESS", "account 333333333333: ok", "Add this statement"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("diagnostics do not contain %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestCopyCommandSharesWithOrganizationalUnits(t *testing.T) {
+	backend := newTestBackend(t)
+	seedImage(backend, "ami-source", "golden", "2024-01-01T00:00:00.000Z", map[string]string{"Name": "golden"}, "snap-source")
+	ou := "arn:aws:organizations::111111111111:ou/o-abcde12345/ou-ab12-abcdefgh"
+
+	runCommand(t, "copy", "--amiID", "ami-source", "--regions", "us-east-1", "--accounts", testConsumer, "--ou-arns", ou)
+
+	images := backend.Images(testDefaultAccount, "us-east-1")
+	if len(images) != 1 {
+		t.Fatalf("images in us-east-1 = %d, want 1", len(images))
+	}
+	copyID := *images[0].ImageId
+	if got := backend.OrganizationLaunchPermissions(copyID); len(got) != 1 || got[0] != ou {
+		t.Errorf("organization launch permissions = %v, want [%s]", got, ou)
+	}
+	if got := backend.LaunchPermissions(copyID); len(got) != 1 || got[0] != testConsumer {
+		t.Errorf("launch permissions = %v, want [%s]", got, testConsumer)
+	}
+	consumerView, _ := backend.Image(testConsumer, "us-east-1", copyID)
+	if got := tagValue(consumerView, "Name"); got != "golden" {
+		t.Errorf("Name tag for %s = %q, want golden", testConsumer, got)
+	}
+}
+
+func TestCopyCommandRejectsInvalidOrganizationArn(t *testing.T) {
+	backend := newTestBackend(t)
+	seedImage(backend, "ami-source", "golden", "2024-01-01T00:00:00.000Z", nil, "snap-source")
+
+	runCommandExpectingExit(t, t.Context(), "copy", "--amiID", "ami-source", "--regions", "us-east-1", "--organization-arn", "o-abcde12345")
+
+	if calls := backend.Calls("CopyImage"); len(calls) != 0 {
+		t.Errorf("CopyImage calls = %d, want none with an invalid organization ARN", len(calls))
+	}
+}
+
+func TestCopyCommandNamesCopiesFromTemplates(t *testing.T) {
+	backend := newTestBackend(t)
+	seedImage(backend, "ami-source", "golden", "2024-01-01T00:00:00.000Z", map[string]string{"Version": "1.2.3"}, "snap-source")
+
+	runCommand(t, "copy", "--amiID", "ami-source", "--regions", "us-east-1,eu-central-1", "--accounts", testConsumer,
+		"--name-template", "{{.SourceName}}-{{.Tags.Version}}-{{.TargetRegion}}",
+		"--description-template", "Copy of {{.SourceId}} from {{.SourceRegion}} on {{.Date}}")
+
+	for _, region := range []string{"us-east-1", "eu-central-1"} {
+		images := backend.Images(testDefaultAccount, region)
+		if len(images) != 1 {
+			t.Fatalf("images in %s = %d, want 1", region, len(images))
+		}
+		if got, want := awsv2.ToString(images[0].Name), "golden-1.2.3-"+region; got != want {
+			t.Errorf("name of the copy in %s = %q, want %q", region, got, want)
+		}
+		if got := awsv2.ToString(images[0].Description); !strings.HasPrefix(got, "Copy of ami-source from eu-west-1 on 20") {
+			t.Errorf("description of the copy in %s = %q", region, got)
+		}
+	}
+}
+
+func TestCopyCommandRejectsInvalidNamesBeforeCopying(t *testing.T) {
+	for _, template := range []string{"{{.SourceName}}*{{.TargetRegion}}", "{{.Tags.Missing}}", "{{.SourceName"} {
+		backend := newTestBackend(t)
+		seedImage(backend, "ami-source", "golden", "2024-01-01T00:00:00.000Z", nil, "snap-source")
+
+		runCommandExpectingExit(t, t.Context(), "copy", "--amiID", "ami-source", "--regions", "us-east-1", "--accounts", testConsumer, "--name-template", template)
+
+		if calls := backend.Calls("CopyImage"); len(calls) != 0 {
+			t.Errorf("name template %q: CopyImage calls = %d, want none", template, len(calls))
+		}
+	}
+}
+
+func TestCopyCommandLimitsConcurrency(t *testing.T) {
+	backend := newTestBackend(t)
+	seedImage(backend, "ami-source", "golden", "2024-01-01T00:00:00.000Z", nil, "snap-source")
+
+	runCommandExpectingExit(t, t.Context(), "copy", "--amiID", "ami-source", "--regions", "us-east-1", "--accounts", testConsumer, "--max-concurrency", "-1")
+	if calls := backend.Calls("CopyImage"); len(calls) != 0 {
+		t.Errorf("CopyImage calls with a negative --max-concurrency = %d, want none", len(calls))
+	}
+
+	runCommand(t, "copy", "--amiID", "ami-source", "--regions", "us-east-1,us-west-2,eu-central-1", "--accounts", testConsumer, "--max-concurrency", "1")
+	if calls := backend.Calls("CopyImage"); len(calls) != 3 {
+		t.Errorf("CopyImage calls = %d, want 3", len(calls))
+	}
+}
+
+func TestCopyCommandResolvesRegionGroups(t *testing.T) {
+	backend := newTestBackend(t)
+	backend.DisableRegion(testConsumer, "us-east-2")
+	seedImage(backend, "ami-source", "golden", "2024-01-01T00:00:00.000Z", nil, "snap-source")
+
+	runCommand(t, "copy", "--amiID", "ami-source", "--regions", "all-except=eu,ap,ca,sa,us-west-1", "--accounts", testConsumer, "--check-region-opt-in")
+
+	var copied []string
+	for _, call := range backend.Calls("CopyImage") {
+		copied = append(copied, call.Region)
+	}
+	sort.Strings(copied)
+	if strings.Join(copied, ",") != "us-east-1,us-west-2" {
+		t.Errorf("copied to %v, want us-east-1 and us-west-2", copied)
+	}
+
+	runCommandExpectingExit(t, t.Context(), "copy", "--amiID", "ami-source", "--regions", "eu,mars", "--accounts", testConsumer)
+}
+
+func TestCopyCommandSharesSnapshotsByDefault(t *testing.T) {
+	backend := newTestBackend(t)
+	seedImage(backend, "ami-source", "golden", "2024-01-01T00:00:00.000Z", map[string]string{"Name": "golden"}, "snap-source")
+
+	runCommand(t, "copy", "--amiID", "ami-source", "--regions", "us-east-1", "--accounts", testConsumer)
+	runCommand(t, "copy", "--amiID", "ami-source", "--regions", "us-west-2", "--accounts", testConsumer, "--share-snapshots=false")
+
+	for region, want := range map[string][]string{"us-east-1": {testConsumer}, "us-west-2": nil} {
+		images := backend.Images(testDefaultAccount, region)
+		if len(images) != 1 {
+			t.Fatalf("images in %s = %d, want 1", region, len(images))
+		}
+		snapshot := *images[0].BlockDeviceMappings[0].Ebs.SnapshotId
+		if got := backend.SnapshotPermissions(snapshot); !slices.Equal(got, want) {
+			t.Errorf("%s: createVolumePermission on %s = %v, want %v", region, snapshot, got, want)
+		}
+	}
+}
+
+func TestCopyCommandMakesOwnedCopies(t *testing.T) {
+	backend := newTestBackend(t)
+	backend.PendingPolls = 1
+	seedImage(backend, "ami-source", "golden", "2024-01-01T00:00:00.000Z", map[string]string{"Name": "golden"}, "snap-source")
+
+	var out bytes.Buffer
+	rootCmd.SetOut(&out)
+	defer rootCmd.SetOut(nil)
+	runCommand(t, "copy", "--amiID", "ami-source", "--regions", "us-east-1,eu-west-1", "--accounts", testConsumer,
+		"--mode", "owned-copy", "--tag", "SourceAmiId={{.SourceAmiID}}")
+
+	if got := backend.LaunchPermissions("ami-source"); len(got) != 1 || got[0] != testConsumer {
+		t.Errorf("launch permissions on the source = %v, want [%s]", got, testConsumer)
+	}
+	if got := backend.SnapshotPermissions("snap-source"); len(got) != 1 || got[0] != testConsumer {
+		t.Errorf("createVolumePermission on snap-source = %v, want [%s]", got, testConsumer)
+	}
+	if images := backend.Images(testDefaultAccount, "us-east-1"); len(images) != 0 {
+		t.Errorf("images of the default account in us-east-1 = %d, want 0", len(images))
+	}
+
+	for _, region := range []string{"us-east-1", "eu-west-1"} {
+		var owned []ec2Types.Image
+		for _, image := range backend.Images(testConsumer, region) {
+			if awsv2.ToString(image.OwnerId) == testConsumer {
+				owned = append(owned, image)
+			}
+		}
+		if len(owned) != 1 {
+			t.Fatalf("copies owned by %s in %s = %d, want 1", testConsumer, region, len(owned))
+		}
+		copied := owned[0]
+		if copied.State != ec2Types.ImageStateAvailable || tagValue(copied, "Name") != "golden" || tagValue(copied, "SourceAmiId") != "ami-source" {
+			t.Errorf("copy in %s = %s with tags %v, want available with Name and SourceAmiId", region, copied.State, copied.Tags)
+		}
+		if !strings.Contains(out.String(), "account "+testConsumer+" "+awsv2.ToString(copied.ImageId)) {
+			t.Errorf("summary does not report copy %s in %s:\n%s", awsv2.ToString(copied.ImageId), region, out.String())
+		}
+	}
+}
+
+func TestCopyCommandAppliesTagRules(t *testing.T) {
+	backend := newTestBackend(t)
+	tags := map[string]string{"Name": "golden", "Team": "platform", "Build": "42", "Secret": "hunter2"}
+	seedImage(backend, "ami-source", "golden", "2024-01-01T00:00:00.000Z", tags, "snap-source")
+
+	runCommand(t, "copy", "--amiID", "ami-source", "--regions", "us-east-1", "--accounts", testConsumer,
+		"--tag", "SourceAmiId={{.SourceAmiID}}", "--rename-tag", "Build=SourceBuild", "--drop-tag", "Secret")
+
+	images := backend.Images(testDefaultAccount, "us-east-1")
+	if len(images) != 1 {
+		t.Fatalf("images in us-east-1 = %d, want 1", len(images))
+	}
+	copyID := *images[0].ImageId
+	want := map[string]string{"Name": "golden", "Team": "platform", "SourceBuild": "42", "SourceAmiId": "ami-source", "Secret": "", "Build": ""}
+
+	for _, account := range []string{testDefaultAccount, testConsumer} {
+		image, _ := backend.Image(account, "us-east-1", copyID)
+		for key, value := range want {
+			if got := tagValue(image, key); got != value {
+				t.Errorf("tag %s of the copy in account %s = %q, want %q", key, account, got, value)
+			}
+		}
+	}
+
+	snapshot := *images[0].BlockDeviceMappings[0].Ebs.SnapshotId
+	var snapshotTags []string
+	for _, tag := range backend.SnapshotTags(snapshot) {
+		snapshotTags = append(snapshotTags, awsv2.ToString(tag.Key)+"="+awsv2.ToString(tag.Value))
+	}
+	if got := strings.Join(snapshotTags, ","); got != "Name=golden,SourceAmiId=ami-source,SourceBuild=42,Team=platform" {
+		t.Errorf("tags of snapshot %s = %s", snapshot, got)
+	}
+}
+
+func TestCopyCommandTagsAtCopyUnlessTaggingAfterCopy(t *testing.T) {
+	for _, tagAfterCopy := range []bool{false, true} {
+		backend := newTestBackend(t)
+		seedImage(backend, "ami-source", "golden", "2024-01-01T00:00:00.000Z", map[string]string{"Name": "golden"}, "snap-source")
+
+		args := []string{"copy", "--amiID", "ami-source", "--regions", "us-east-1", "--accounts", testConsumer}
+		if tagAfterCopy {
+			args = append(args, "--tag-after-copy")
+		}
+		runCommand(t, args...)
+
+		images := backend.Images(testDefaultAccount, "us-east-1")
+		if len(images) != 1 {
+			t.Fatalf("images in us-east-1 = %d, want 1", len(images))
+		}
+		if got := tagValue(images[0], "Name"); got != "golden" {
+			t.Errorf("tagAfterCopy=%v: Name tag of the copy = %q, want golden", tagAfterCopy, got)
+		}
+		snapshot := *images[0].BlockDeviceMappings[0].Ebs.SnapshotId
+		if got := backend.SnapshotTags(snapshot); len(got) != 1 || awsv2.ToString(got[0].Value) != "golden" {
+			t.Errorf("tagAfterCopy=%v: tags of snapshot %s = %v, want Name=golden", tagAfterCopy, snapshot, got)
+		}
+		wantCalls := 0
+		if tagAfterCopy {
+			wantCalls = 1
+		}
+		calls := 0
+		for _, call := range backend.Calls("CreateTags") {
+			if call.Account == testDefaultAccount {
+				calls++
+			}
+		}
+		if calls != wantCalls {
+			t.Errorf("tagAfterCopy=%v: CreateTags calls in the default account = %d, want %d", tagAfterCopy, calls, wantCalls)
+		}
+	}
+}
+
+func TestShareCommand(t *testing.T) {
+	backend := newTestBackend(t)
+	seedImage(backend, "ami-shared", "golden", "2024-01-01T00:00:00.000Z", nil, "snap-shared")
+
+	var out bytes.Buffer
+	rootCmd.SetOut(&out)
+	defer rootCmd.SetOut(nil)
+	runCommand(t, "share", "--amiID", "ami-shared", "--accounts", testConsumer)
+
+	if got := backend.LaunchPermissions("ami-shared"); len(got) != 1 || got[0] != testConsumer {
+		t.Errorf("launch permissions = %v, want [%s]", got, testConsumer)
+	}
+	if got := backend.SnapshotPermissions("snap-shared"); len(got) != 1 || got[0] != testConsumer {
+		t.Errorf("createVolumePermission = %v, want [%s]", got, testConsumer)
+	}
+	if !strings.Contains(out.String(), "snap-shared") {
+		t.Errorf("summary does not list the shared snapshot:\n%s", out.String())
+	}
+}
+
+func TestUnshareCommand(t *testing.T) {
+	backend := newTestBackend(t)
+	seedImage(backend, "ami-source", "golden", "2024-01-01T00:00:00.000Z", map[string]string{"Name": "golden"}, "snap-source")
+	runCommand(t, "copy", "--amiID", "ami-source", "--regions", "us-east-1", "--accounts", testConsumer+",333333333333")
+	copyID := *backend.Images(testDefaultAccount, "us-east-1")[0].ImageId
+
+	var out bytes.Buffer
+	rootCmd.SetOut(&out)
+	defer rootCmd.SetOut(nil)
+	runCommand(t, "unshare", "--amiID", "ami-source", "--regions", "us-east-1,us-west-2", "--accounts", testConsumer, "--snapshots")
+
+	if got := backend.LaunchPermissions(copyID); len(got) != 1 || got[0] != "333333333333" {
+		t.Errorf("launch permissions after unshare = %v, want [333333333333]", got)
+	}
+	snapshot := *backend.Images(testDefaultAccount, "us-east-1")[0].BlockDeviceMappings[0].Ebs.SnapshotId
+	if got := backend.SnapshotPermissions(snapshot); len(got) != 1 || got[0] != "333333333333" {
+		t.Errorf("createVolumePermission after unshare = %v, want [333333333333]", got)
+	}
+	for _, want := range []string{"launch permissions before: " + testConsumer + ", 333333333333", "launch permissions after:  333333333333", "us-west-2        no copy found"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("summary does not contain %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestUnshareCommandDryRun(t *testing.T) {
+	backend := newTestBackend(t)
+	seedImage(backend, "ami-source", "golden", "2024-01-01T00:00:00.000Z", nil, "snap-source")
+	runCommand(t, "share", "--amiID", "ami-source", "--accounts", testConsumer)
+
+	var out bytes.Buffer
+	rootCmd.SetOut(&out)
+	defer rootCmd.SetOut(nil)
+	runCommand(t, "unshare", "--amiID", "ami-source", "--accounts", testConsumer, "--snapshots", "--dry-run")
+
+	if got := backend.LaunchPermissions("ami-source"); len(got) != 1 {
+		t.Errorf("launch permissions after dry run = %v, want them unchanged", got)
+	}
+	if got := backend.SnapshotPermissions("snap-source"); len(got) != 1 {
+		t.Errorf("createVolumePermission after dry run = %v, want it unchanged", got)
+	}
+	if !strings.Contains(out.String(), "dry run") || !strings.Contains(out.String(), "launch permissions after:  -") {
+		t.Errorf("summary does not show the dry run result:\n%s", out.String())
+	}
+}
+
+func TestRemoveCommand(t *testing.T) {
+	backend := newTestBackend(t)
+	seedImage(backend, "ami-old", "old", "2024-01-01T00:00:00.000Z", nil, "snap-old")
+
+	runCommand(t, "remove", "--amiID", "ami-old")
+
+	if _, ok := backend.Image(testDefaultAccount, testRegion, "ami-old"); ok {
+		t.Error("ami-old still registered after remove")
+	}
+	if backend.SnapshotExists("snap-old") {
+		t.Error("snap-old still exists after remove")
+	}
+}
+
+func TestResumeCommandDeletesSnapshotsOfDeregisteredAmi(t *testing.T) {
+	backend := newTestBackend(t)
+	seedImage(backend, "ami-old", "old", "2024-01-01T00:00:00.000Z", nil, "snap-old")
+	if _, err := backend.EC2(testDefaultAccount, testRegion).DeregisterImage(t.Context(), &ec2.DeregisterImageInput{ImageId: awsv2.String("ami-old")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the runner died after deregistering the AMI, before deleting its snapshot
+	stateFile := filepath.Join(t.TempDir(), "state.jsonl")
+	journal := `{"step":"started","run":{"command":"remove","args":["--amiID=ami-old","--state-file=` + stateFile + `"]}}
+{"step":"deregistered","region":"` + testRegion + `","amiId":"ami-old","snapshotIds":["snap-old"]}
+`
+	if err := os.WriteFile(stateFile, []byte(journal), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	runCommand(t, "resume", "--state-file", stateFile)
+
+	if backend.SnapshotExists("snap-old") {
+		t.Error("snap-old still exists after resuming the remove")
+	}
+	entries, err := aws.ReadJournal(stateFile)
+	if err != nil {
+		t.Fatalf("ReadJournal() error = %v", err)
+	}
+	if last := entries[len(entries)-1].Step; last != "finished" {
+		t.Errorf("last journal step = %s, want finished", last)
+	}
+}
+
+func TestRemoveCommandDryRun(t *testing.T) {
+	backend := newTestBackend(t)
+	seedImage(backend, "ami-old", "old", "2024-01-01T00:00:00.000Z", nil, "snap-old")
+
+	runCommand(t, "remove", "--amiID", "ami-old", "--dry-run")
+
+	if _, ok := backend.Image(testDefaultAccount, testRegion, "ami-old"); !ok {
+		t.Error("ami-old was removed during a dry run")
+	}
+	if len(backend.Calls("DeregisterImage")) != 0 || len(backend.Calls("DeleteSnapshot")) != 0 {
+		t.Error("dry run issued mutating calls")
+	}
+}
+
+func TestCleanupCommand(t *testing.T) {
+	backend := newTestBackend(t)
+	tags := map[string]string{"Name": "golden"}
+	seedImage(backend, "ami-1", "golden-1", "2024-01-01T00:00:00.000Z", tags, "snap-1")
+	seedImage(backend, "ami-2", "golden-2", "2024-02-01T00:00:00.000Z", tags, "snap-2")
+	seedImage(backend, "ami-3", "golden-3", "2024-03-01T00:00:00.000Z", tags, "snap-3")
+	seedImage(backend, "ami-other", "other", "2023-01-01T00:00:00.000Z", map[string]string{"Name": "other"}, "snap-other")
+
+	runCommand(t, "cleanup", "--amiID", "ami-3", "--regions", testRegion, "--tags", "Name", "--versions-to-keep", "2")
+
+	var remaining []string
+	for _, image := range backend.Images(testDefaultAccount, testRegion) {
+		remaining = append(remaining, *image.ImageId)
+	}
+	want := []string{"ami-2", "ami-3", "ami-other"}
+	if len(remaining) != len(want) {
+		t.Fatalf("remaining images = %v, want %v", remaining, want)
+	}
+	for i := range want {
+		if remaining[i] != want[i] {
+			t.Fatalf("remaining images = %v, want %v", remaining, want)
+		}
+	}
+	if backend.SnapshotExists("snap-1") {
+		t.Error("snap-1 still exists after cleanup")
+	}
+}
+
+func TestCleanupCommandReportsImagesItCannotDeregister(t *testing.T) {
+	backend := newTestBackend(t)
+	tags := map[string]string{"Name": "golden"}
+	seedImage(backend, "ami-1", "golden-1", "2024-01-01T00:00:00.000Z", tags, "snap-1")
+	seedImage(backend, "ami-2", "golden-2", "2024-02-01T00:00:00.000Z", tags, "snap-2")
+	seedImage(backend, "ami-3", "golden-3", "2024-03-01T00:00:00.000Z", tags, "snap-3")
+	backend.SetError(testDefaultAccount, testRegion, "DeregisterImage", errors.New("UnauthorizedOperation"))
+	stateFile := filepath.Join(t.TempDir(), "state.jsonl")
+
+	out, _ := runCommandExpectingExit(t, t.Context(), "cleanup", "--amiID", "ami-3", "--regions", testRegion, "--tags", "Name",
+		"--versions-to-keep", "1", "--state-file", stateFile)
+
+	for _, want := range []string{"ami-1", "ami-2", "not deleted: UnauthorizedOperation"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("summary does not contain %q:\n%s", want, out)
+		}
+	}
+	if len(backend.Calls("DeregisterImage")) != 2 {
+		t.Errorf("DeregisterImage calls = %d, want both old versions tried", len(backend.Calls("DeregisterImage")))
+	}
+	if _, err := aws.ResumeJournal(stateFile); err == nil {
+		t.Error("the state file of the cleanup is not finished")
+	}
+}
+
+func TestCleanupCommandDryRunPrintsJSONPlan(t *testing.T) {
+	backend := newTestBackend(t)
+	tags := map[string]string{"Name": "golden"}
+	seedImage(backend, "ami-1", "golden-1", "2024-01-01T00:00:00.000Z", tags, "snap-1")
+	seedImage(backend, "ami-2", "golden-2", "2024-02-01T00:00:00.000Z", map[string]string{"Name": "golden", "Release": "2.0"}, "snap-2")
+	seedImage(backend, "ami-3", "golden-3", "2024-03-01T00:00:00.000Z", tags, "snap-3")
+
+	var out bytes.Buffer
+	rootCmd.SetOut(&out)
+	defer rootCmd.SetOut(nil)
+	runCommand(t, "cleanup", "--amiID", "ami-3", "--regions", testRegion, "--tags", "Name", "--versions-to-keep", "1",
+		"--protect-tags", "Release", "--dry-run", "--output", "json")
+
+	if len(backend.Calls("DeregisterImage")) != 0 || len(backend.Calls("DeleteSnapshot")) != 0 {
+		t.Error("dry run issued mutating calls")
+	}
+	var plan aws.CleanupResult
+	if err := json.Unmarshal(out.Bytes(), &plan); err != nil {
+		t.Fatalf("plan is not JSON: %v\n%s", err, out.String())
+	}
+	if !plan.DryRun || plan.SourceAmiID != "ami-3" {
+		t.Errorf("plan = %+v, want a dry run for ami-3", plan)
+	}
+	regionPlan := plan.Regions[testRegion]
+	if regionPlan == nil || len(regionPlan.Images) != 3 {
+		t.Fatalf("plan for %s = %+v, want 3 images", testRegion, regionPlan)
+	}
+	if image := regionPlan.Images[1]; image.AmiID != "ami-2" || image.Reason != aws.CleanupReasonProtected {
+		t.Errorf("ami-2 = %+v, want it protected", image)
+	}
+	if image := regionPlan.Images[2]; image.AmiID != "ami-1" || image.Action != aws.CleanupActionDeregister || strings.Join(image.SnapshotIDs, ",") != "snap-1" {
+		t.Errorf("ami-1 = %+v, want it deregistered with snap-1", image)
+	}
+}
+
+func TestCleanupCommandAppliesRetentionFile(t *testing.T) {
+	backend := newTestBackend(t)
+	web := map[string]string{"Name": "golden", "Role": "web"}
+	worker := map[string]string{"Name": "golden", "Role": "worker"}
+	seedImage(backend, "ami-web-1", "web-1", "2024-01-01T00:00:00.000Z", web, "snap-web-1")
+	seedImage(backend, "ami-web-2", "web-2", "2024-02-01T00:00:00.000Z", web, "snap-web-2")
+	seedImage(backend, "ami-worker-1", "worker-1", "2024-01-01T00:00:00.000Z", worker, "snap-worker-1")
+	seedImage(backend, "ami-worker-2", "worker-2", "2024-02-01T00:00:00.000Z", worker, "snap-worker-2")
+	policyFile := filepath.Join(t.TempDir(), "retention.json")
+	if err := os.WriteFile(policyFile, []byte(`{"keepNewest": 3, "groupBy": ["Role"]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	rootCmd.SetOut(&out)
+	defer rootCmd.SetOut(nil)
+	// --versions-to-keep overrides the file
+	runCommand(t, "cleanup", "--amiID", "ami-web-2", "--regions", testRegion, "--tags", "Name", "--retention-file", policyFile, "--versions-to-keep", "1")
+
+	var remaining []string
+	for _, image := range backend.Images(testDefaultAccount, testRegion) {
+		remaining = append(remaining, *image.ImageId)
+	}
+	if strings.Join(remaining, ",") != "ami-web-2,ami-worker-2" {
+		t.Errorf("remaining images = %v, want the newest of every role", remaining)
+	}
+	if !strings.Contains(out.String(), "kept (newest in Role=worker)") {
+		t.Errorf("summary does not report the group:\n%s", out.String())
+	}
+}
+
+func TestCleanupCommandOnlyDescribesOwnedImages(t *testing.T) {
+	backend := newTestBackend(t)
+	tags := map[string]string{"Name": "golden"}
+	seedImage(backend, "ami-1", "golden-1", "2024-01-01T00:00:00.000Z", tags, "snap-1")
+	seedImage(backend, "ami-2", "golden-2", "2024-02-01T00:00:00.000Z", tags, "snap-2")
+
+	runCommand(t, "cleanup", "--amiID", "ami-2", "--regions", testRegion, "--tags", "Name", "--versions-to-keep", "1", "--dry-run")
+	runCommand(t, "cleanup", "--amiID", "ami-2", "--regions", testRegion, "--tags", "Name", "--versions-to-keep", "1", "--dry-run", "--owners", "self,"+testConsumer)
+
+	var owners []string
+	for _, call := range backend.Calls("DescribeImages") {
+		if input := call.Input.(*ec2.DescribeImagesInput); len(input.ImageIds) == 0 {
+			owners = append(owners, strings.Join(input.Owners, "+"))
+		}
+	}
+	if strings.Join(owners, ",") != "self,self+"+testConsumer {
+		t.Errorf("DescribeImages owners = %v, want self, then self and %s", owners, testConsumer)
+	}
+}
+
+func TestCleanupCommandKeepsAmisInUse(t *testing.T) {
+	backend := newTestBackend(t)
+	tags := map[string]string{"Name": "golden"}
+	seedImage(backend, "ami-1", "golden-1", "2024-01-01T00:00:00.000Z", tags, "snap-1")
+	seedImage(backend, "ami-2", "golden-2", "2024-02-01T00:00:00.000Z", tags, "snap-2")
+	seedImage(backend, "ami-3", "golden-3", "2024-03-01T00:00:00.000Z", tags, "snap-3")
+	seedImage(backend, "ami-4", "golden-4", "2024-04-01T00:00:00.000Z", tags, "snap-4")
+	backend.AddInstance(testDefaultAccount, testRegion, "ami-1", ec2Types.InstanceStateNameStopped)
+	backend.AddInstance(testDefaultAccount, testRegion, "ami-3", ec2Types.InstanceStateNameTerminated)
+	backend.AddLaunchTemplate(testConsumer, testRegion, "web", "ami-3", "ami-4")
+	backend.AddAutoScalingGroup(testConsumer, testRegion, asTypes.AutoScalingGroup{
+		AutoScalingGroupName: awsv2.String("web-asg"),
+		LaunchTemplate:       &asTypes.LaunchTemplateSpecification{LaunchTemplateName: awsv2.String("web"), Version: awsv2.String("1")},
+	})
+
+	var out bytes.Buffer
+	rootCmd.SetOut(&out)
+	defer rootCmd.SetOut(nil)
+	runCommand(t, "cleanup", "--amiID", "ami-4", "--regions", testRegion, "--tags", "Name", "--versions-to-keep", "1", "--accounts", testConsumer)
+
+	var remaining []string
+	for _, image := range backend.Images(testDefaultAccount, testRegion) {
+		remaining = append(remaining, *image.ImageId)
+	}
+	if strings.Join(remaining, ",") != "ami-1,ami-3,ami-4" {
+		t.Errorf("remaining images = %v, want ami-1, ami-3 and ami-4", remaining)
+	}
+	for _, want := range []string{"ami-1", "in use by instance", "ami-3", "Auto Scaling group web-asg in account " + testConsumer, "ami-2", "deleted"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("summary does not contain %q:\n%s", want, out.String())
+		}
+	}
+}
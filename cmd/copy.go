@@ -15,7 +15,12 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"maps"
+	"slices"
 	"strings"
 	"time"
 
@@ -24,6 +29,28 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var (
+	copyEncrypted      bool
+	copyKmsKeys        []string
+	copyShareSnapshots bool
+	copyKmsGrants      bool
+	copyTags           []string
+	copyRenameTags     []string
+	copyDropTags       []string
+	copyTagAfterCopy   bool
+	copyNameTemplate   string
+	copyDescTemplate   string
+	copyNoWait         bool
+	copyRetries        int
+	copyDeregister     bool
+	copyMaxConcurrency int
+	copyCheckOptIn     bool
+	copyMode           string
+
+	organizationArn        string
+	organizationalUnitArns []string
+)
+
 // copyCmd represents the copy command
 var copyCmd = &cobra.Command{
 	Use:   "copy",
@@ -31,13 +58,30 @@ var copyCmd = &cobra.Command{
 	Long: `Copies an AMI to a list of AWS regions and accounts.
 
 E.g. aws-ami-manager copy --amiID=ami-0e38977fc6310ea8b --regions=eu-west-1,eu-central-1 --accounts=123456789,987654321,192837465
+
+--regions also takes region groups, such as eu or us, all enabled regions, or all of them but some:
+aws-ami-manager copy --amiID=ami-0e38977fc6310ea8b --regions=all-except=us-west-1,ap --accounts=123456789
+
+Instead of, or next to, accounts the AMI's can be shared with a whole AWS Organization or with organizational units:
+aws-ami-manager copy --amiID=ami-0e38977fc6310ea8b --regions=eu-west-1 --ou-arns=arn:aws:organizations::123456789012:ou/o-abcde12345/ou-ab12-abcdefgh
+
+With --no-wait the copies are only started; the wait command shares and tags them once they are available.
+
+With --mode owned-copy the source AMI is shared with the accounts, which then copy it to every region themselves, so they own their copies:
+aws-ami-manager copy --amiID=ami-0e38977fc6310ea8b --regions=eu-west-1,us-east-1 --accounts=123456789 --mode owned-copy
+
+Encrypted copies can use a different KMS key per region:
+aws-ami-manager copy --amiID=ami-0e38977fc6310ea8b --regions=eu-west-1,us-east-1 --accounts=123456789 \
+  --kms-key eu-west-1=alias/ami,us-east-1=arn:aws:kms:us-east-1:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab
 	`,
 	Run: func(cmd *cobra.Command, args []string) {
-		runCopy()
+		journal := openJournal(cmd)
+		defer func() { _ = journal.Close() }()
+		runCopy(cmd.Context(), cmd.OutOrStdout(), journal)
 	},
 }
 
-func runCopy() {
+func runCopy(ctx context.Context, out io.Writer, journal *aws.Journal) {
 	log.Infof("Started copying AMI %s", amiID)
 	if len(accounts) > 0 {
 		log.WithFields(log.Fields{"accounts": strings.Join(accounts, ","), "role": role}).Info("Copying AMI across additional target account(s)")
@@ -46,13 +90,130 @@ func runCopy() {
 	}
 	start := time.Now()
 
-	loadAWSConfigForProfiles()
+	loadAWSConfigForProfiles(ctx)
+	targetRegions := resolveRegions(ctx)
+	if copyCheckOptIn {
+		var err error
+		if targetRegions, err = aws.RegionsEnabledInAccounts(ctx, targetRegions, accounts); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	kmsKeys, err := parseKeyValuePairs("kms-key", copyKmsKeys)
+	if err != nil {
+		log.Fatal(err)
+	}
+	addTags, err := parseKeyValuePairs("tag", copyTags)
+	if err != nil {
+		log.Fatal(err)
+	}
+	renameTags, err := parseKeyValuePairs("rename-tag", copyRenameTags)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts := aws.CopyOptions{
+		Mode:                aws.CopyMode(copyMode),
+		OrganizationTargets: organizationTargets(),
+		Encrypted:           copyEncrypted,
+		KmsKeyIDs:           kmsKeys,
+		ShareSnapshots:      copyShareSnapshots,
+		CreateKmsGrants:     copyKmsGrants,
+		TagRules: aws.TagRules{
+			Add:    addTags,
+			Rename: renameTags,
+			Drop:   copyDropTags,
+		},
+		TagAfterCopy:           copyTagAfterCopy,
+		NameTemplate:           copyNameTemplate,
+		DescriptionTemplate:    copyDescTemplate,
+		Wait:                   waitPolicy(),
+		NoWait:                 copyNoWait,
+		DeregisterFailedCopies: copyDeregister,
+		CopyRetries:            copyRetries,
+		MaxConcurrency:         copyMaxConcurrency,
+	}
+	if err := opts.Validate(aws.ConfigManager.GetDefaultRegion(), targetRegions); err != nil {
+		log.Fatalf("Invalid copy options: %v", err)
+	}
 
-	ami := aws.NewAmiWithRegions(amiID, aws.ConfigManager.GetDefaultRegion(), regions)
-	ami.Copy()
+	ami := aws.NewAmiWithRegions(amiID, aws.ConfigManager.GetDefaultRegion(), targetRegions)
+	ami.Journal = journal
+	result, err := ami.Copy(ctx, opts)
+	if err != nil {
+		exitIfInterrupted(ctx, "Copy")
+		log.Fatalf("Unable to copy AMI %s: %v", amiID, err)
+	}
 
 	elapsed := time.Since(start)
 	log.Infof("Finished copying AMI after %s", elapsed)
+
+	printCopySummary(out, result)
+	printWaitHint(out, result)
+
+	if ctx.Err() != nil {
+		for _, regionResult := range result.SortedRegions() {
+			if regionResult.CopyErr != nil && regionResult.AmiID != "" {
+				log.Warnf("Copy to %s was already started as %s; it will complete in AWS without launch permissions or tags", regionResult.Region, regionResult.AmiID)
+			}
+			for _, accountCopy := range regionResult.AccountCopies {
+				if accountCopy.CopyErr != nil && accountCopy.AmiID != "" {
+					log.Warnf("Copy to %s in account %s was already started as %s; it will complete in AWS without tags", regionResult.Region, accountCopy.Account, accountCopy.AmiID)
+				}
+			}
+		}
+		exitIfInterrupted(ctx, "Copy")
+	}
+
+	if failed := result.FailedRegions(); len(failed) > 0 {
+		log.Fatalf("Copying AMI %s failed in %d region(s): %s\n%v", amiID, len(failed), strings.Join(failed, ", "), result.Err())
+	}
+	journal.Finish()
+}
+
+// printCopySummary writes one line per region with the resulting AMI ID, followed by the failures.
+func printCopySummary(out io.Writer, result *aws.CopyResult) {
+	_, _ = fmt.Fprintf(out, "Copy summary for %s (source region %s):\n", result.SourceAmiID, result.SourceRegion)
+	for _, regionResult := range result.SortedRegions() {
+		amiID := regionResult.AmiID
+		if amiID == "" {
+			amiID = "-"
+		}
+		status := "ok"
+		switch {
+		case regionResult.Failed():
+			status = "FAILED"
+		case regionResult.Pending:
+			status = "copying"
+		}
+		if regionResult.Retries > 0 {
+			status += fmt.Sprintf(" (copied again after %d failed copies)", regionResult.Retries)
+		}
+		if regionResult.Reused {
+			status += " (existing copy)"
+		}
+		if regionResult.Resumed {
+			status += " (completed by the resumed run)"
+		}
+		_, _ = fmt.Fprintf(out, "  %-16s %-22s %s\n", regionResult.Region, amiID, status)
+		printAccountCopyResults(out, regionResult.AccountCopies)
+		printSnapshotShareResults(out, regionResult.Snapshots)
+		printKeyAccessResults(out, regionResult.KeyAccess)
+		if regionResult.KeyAccessErr != nil {
+			_, _ = fmt.Fprintf(out, "      KMS key access not checked: %v\n", regionResult.KeyAccessErr)
+		}
+		if err := regionResult.Err(); err != nil {
+			for _, line := range strings.Split(err.Error(), "\n") {
+				_, _ = fmt.Fprintf(out, "      %s\n", line)
+			}
+		}
+	}
+
+	printKeyAccessResults(out, result.SourceKeyAccess)
+	keyAccess := result.SourceKeyAccess
+	for _, regionResult := range result.SortedRegions() {
+		keyAccess = append(keyAccess, regionResult.KeyAccess...)
+	}
+	printKeyPolicyHints(out, keyAccess)
 }
 
 func init() {
@@ -61,17 +222,139 @@ func init() {
 	copyCmd.Flags().StringVar(&amiID, "amiID", "", "The source AMI ID, e.g. aws-0e38957fc6310ea8b")
 	_ = copyCmd.MarkFlagRequired("amiID")
 
-	copyCmd.Flags().StringSliceVar(&regions, "regions", []string{}, "The regions to copy this AMI to. Can be multiple flags, or a comma-separated value")
+	copyCmd.Flags().StringSliceVar(&regions, "regions", []string{}, "The regions to copy this AMI to: region names, region groups such as eu or us, all, or all-except= followed by the regions or groups to leave out. Can be multiple flags, or a comma-separated value")
 	_ = copyCmd.MarkFlagRequired("regions")
+	copyCmd.Flags().BoolVar(&copyCheckOptIn, "check-region-opt-in", false, "Skip the regions that are not enabled in every account in --accounts")
 
 	copyCmd.Flags().StringSliceVar(&accounts, "accounts", []string{}, "The account ID's that will be authorized to use the Ami's. Can be multiple flags, or a comma-separated value")
-	_ = copyCmd.MarkFlagRequired("accounts")
+	addOrganizationFlags(copyCmd)
+	copyCmd.MarkFlagsOneRequired("accounts", "organization-arn", "ou-arns")
 
+	copyCmd.Flags().StringVar(&copyMode, "mode", string(aws.CopyModeShare), fmt.Sprintf("How the accounts get the AMI: %s grants them launch permissions on copies in the default account, %s has every account in --accounts copy the shared source AMI, so it owns its copies", aws.CopyModeShare, aws.CopyModeOwnedCopy))
 	copyCmd.Flags().StringVar(&role, "role", aws.DefaultAssumeRole, fmt.Sprintf("The AWS IAM role to assume in the organizations. Defaults to '%s'.", aws.DefaultAssumeRole))
+
+	copyCmd.Flags().StringVar(&copyNameTemplate, "name-template", "", "Go template for the names of the copies, with .SourceName, .SourceId, .SourceRegion, .TargetRegion, .Date and .Tags, e.g. '{{.SourceName}}-{{.TargetRegion}}'. Defaults to the source AMI name.")
+	copyCmd.Flags().StringVar(&copyDescTemplate, "description-template", "", "Go template for the descriptions of the copies, with the same fields as --name-template")
+
+	copyCmd.Flags().StringArrayVar(&copyTags, "tag", []string{}, "Tag to set on the copies as key=value. The value is a Go template with .SourceAmiID, .SourceAmiName, .SourceRegion, .AmiID, .Region and .CopiedAt, e.g. SourceAmiId={{.SourceAmiID}}. Can be repeated.")
+	copyCmd.Flags().StringSliceVar(&copyRenameTags, "rename-tag", []string{}, "Source tag to copy under another key, as old=new. Can be multiple flags, or a comma-separated value")
+	copyCmd.Flags().StringSliceVar(&copyDropTags, "drop-tag", []string{}, "Source tag key not to copy. A trailing * drops every key with that prefix. Tags starting with aws: are never copied.")
+	copyCmd.Flags().BoolVar(&copyTagAfterCopy, "tag-after-copy", false, "Tag the copies and their snapshots once they are available, instead of when the copy is requested")
+
+	copyCmd.Flags().BoolVar(&copyEncrypted, "encrypted", false, "Encrypt every regional copy. Regions without a --kms-key use the account's default EBS KMS key.")
+	copyCmd.Flags().BoolVar(&copyShareSnapshots, "share-snapshots", true, "Also grant the accounts createVolumePermission on the EBS snapshots of every regional copy, so they can copy the AMI and create volumes from it. On by default; use --share-snapshots=false to share only the AMI.")
+	copyCmd.Flags().BoolVar(&copyKmsGrants, "kms-grants", false, "Create KMS grants for the accounts that cannot use the customer managed keys encrypting the copies. Without it, the key policy statement to add is printed instead.")
+	addStateFileFlag(copyCmd)
+	addWaitPolicyFlags(copyCmd)
+	addFailedCopyFlags(copyCmd)
+	copyCmd.Flags().BoolVar(&copyNoWait, "no-wait", false, "Only start the copies and print their IDs, without waiting for them. Share and tag them afterwards with the wait command.")
+	copyCmd.Flags().StringSliceVar(&copyKmsKeys, "kms-key", []string{}, "Region to KMS key mapping used to encrypt the regional copies, e.g. eu-west-1=alias/ami,us-east-1=arn:aws:kms:... Implies --encrypted for those regions.")
+}
+
+// printAccountCopyResults writes one line per account that made its own copy.
+func printAccountCopyResults(out io.Writer, results []aws.AccountCopyResult) {
+	for _, result := range results {
+		amiID := result.AmiID
+		if amiID == "" {
+			amiID = "-"
+		}
+		status := "ok"
+		if result.Err() != nil {
+			status = "FAILED"
+		}
+		if result.Reused {
+			status += " (existing copy)"
+		}
+		_, _ = fmt.Fprintf(out, "      account %s %-22s %s\n", result.Account, amiID, status)
+	}
+}
+
+// printSnapshotShareResults writes one line per shared snapshot.
+func printSnapshotShareResults(out io.Writer, results []aws.SnapshotShareResult) {
+	for _, result := range results {
+		status := "shared"
+		if result.Err != nil {
+			status = "FAILED"
+		}
+		_, _ = fmt.Fprintf(out, "      snapshot %-22s %s\n", result.SnapshotID, status)
+	}
+}
+
+// printKeyAccessResults writes one line per KMS key and account that was checked.
+func printKeyAccessResults(out io.Writer, results []aws.KeyAccessResult) {
+	for _, result := range results {
+		status := "ok"
+		switch {
+		case result.GrantID != "":
+			status = "granted " + result.GrantID
+		case result.GrantErr != nil:
+			status = "GRANT FAILED"
+		case errors.Is(result.AccessErr, aws.ErrAWSManagedKey):
+			status = "NO ACCESS (AWS managed key)"
+		case result.AccessErr != nil:
+			status = "NO ACCESS"
+		}
+		_, _ = fmt.Fprintf(out, "      kms key %s account %s %s\n", result.KeyArn, result.Account, status)
+	}
+}
+
+// printKeyPolicyHints prints the key policy statement to add for every key that accounts cannot use.
+func printKeyPolicyHints(out io.Writer, results []aws.KeyAccessResult) {
+	missing := aws.KeysWithoutAccess(results)
+	for _, keyArn := range slices.Sorted(maps.Keys(missing)) {
+		_, _ = fmt.Fprintf(out, "\nAccounts %s cannot use KMS key %s. Add this statement to its key policy, or create grants with copy --kms-grants:\n%s\n",
+			strings.Join(missing[keyArn], ", "), keyArn, aws.KeyPolicyStatement(keyArn, missing[keyArn]))
+	}
+}
+
+// addFailedCopyFlags adds the flags that control what happens to regional copies that fail.
+func addFailedCopyFlags(command *cobra.Command) {
+	command.Flags().BoolVar(&copyDeregister, "deregister-failed-copies", false, "Deregister a copy that fails, and delete its snapshots")
+	command.Flags().IntVar(&copyRetries, "copy-retries", 0, "The number of times to copy the AMI again to a region where the copy failed; requires --deregister-failed-copies")
+	command.Flags().IntVar(&copyMaxConcurrency, "max-concurrency", 0, "The number of regions to copy to, or share and tag in, at the same time. Defaults to every region at once.")
+}
+
+// addOrganizationFlags adds the flags to share with an AWS Organization or organizational units.
+func addOrganizationFlags(command *cobra.Command) {
+	command.Flags().StringVar(&organizationArn, "organization-arn", "", "The ARN of an AWS Organization whose accounts are authorized to use the AMI's, e.g. arn:aws:organizations::123456789012:organization/o-abcde12345")
+	command.Flags().StringSliceVar(&organizationalUnitArns, "ou-arns", []string{}, "The ARNs of organizational units whose accounts are authorized to use the AMI's. Can be multiple flags, or a comma-separated value")
+}
+
+func organizationTargets() aws.OrganizationTargets {
+	return aws.OrganizationTargets{
+		OrganizationArn:        strings.TrimSpace(organizationArn),
+		OrganizationalUnitArns: organizationalUnitArns,
+	}
+}
+
+// parseKeyValuePairs turns key=value flag values into a map. Keys must be unique.
+func parseKeyValuePairs(flagName string, values []string) (map[string]string, error) {
+	pairs := make(map[string]string, len(values))
+	for _, value := range values {
+		key, val, ok := strings.Cut(value, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid --%s value %q: expected key=value", flagName, value)
+		}
+		if _, exists := pairs[key]; exists {
+			return nil, fmt.Errorf("invalid --%s value %q: %s is given more than once", flagName, value, key)
+		}
+		pairs[key] = strings.TrimSpace(val)
+	}
+	return pairs, nil
+}
+
+// resolveRegions resolves the region groups, all and all-except= in --regions to region names.
+func resolveRegions(ctx context.Context) []string {
+	resolved, err := aws.ResolveRegions(ctx, regions)
+	if err != nil {
+		log.Fatalf("Unable to resolve --regions: %v", err)
+	}
+	return resolved
 }
 
-func loadAWSConfigForProfiles() {
-	cm, err := aws.NewConfigurationManagerForRegionsAndAccounts(regions, accounts, role)
+func loadAWSConfigForProfiles(ctx context.Context) {
+	cm, err := aws.NewConfigurationManagerForRegionsAndAccounts(ctx, regions, accounts, role, configurationOptions...)
 	if err != nil {
 		log.Fatalf("Failed to initialize AWS configuration: %v", err)
 	}
@@ -1,7 +1,9 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"time"
 
@@ -10,41 +12,97 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var (
+	diagnoseKmsKeys []string
+)
+
 var diagnoseCmd = &cobra.Command{
 	Use:   "diagnose",
 	Short: "Show resolved AWS configuration and attempt STS identity call",
+	Long: `Show resolved AWS configuration and attempt STS identity call.
+
+With --kms-key, also check whether the accounts given with --accounts can use the KMS keys, e.g.
+the keys encrypting an AMI shared with them. Each account is checked by assuming --role in it.
+
+E.g. aws-ami-manager diagnose --kms-key=arn:aws:kms:eu-west-1:111111111111:key/1234abcd-12ab-34cd-56ef-1234567890ab --accounts=222222222222
+	`,
 	Run: func(cmd *cobra.Command, args []string) {
-		runDiagnose()
+		runDiagnose(cmd.Context(), cmd.OutOrStdout())
 	},
 }
 
-func runDiagnose() {
+func runDiagnose(ctx context.Context, out io.Writer) {
 	start := time.Now()
-	fmt.Println("== aws-ami-manager diagnostics ==")
-	fmt.Printf("AWS_REGION env: %s\n", os.Getenv("AWS_REGION"))
-	fmt.Printf("AWS_DEFAULT_REGION env: %s\n", os.Getenv("AWS_DEFAULT_REGION"))
-	fmt.Printf("AWS_PROFILE env: %s\n", os.Getenv("AWS_PROFILE"))
-	fmt.Printf("Has AWS_ACCESS_KEY_ID: %v\n", os.Getenv("AWS_ACCESS_KEY_ID") != "")
-	fmt.Printf("Has AWS_SESSION_TOKEN: %v\n", os.Getenv("AWS_SESSION_TOKEN") != "")
-
-	cm, err := aws.NewConfigurationManager()
+	_, _ = fmt.Fprintln(out, "== aws-ami-manager diagnostics ==")
+	_, _ = fmt.Fprintf(out, "AWS_REGION env: %s\n", os.Getenv("AWS_REGION"))
+	_, _ = fmt.Fprintf(out, "AWS_DEFAULT_REGION env: %s\n", os.Getenv("AWS_DEFAULT_REGION"))
+	_, _ = fmt.Fprintf(out, "AWS_PROFILE env: %s\n", os.Getenv("AWS_PROFILE"))
+	_, _ = fmt.Fprintf(out, "Has AWS_ACCESS_KEY_ID: %v\n", os.Getenv("AWS_ACCESS_KEY_ID") != "")
+	_, _ = fmt.Fprintf(out, "Has AWS_SESSION_TOKEN: %v\n", os.Getenv("AWS_SESSION_TOKEN") != "")
+
+	var targetAccounts []string
+	if len(diagnoseKmsKeys) > 0 {
+		targetAccounts = accounts
+	}
+	cm, err := aws.NewConfigurationManagerForRegionsAndAccounts(ctx, []string{}, targetAccounts, role, configurationOptions...)
 	if err != nil {
-		fmt.Println("Configuration error:")
-		fmt.Println(err.Error())
-		fmt.Println("You can re-run with --loglevel=debug for more detail.")
+		_, _ = fmt.Fprintln(out, "Configuration error:")
+		_, _ = fmt.Fprintln(out, err.Error())
+		_, _ = fmt.Fprintln(out, "You can re-run with --loglevel=debug for more detail.")
 		return
 	}
 
-	fmt.Printf("Resolved Region: %s\n", cm.GetDefaultRegion())
+	_, _ = fmt.Fprintf(out, "Resolved Region: %s\n", cm.GetDefaultRegion())
 	if acct := cm.GetDefaultAccountID(); acct != nil {
-		fmt.Printf("Resolved Account ID: %s\n", *acct)
+		_, _ = fmt.Fprintf(out, "Resolved Account ID: %s\n", *acct)
 	} else {
-		fmt.Println("Resolved Account ID: <nil>")
+		_, _ = fmt.Fprintln(out, "Resolved Account ID: <nil>")
+	}
+
+	if len(diagnoseKmsKeys) > 0 {
+		aws.ConfigManager = cm
+		diagnoseKeyAccess(ctx, out, cm.GetDefaultRegion())
 	}
-	fmt.Printf("Elapsed: %s\n", time.Since(start))
+
+	_, _ = fmt.Fprintf(out, "Elapsed: %s\n", time.Since(start))
 	log.Info("Diagnostics complete")
 }
 
+// diagnoseKeyAccess checks every --kms-key for the --accounts and prints the key policy statement
+// for the keys that accounts cannot use.
+func diagnoseKeyAccess(ctx context.Context, out io.Writer, defaultRegion string) {
+	var all []aws.KeyAccessResult
+	for _, key := range diagnoseKmsKeys {
+		region := aws.KmsKeyRegion(key)
+		if region == "" {
+			region = defaultRegion
+		}
+
+		_, _ = fmt.Fprintf(out, "KMS key %s (%s):\n", key, region)
+		results, err := aws.CheckKeyAccess(ctx, region, key, accounts, false)
+		if err != nil {
+			_, _ = fmt.Fprintf(out, "  %v\n", err)
+			continue
+		}
+		if len(results) == 0 {
+			_, _ = fmt.Fprintln(out, "  no other accounts to check; pass them with --accounts")
+		}
+		for _, result := range results {
+			if result.AccessErr != nil {
+				_, _ = fmt.Fprintf(out, "  account %s: NO ACCESS (%v)\n", result.Account, result.AccessErr)
+			} else {
+				_, _ = fmt.Fprintf(out, "  account %s: ok\n", result.Account)
+			}
+		}
+		all = append(all, results...)
+	}
+	printKeyPolicyHints(out, all)
+}
+
 func init() {
 	rootCmd.AddCommand(diagnoseCmd)
+
+	diagnoseCmd.Flags().StringSliceVar(&diagnoseKmsKeys, "kms-key", []string{}, "KMS key ID, alias or ARN to check access to for the --accounts. Can be multiple flags, or a comma-separated value")
+	diagnoseCmd.Flags().StringSliceVar(&accounts, "accounts", []string{}, "The account ID's to check KMS key access for.")
+	diagnoseCmd.Flags().StringVar(&role, "role", aws.DefaultAssumeRole, fmt.Sprintf("The AWS IAM role to assume in the accounts to check KMS key access. Defaults to '%s'.", aws.DefaultAssumeRole))
 }
@@ -15,6 +15,7 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/cloudnatives/aws-ami-manager/aws"
@@ -36,12 +37,14 @@ E.g. ./aws-ami-manager remove --amiID=ami-075d87a3d4512bee5 --region=eu-west-1
 You can target another account by adding --accounts <id> --role <RoleName>.
 Use --dry-run to preview what would be deleted (AMI + snapshots).`,
 	Run: func(cmd *cobra.Command, args []string) {
-		runRemove()
+		journal := openJournal(cmd)
+		defer func() { _ = journal.Close() }()
+		runRemove(cmd.Context(), journal)
 	},
 }
 
-func runRemove() {
-	cm, err := aws.NewConfigurationManager()
+func runRemove(ctx context.Context, journal *aws.Journal) {
+	cm, err := aws.NewConfigurationManager(ctx, configurationOptions...)
 	if err != nil {
 		log.Fatalf("Failed to initialize AWS configuration: %v", err)
 	}
@@ -56,21 +59,24 @@ func runRemove() {
 		}
 		acct := accounts[0]
 		log.Infof("Assuming role %s in account %s for remove operation", role, acct)
-		if err := cm.AssumeDefaultAccountRole(acct, role); err != nil {
+		if err := cm.AssumeDefaultAccountRole(ctx, acct, role); err != nil {
 			log.Fatalf("Unable to assume role %s in account %s: %v", role, acct, err)
 		}
 	}
 
 	ami := aws.NewAmi(amiID)
 	ami.SourceRegion = cm.GetDefaultRegion()
+	ami.Journal = journal
 
 	aws.ConfigManager = cm
 
-	err = ami.RemoveAmi(removeDryRun)
+	err = ami.RemoveAmi(ctx, removeDryRun)
 
 	if err != nil {
+		exitIfInterrupted(ctx, "Remove")
 		log.Fatal(err)
 	}
+	journal.Finish()
 
 	if removeDryRun {
 		log.Infof("[dry-run] Completed successfully; no changes made for AMI %s", ami.SourceAmiID)
@@ -87,5 +93,6 @@ func init() {
 
 	removeCmd.Flags().StringSliceVar(&accounts, "accounts", []string{}, "Optional: Account ID(s) to assume into for this operation (only first is used).")
 	removeCmd.Flags().StringVar(&role, "role", aws.DefaultAssumeRole, fmt.Sprintf("Role name to assume in the provided account. Defaults to '%s'. When --accounts is set this role must exist in that account.", aws.DefaultAssumeRole))
+	addStateFileFlag(removeCmd)
 	removeCmd.Flags().BoolVar(&removeDryRun, "dry-run", false, "Show what would be removed without performing deregistration or snapshot deletion.")
 }
@@ -0,0 +1,118 @@
+// Copyright © 2019 Jeroen Schepens <jeroen@cloudnatives.be>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"strings"
+
+	"github.com/cloudnatives/aws-ami-manager/aws"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var (
+	stateFile string
+
+	// resumeJournal is the journal of the run that resume continues, picked up by the resumed command.
+	resumeJournal *aws.Journal
+	// journaledCommands are the commands with a --state-file, which resume can continue.
+	journaledCommands = make(map[string]bool)
+)
+
+// resumeCmd represents the resume command
+var resumeCmd = &cobra.Command{
+	Use:   "resume",
+	Short: "Resumes a copy, remove or cleanup run recorded in a state file",
+	Long: `Resumes a copy, remove or cleanup run that was started with --state-file and did not finish,
+e.g. because the runner died while waiting for the regional copies.
+
+E.g. ./aws-ami-manager resume --state-file=copy-state.jsonl
+
+The run is started again with the same flags. Regions it completed are skipped, copies it started
+are reused, and snapshots of AMIs it deregistered are deleted.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runResume(cmd)
+	},
+}
+
+func runResume(cmd *cobra.Command) {
+	journal, err := aws.ResumeJournal(stateFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	run := journal.Run()
+
+	command, flags, err := rootCmd.Find(append([]string{run.Command}, run.Args...))
+	if err != nil || !journaledCommands[command.Name()] {
+		log.Fatalf("State file %s records the %q command, which cannot be resumed", stateFile, run.Command)
+	}
+	if err := command.ParseFlags(flags); err != nil {
+		log.Fatalf("State file %s records invalid flags for %s: %v", stateFile, run.Command, err)
+	}
+	rootCmd.PersistentPreRun(command, nil)
+
+	log.Infof("Resuming %s %s", run.Command, strings.Join(run.Args, " "))
+	resumeJournal = journal
+	defer func() { resumeJournal = nil }()
+	command.SetContext(cmd.Context())
+	command.Run(command, command.Flags().Args())
+}
+
+// addStateFileFlag adds the --state-file flag to a command that records its steps in a journal.
+func addStateFileFlag(command *cobra.Command) {
+	journaledCommands[command.Name()] = true
+	command.Flags().StringVar(&stateFile, "state-file", "", "Record every step in this file as it happens, so the run can be continued with the resume command if it dies halfway")
+}
+
+// openJournal returns the journal for command: the one resume continues, a new one in the
+// --state-file, or nil without a state file.
+func openJournal(command *cobra.Command) *aws.Journal {
+	journal := resumeJournal
+	if journal == nil {
+		if stateFile == "" {
+			return nil
+		}
+		var err error
+		if journal, err = aws.OpenJournal(stateFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	journal.Start(aws.JournalRun{Command: command.Name(), Args: commandArgs(command)})
+	return journal
+}
+
+// commandArgs returns the flags that were set on command, including the global ones, in a form
+// that parses to the same values.
+func commandArgs(command *cobra.Command) []string {
+	var args []string
+	command.Flags().Visit(func(flag *pflag.Flag) {
+		if slice, ok := flag.Value.(pflag.SliceValue); ok {
+			for _, value := range slice.GetSlice() {
+				args = append(args, "--"+flag.Name+"="+value)
+			}
+			return
+		}
+		args = append(args, "--"+flag.Name+"="+flag.Value.String())
+	})
+	return args
+}
+
+func init() {
+	rootCmd.AddCommand(resumeCmd)
+
+	resumeCmd.Flags().StringVar(&stateFile, "state-file", "", "The state file of the run to resume")
+	_ = resumeCmd.MarkFlagRequired("state-file")
+}
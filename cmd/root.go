@@ -15,8 +15,11 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/cloudnatives/aws-ami-manager/aws"
 	"github.com/sirupsen/logrus"
@@ -32,6 +35,10 @@ var (
 	role           string
 	regionOverride string
 	profileName    string
+
+	// configurationOptions are passed to every ConfigurationManager the commands create. Tests use
+	// it to plug in fake AWS clients.
+	configurationOptions []aws.Option
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -50,17 +57,45 @@ set launch permissions, tag them, and clean up older versions.`,
 	},
 }
 
+// exitCodeInterrupted is the conventional exit status of a process stopped by SIGINT.
+const exitCodeInterrupted = 130
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
 	aws.SetLogLevel(logLevel)
 
-	if err := rootCmd.Execute(); err != nil {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+			// the command returned without a signal
+			return
+		}
+		// restore the default behavior, so a second interrupt kills the process right away
+		stop()
+		logrus.Warn("Interrupt received, stopping after the AWS calls in flight. Interrupt again to exit immediately.")
+	}()
+
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 }
 
+// exitIfInterrupted ends the process with exitCodeInterrupted when the command's context was cancelled
+// by a signal.
+func exitIfInterrupted(ctx context.Context, operation string) {
+	if ctx.Err() != nil {
+		logrus.Warnf("%s was interrupted", operation)
+		logrus.StandardLogger().Exit(exitCodeInterrupted)
+	}
+}
+
 func init() {
 	rootCmd.PersistentFlags().StringVar(&logLevel, "loglevel", logrus.DebugLevel.String(), "Set the log level (debug, info, warn, error)")
 	rootCmd.PersistentFlags().StringVar(&regionOverride, "region", "", "AWS region to use (overrides AWS_REGION/AWS_DEFAULT_REGION env vars)")
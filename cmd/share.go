@@ -0,0 +1,96 @@
+// Copyright © 2019 Jeroen Schepens <jeroen@cloudnatives.be>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/cloudnatives/aws-ami-manager/aws"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	shareSnapshots bool
+)
+
+// shareCmd represents the share command
+var shareCmd = &cobra.Command{
+	Use:   "share",
+	Short: "Shares an existing AMI and its snapshots with a list of AWS accounts",
+	Long: `Shares an existing AMI in your current region with a list of AWS accounts.
+
+It grants launch permissions on the AMI and createVolumePermission on its EBS snapshots,
+so the accounts can launch, copy and create volumes from it.
+
+E.g. aws-ami-manager share --amiID=ami-0e38977fc6310ea8b --accounts=123456789012,987654321098 --region=eu-west-1
+
+Use --organization-arn or --ou-arns to share the AMI with every account in an AWS Organization or
+organizational unit. Snapshots can only be shared with the listed accounts.
+	`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runShare(cmd.Context(), cmd.OutOrStdout())
+	},
+}
+
+func runShare(ctx context.Context, out io.Writer) {
+	cm, err := aws.NewConfigurationManager(ctx, configurationOptions...)
+	if err != nil {
+		log.Fatalf("Failed to initialize AWS configuration: %v", err)
+	}
+
+	ami := aws.NewAmi(amiID)
+	ami.SourceRegion = cm.GetDefaultRegion()
+
+	aws.ConfigManager = cm
+
+	result, err := ami.Share(ctx, accounts, aws.ShareOptions{
+		OrganizationTargets: organizationTargets(),
+		ShareSnapshots:      shareSnapshots,
+	})
+	if err != nil {
+		exitIfInterrupted(ctx, "Share")
+		log.Fatal(err)
+	}
+
+	status := "ok"
+	if result.PermissionErr != nil {
+		status = "FAILED"
+	}
+	_, _ = fmt.Fprintf(out, "Share summary for %s in %s:\n", result.AmiID, result.Region)
+	_, _ = fmt.Fprintf(out, "  launch permissions %s\n", status)
+	printSnapshotShareResults(out, result.Snapshots)
+
+	if err := result.Err(); err != nil {
+		exitIfInterrupted(ctx, "Share")
+		log.Fatalf("Sharing AMI %s failed:\n%v", ami.SourceAmiID, err)
+	}
+	log.Infof("AMI %s has been shared successfully", ami.SourceAmiID)
+}
+
+func init() {
+	rootCmd.AddCommand(shareCmd)
+
+	shareCmd.Flags().StringVar(&amiID, "amiID", "", "The AMI ID to share, e.g. ami-0e38957fc6310ea8b")
+	_ = shareCmd.MarkFlagRequired("amiID")
+
+	shareCmd.Flags().StringSliceVar(&accounts, "accounts", []string{}, "The account ID's to share the AMI with. Can be multiple flags, or a comma-separated value")
+	addOrganizationFlags(shareCmd)
+	shareCmd.MarkFlagsOneRequired("accounts", "organization-arn", "ou-arns")
+
+	shareCmd.Flags().BoolVar(&shareSnapshots, "snapshots", true, "Also grant createVolumePermission on the EBS snapshots of the AMI.")
+}
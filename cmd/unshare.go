@@ -0,0 +1,151 @@
+// Copyright © 2019 Jeroen Schepens <jeroen@cloudnatives.be>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/cloudnatives/aws-ami-manager/aws"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	unshareSnapshots bool
+	unshareDryRun    bool
+)
+
+// unshareCmd represents the unshare command
+var unshareCmd = &cobra.Command{
+	Use:   "unshare",
+	Short: "Revokes the launch permissions of accounts, organizations or OUs on an AMI and its copies",
+	Long: `Revokes the launch permissions of a list of AWS accounts, organizations or organizational units
+on an AMI in your current region and on its copies in --regions. Copies are found by their source AMI ID
+and source region, and every copy in a region is unshared.
+
+E.g. aws-ami-manager unshare --amiID=ami-0e38977fc6310ea8b --regions=eu-west-1,eu-central-1 --accounts=123456789012 --snapshots
+Use --dry-run to preview the permissions that would be revoked.
+	`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runUnshare(cmd.Context(), cmd.OutOrStdout())
+	},
+}
+
+func runUnshare(ctx context.Context, out io.Writer) {
+	cm, err := aws.NewConfigurationManager(ctx, configurationOptions...)
+	if err != nil {
+		log.Fatalf("Failed to initialize AWS configuration: %v", err)
+	}
+
+	ami := aws.NewAmi(amiID)
+	ami.SourceRegion = cm.GetDefaultRegion()
+
+	aws.ConfigManager = cm
+
+	targetRegions := regions
+	if len(targetRegions) == 0 {
+		targetRegions = []string{cm.GetDefaultRegion()}
+	}
+
+	result, err := ami.Unshare(ctx, targetRegions, accounts, aws.UnshareOptions{
+		OrganizationTargets: organizationTargets(),
+		Snapshots:           unshareSnapshots,
+		DryRun:              unshareDryRun,
+	})
+	if err != nil {
+		exitIfInterrupted(ctx, "Unshare")
+		log.Fatal(err)
+	}
+
+	printUnshareSummary(out, result)
+
+	if err := result.Err(); err != nil {
+		exitIfInterrupted(ctx, "Unshare")
+		log.Fatalf("Unsharing AMI %s failed:\n%v", ami.SourceAmiID, err)
+	}
+	if unshareDryRun {
+		log.Infof("[dry-run] Completed successfully; no changes made for AMI %s", ami.SourceAmiID)
+		return
+	}
+	log.Infof("AMI %s has been unshared successfully", ami.SourceAmiID)
+}
+
+// printUnshareSummary writes the permissions before and after per region, AMI and snapshot.
+func printUnshareSummary(out io.Writer, result *aws.UnshareResult) {
+	title := "Unshare summary"
+	if result.DryRun {
+		title += " (dry run, no changes made)"
+	}
+	_, _ = fmt.Fprintf(out, "%s for %s:\n", title, result.SourceAmiID)
+	for _, regionResult := range result.SortedRegions() {
+		if regionResult.Err != nil {
+			_, _ = fmt.Fprintf(out, "  %-16s FAILED\n      %v\n", regionResult.Region, regionResult.Err)
+			continue
+		}
+		if len(regionResult.Images) == 0 {
+			_, _ = fmt.Fprintf(out, "  %-16s no copy found\n", regionResult.Region)
+			continue
+		}
+		for _, image := range regionResult.Images {
+			printImageUnshareResult(out, regionResult.Region, image)
+		}
+	}
+}
+
+func printImageUnshareResult(out io.Writer, region string, image *aws.ImageUnshareResult) {
+	status := "ok"
+	if image.Failed() {
+		status = "FAILED"
+	}
+	_, _ = fmt.Fprintf(out, "  %-16s %-22s %s\n", region, image.AmiID, status)
+	if image.Err != nil {
+		_, _ = fmt.Fprintf(out, "      %v\n", image.Err)
+		return
+	}
+	_, _ = fmt.Fprintf(out, "      launch permissions before: %s\n", formatPrincipals(image.Before))
+	_, _ = fmt.Fprintf(out, "      launch permissions after:  %s\n", formatPrincipals(image.After))
+	for _, snapshot := range image.Snapshots {
+		if snapshot.Err != nil {
+			_, _ = fmt.Fprintf(out, "      snapshot %s FAILED: %v\n", snapshot.SnapshotID, snapshot.Err)
+			continue
+		}
+		_, _ = fmt.Fprintf(out, "      snapshot %s before: %s after: %s\n", snapshot.SnapshotID, formatPrincipals(snapshot.Before), formatPrincipals(snapshot.After))
+	}
+}
+
+func formatPrincipals(principals []string) string {
+	if len(principals) == 0 {
+		return "-"
+	}
+	return strings.Join(principals, ", ")
+}
+
+func init() {
+	rootCmd.AddCommand(unshareCmd)
+
+	unshareCmd.Flags().StringVar(&amiID, "amiID", "", "The AMI ID in your current region, e.g. ami-0e38957fc6310ea8b")
+	_ = unshareCmd.MarkFlagRequired("amiID")
+
+	unshareCmd.Flags().StringSliceVar(&regions, "regions", []string{}, "The regions to revoke permissions in. Defaults to your current region. Can be multiple flags, or a comma-separated value")
+	unshareCmd.Flags().StringSliceVar(&accounts, "accounts", []string{}, "The account ID's to revoke permissions for. Can be multiple flags, or a comma-separated value")
+	addOrganizationFlags(unshareCmd)
+	unshareCmd.MarkFlagsOneRequired("accounts", "organization-arn", "ou-arns")
+
+	unshareCmd.Flags().BoolVar(&unshareSnapshots, "snapshots", false, "Also revoke the accounts' createVolumePermission on the EBS snapshots.")
+	unshareCmd.Flags().BoolVar(&unshareDryRun, "dry-run", false, "Show which permissions would be revoked without changing them.")
+}
@@ -0,0 +1,159 @@
+// Copyright © 2019 Jeroen Schepens <jeroen@cloudnatives.be>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cloudnatives/aws-ami-manager/aws"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	waitImages []string
+
+	waitInterval    time.Duration
+	waitMaxInterval time.Duration
+	waitWarnAfter   time.Duration
+	waitTimeout     time.Duration
+	waitSDKWaiter   bool
+)
+
+// waitCmd represents the wait command
+var waitCmd = &cobra.Command{
+	Use:   "wait",
+	Short: "Waits for copies started with copy --no-wait, then shares and tags them",
+	Long: `Waits until the regional copies started with copy --no-wait are available, and then grants the
+launch permissions, shares the snapshots and tags the copies like copy does.
+
+E.g. aws-ami-manager wait --images=eu-central-1=ami-0123456789abcdef0,us-east-1=ami-0fedcba9876543210 --accounts=123456789,987654321
+
+Pass the same accounts, organization and tag flags that were given to copy.
+	`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runWait(cmd.Context(), cmd.OutOrStdout())
+	},
+}
+
+func runWait(ctx context.Context, out io.Writer) {
+	copies, err := parseKeyValuePairs("images", waitImages)
+	if err != nil {
+		log.Fatal(err)
+	}
+	addTags, err := parseKeyValuePairs("tag", copyTags)
+	if err != nil {
+		log.Fatal(err)
+	}
+	renameTags, err := parseKeyValuePairs("rename-tag", copyRenameTags)
+	if err != nil {
+		log.Fatal(err)
+	}
+	start := time.Now()
+
+	regions = slices.Sorted(maps.Keys(copies))
+	loadAWSConfigForProfiles(ctx)
+
+	result, err := aws.WaitForCopies(ctx, copies, aws.CopyOptions{
+		OrganizationTargets: organizationTargets(),
+		ShareSnapshots:      copyShareSnapshots,
+		CreateKmsGrants:     copyKmsGrants,
+		TagRules: aws.TagRules{
+			Add:    addTags,
+			Rename: renameTags,
+			Drop:   copyDropTags,
+		},
+		Wait:                   waitPolicy(),
+		DeregisterFailedCopies: copyDeregister,
+		CopyRetries:            copyRetries,
+		MaxConcurrency:         copyMaxConcurrency,
+	})
+	if err != nil {
+		exitIfInterrupted(ctx, "Wait")
+		log.Fatalf("Unable to wait for the copies: %v", err)
+	}
+
+	log.Infof("Finished waiting for the copies after %s", time.Since(start))
+
+	printCopySummary(out, result)
+	exitIfInterrupted(ctx, "Wait")
+
+	if failed := result.FailedRegions(); len(failed) > 0 {
+		log.Fatalf("Waiting for the copies of AMI %s failed in %d region(s): %s\n%v", result.SourceAmiID, len(failed), strings.Join(failed, ", "), result.Err())
+	}
+}
+
+// printWaitHint prints the wait command that shares and tags the copies that were started without
+// waiting for them.
+func printWaitHint(out io.Writer, result *aws.CopyResult) {
+	pending := result.PendingCopies()
+	if len(pending) == 0 {
+		return
+	}
+	images := make([]string, 0, len(pending))
+	for _, region := range slices.Sorted(maps.Keys(pending)) {
+		images = append(images, region+"="+pending[region])
+	}
+	_, _ = fmt.Fprintf(out, "\nThe copies are not shared or tagged yet. Once they are available, run with the same accounts and tag flags:\n  aws-ami-manager wait --images=%s\n",
+		strings.Join(images, ","))
+}
+
+// addWaitPolicyFlags adds the flags that control how a command waits for regional copies.
+func addWaitPolicyFlags(command *cobra.Command) {
+	defaults := aws.DefaultWaitPolicy()
+	command.Flags().DurationVar(&waitInterval, "wait-interval", defaults.Interval, "The time to wait before checking a copy for the first time. Every next check waits this much longer, up to --wait-max-interval.")
+	command.Flags().DurationVar(&waitMaxInterval, "wait-max-interval", defaults.MaxInterval, "The longest time to wait between two checks of a copy")
+	command.Flags().DurationVar(&waitWarnAfter, "wait-warn-after", defaults.WarnAfter, "Log a warning when a copy is not available after this long")
+	command.Flags().DurationVar(&waitTimeout, "wait-timeout", defaults.Timeout, "Fail the region when its copy is not available after this long")
+	command.Flags().BoolVar(&waitSDKWaiter, "sdk-waiter", false, "Wait with the AWS SDK's image available waiter, which backs off exponentially between --wait-interval and --wait-max-interval and stops as soon as a copy fails")
+}
+
+func waitPolicy() aws.WaitPolicy {
+	return aws.WaitPolicy{
+		Interval:     waitInterval,
+		MaxInterval:  waitMaxInterval,
+		WarnAfter:    waitWarnAfter,
+		Timeout:      waitTimeout,
+		UseSDKWaiter: waitSDKWaiter,
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(waitCmd)
+
+	waitCmd.Flags().StringSliceVar(&waitImages, "images", []string{}, "The copies to wait for, as region=ami-id, e.g. us-east-1=ami-0fedcba9876543210. Can be multiple flags, or a comma-separated value")
+	_ = waitCmd.MarkFlagRequired("images")
+
+	waitCmd.Flags().StringSliceVar(&accounts, "accounts", []string{}, "The account ID's that will be authorized to use the Ami's. Can be multiple flags, or a comma-separated value")
+	addOrganizationFlags(waitCmd)
+	waitCmd.MarkFlagsOneRequired("accounts", "organization-arn", "ou-arns")
+
+	waitCmd.Flags().StringVar(&role, "role", aws.DefaultAssumeRole, fmt.Sprintf("The AWS IAM role to assume in the organizations. Defaults to '%s'.", aws.DefaultAssumeRole))
+
+	waitCmd.Flags().StringArrayVar(&copyTags, "tag", []string{}, "Tag to set on the copies as key=value, like copy --tag. Can be repeated.")
+	waitCmd.Flags().StringSliceVar(&copyRenameTags, "rename-tag", []string{}, "Source tag to copy under another key, as old=new. Can be multiple flags, or a comma-separated value")
+	waitCmd.Flags().StringSliceVar(&copyDropTags, "drop-tag", []string{}, "Source tag key not to copy. A trailing * drops every key with that prefix.")
+
+	waitCmd.Flags().BoolVar(&copyShareSnapshots, "share-snapshots", true, "Also grant the accounts createVolumePermission on the EBS snapshots of the copies. On by default, like for copy.")
+	waitCmd.Flags().BoolVar(&copyKmsGrants, "kms-grants", false, "Create KMS grants for the accounts that cannot use the customer managed keys encrypting the copies.")
+	addWaitPolicyFlags(waitCmd)
+	addFailedCopyFlags(waitCmd)
+}
@@ -0,0 +1,946 @@
+// Package ec2fake provides an in-memory, stateful stand-in for the EC2 image, KMS key and Auto
+// Scaling APIs used by aws-ami-manager. It is meant for tests only: images, snapshots, tags, launch
+// permissions, KMS keys and the instances, launch templates and Auto Scaling groups using the images
+// are tracked per account and region so whole commands can run without network access.
+package ec2fake
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	stsTypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/aws/smithy-go"
+)
+
+// Call records a single API call made against the backend.
+type Call struct {
+	Account   string
+	Region    string
+	Operation string
+	Input     interface{}
+}
+
+type injectKey struct {
+	location
+	operation string
+}
+
+type location struct {
+	account string
+	region  string
+}
+
+type image struct {
+	ec2Types.Image
+
+	owner        string
+	region       string
+	pendingPolls int
+	// fails turns the image `failed` instead of `available` once it is no longer pending
+	fails bool
+	// tags are account-local: the owner and every consumer account see their own set
+	tags          map[string][]ec2Types.Tag
+	launchAccount map[string]bool
+	// launchOrganization holds the organization and organizational unit ARNs with launch permission
+	launchOrganization map[string]bool
+	public             bool
+}
+
+type snapshot struct {
+	id            string
+	owner         string
+	region        string
+	encrypted     bool
+	kmsKeyID      string
+	tags          []ec2Types.Tag
+	volumeAccount map[string]bool
+}
+
+// Backend is an in-memory EC2 image store shared by every client it hands out.
+type Backend struct {
+	mu sync.Mutex
+
+	// DefaultAccount is returned by GetCallerIdentity for clients that were not created for a
+	// specific account.
+	DefaultAccount string
+	// PendingPolls is the number of DescribeImages calls a copied or seeded `pending` image stays
+	// `pending` for before it turns `available`.
+	PendingPolls int
+	// FailedCopies is the number of copies, from now on, that turn `failed` instead of `available`.
+	FailedCopies int
+	// Now returns the creation time stamped on copied images. Defaults to time.Now.
+	Now func() time.Time
+	// Regions are the regions DescribeRegions returns as enabled, unless DisableRegion disabled
+	// them for the account. Defaults to DefaultRegions.
+	Regions []string
+	// PageSize is the number of images DescribeImages returns per page when it is not given
+	// ImageIds, or all of them when 0.
+	PageSize int
+
+	images    map[string]*image
+	snapshots map[string]*snapshot
+	keys      map[string]*key
+	nextID    int
+	calls     []Call
+	errors    map[injectKey]error
+	disabled  map[location]bool
+	resources map[location]*resources
+}
+
+// DefaultRegions are the regions of a new backend.
+var DefaultRegions = []string{
+	"ap-northeast-1", "ap-south-1", "ap-southeast-1", "ca-central-1", "eu-central-1", "eu-north-1",
+	"eu-west-1", "eu-west-2", "sa-east-1", "us-east-1", "us-east-2", "us-west-1", "us-west-2",
+}
+
+// New creates an empty backend whose default credentials belong to defaultAccount.
+func New(defaultAccount string) *Backend {
+	return &Backend{
+		DefaultAccount: defaultAccount,
+		Now:            time.Now,
+		images:         make(map[string]*image),
+		snapshots:      make(map[string]*snapshot),
+		keys:           make(map[string]*key),
+		errors:         make(map[injectKey]error),
+		disabled:       make(map[location]bool),
+		Regions:        slices.Clone(DefaultRegions),
+	}
+}
+
+// DisableRegion makes region an opt-in region that account did not opt in to, so DescribeRegions
+// in that account leaves it out.
+func (b *Backend) DisableRegion(account, region string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.disabled[location{account: account, region: region}] = true
+}
+
+// SetError makes every call to operation by account in region fail with err. Passing a nil error
+// removes the injected failure.
+func (b *Backend) SetError(account, region, operation string, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	key := injectKey{location: location{account: account, region: region}, operation: operation}
+	if err == nil {
+		delete(b.errors, key)
+		return
+	}
+	b.errors[key] = err
+}
+
+// AddImage seeds an image owned by account in region. Snapshots referenced by the block device
+// mappings are registered as well. Images without a state are made `available`; `pending` images
+// stay pending for PendingPolls describes.
+func (b *Backend) AddImage(account, region string, img ec2Types.Image) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if img.State == "" {
+		img.State = ec2Types.ImageStateAvailable
+	}
+	img.OwnerId = awsv2.String(account)
+	stored := &image{
+		Image:              img,
+		owner:              account,
+		region:             region,
+		pendingPolls:       b.PendingPolls,
+		tags:               map[string][]ec2Types.Tag{account: img.Tags},
+		launchAccount:      make(map[string]bool),
+		launchOrganization: make(map[string]bool),
+		public:             img.Public != nil && *img.Public,
+	}
+	stored.Tags = nil
+	b.images[*img.ImageId] = stored
+
+	for _, mapping := range img.BlockDeviceMappings {
+		if mapping.Ebs != nil && mapping.Ebs.SnapshotId != nil {
+			snap := newSnapshot(*mapping.Ebs.SnapshotId, account, region)
+			snap.encrypted = awsv2.ToBool(mapping.Ebs.Encrypted)
+			if snap.encrypted {
+				snap.kmsKeyID = b.keyArn(location{account: account, region: region}, awsv2.ToString(mapping.Ebs.KmsKeyId))
+			}
+			b.snapshots[snap.id] = snap
+		}
+	}
+}
+
+// Image returns the image as seen by account, and whether it exists in region.
+func (b *Backend) Image(account, region, id string) (ec2Types.Image, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	img, ok := b.images[id]
+	if !ok || img.region != region {
+		return ec2Types.Image{}, false
+	}
+	return img.view(account), true
+}
+
+// Images returns the images owned by account in region, sorted by ID.
+func (b *Backend) Images(account, region string) []ec2Types.Image {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var images []ec2Types.Image
+	for _, img := range b.images {
+		if img.owner == account && img.region == region {
+			images = append(images, img.view(account))
+		}
+	}
+	sort.Slice(images, func(i, j int) bool { return *images[i].ImageId < *images[j].ImageId })
+	return images
+}
+
+// LaunchPermissions returns the accounts that were granted launch permission on an image.
+func (b *Backend) LaunchPermissions(id string) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	img, ok := b.images[id]
+	if !ok {
+		return nil
+	}
+	accounts := make([]string, 0, len(img.launchAccount))
+	for account := range img.launchAccount {
+		accounts = append(accounts, account)
+	}
+	sort.Strings(accounts)
+	return accounts
+}
+
+// OrganizationLaunchPermissions returns the organization and organizational unit ARNs that were
+// granted launch permission on an image.
+func (b *Backend) OrganizationLaunchPermissions(id string) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	img, ok := b.images[id]
+	if !ok {
+		return nil
+	}
+	arns := make([]string, 0, len(img.launchOrganization))
+	for arn := range img.launchOrganization {
+		arns = append(arns, arn)
+	}
+	sort.Strings(arns)
+	return arns
+}
+
+// SnapshotPermissions returns the accounts that were granted createVolumePermission on a snapshot.
+func (b *Backend) SnapshotPermissions(id string) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	snap, ok := b.snapshots[id]
+	if !ok {
+		return nil
+	}
+	accounts := make([]string, 0, len(snap.volumeAccount))
+	for account := range snap.volumeAccount {
+		accounts = append(accounts, account)
+	}
+	sort.Strings(accounts)
+	return accounts
+}
+
+// SnapshotTags returns the tags of a snapshot.
+func (b *Backend) SnapshotTags(id string) []ec2Types.Tag {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	snap, ok := b.snapshots[id]
+	if !ok {
+		return nil
+	}
+	return append([]ec2Types.Tag(nil), snap.tags...)
+}
+
+// SnapshotExists reports whether a snapshot is still present.
+func (b *Backend) SnapshotExists(id string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	_, ok := b.snapshots[id]
+	return ok
+}
+
+// Calls returns the recorded calls for an operation, or all calls when operation is empty.
+func (b *Backend) Calls(operation string) []Call {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var calls []Call
+	for _, call := range b.calls {
+		if operation == "" || call.Operation == operation {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// EC2 returns a client acting as account in region.
+func (b *Backend) EC2(account, region string) *Client {
+	return &Client{backend: b, loc: location{account: account, region: region}}
+}
+
+// STS returns an STS client reporting account as the caller identity. An empty account reports
+// the backend's DefaultAccount.
+func (b *Backend) STS(account string) *STSClient {
+	if account == "" {
+		account = b.DefaultAccount
+	}
+	return &STSClient{account: account}
+}
+
+// record logs the call and returns the error injected for it, if any.
+func (b *Backend) record(loc location, operation string, input interface{}) error {
+	b.calls = append(b.calls, Call{Account: loc.account, Region: loc.region, Operation: operation, Input: input})
+	return b.errors[injectKey{location: loc, operation: operation}]
+}
+
+func (b *Backend) newID(prefix string) string {
+	b.nextID++
+	return fmt.Sprintf("%s-%017x", prefix, b.nextID)
+}
+
+// visible reports whether account can see img through ownership, launch permission or publicity.
+func (img *image) visible(account string) bool {
+	return img.owner == account || img.launchAccount[account] || img.public
+}
+
+func (img *image) view(account string) ec2Types.Image {
+	out := img.Image
+	out.Tags = append([]ec2Types.Tag(nil), img.tags[account]...)
+	out.BlockDeviceMappings = append([]ec2Types.BlockDeviceMapping(nil), img.BlockDeviceMappings...)
+	return out
+}
+
+// STSClient is a fake STS client.
+type STSClient struct {
+	account string
+}
+
+// GetCallerIdentity returns the account the client was created for.
+func (c *STSClient) GetCallerIdentity(_ context.Context, _ *sts.GetCallerIdentityInput, _ ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error) {
+	return &sts.GetCallerIdentityOutput{
+		Account: awsv2.String(c.account),
+		Arn:     awsv2.String(fmt.Sprintf("arn:aws:iam::%s:user/ec2fake", c.account)),
+		UserId:  awsv2.String("ec2fake"),
+	}, nil
+}
+
+// AssumeRole returns temporary credentials for the role. The credentials are not checked by the
+// fake clients.
+func (c *STSClient) AssumeRole(_ context.Context, params *sts.AssumeRoleInput, _ ...func(*sts.Options)) (*sts.AssumeRoleOutput, error) {
+	return &sts.AssumeRoleOutput{
+		AssumedRoleUser: &stsTypes.AssumedRoleUser{
+			Arn:           params.RoleArn,
+			AssumedRoleId: awsv2.String("ec2fake:" + awsv2.ToString(params.RoleSessionName)),
+		},
+		Credentials: &stsTypes.Credentials{
+			AccessKeyId:     awsv2.String("ec2fake"),
+			SecretAccessKey: awsv2.String("ec2fake"),
+			SessionToken:    awsv2.String("ec2fake"),
+			Expiration:      awsv2.Time(time.Now().Add(time.Hour)),
+		},
+	}, nil
+}
+
+// Client is a fake EC2 client bound to one account and region.
+type Client struct {
+	backend *Backend
+	loc     location
+}
+
+// DescribeImages returns the images visible to the client's account in its region, honoring
+// ImageIds, Owners, NextToken and the filters used by aws-ami-manager. Without ImageIds, the
+// images are returned PageSize at a time.
+func (c *Client) DescribeImages(_ context.Context, params *ec2.DescribeImagesInput, _ ...func(*ec2.Options)) (*ec2.DescribeImagesOutput, error) {
+	b := c.backend
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.record(c.loc, "DescribeImages", params); err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	if len(params.ImageIds) > 0 {
+		ids = params.ImageIds
+	} else {
+		for id := range b.images {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+	}
+
+	output := &ec2.DescribeImagesOutput{}
+	for _, id := range ids {
+		img, ok := b.images[id]
+		if !ok || img.region != c.loc.region || !img.visible(c.loc.account) {
+			if len(params.ImageIds) > 0 {
+				return nil, apiError("InvalidAMIID.NotFound", fmt.Sprintf("The image id '[%s]' does not exist", id))
+			}
+			continue
+		}
+		if !matchesOwners(img, c.loc.account, params.Owners) || !matchesFilters(img.view(c.loc.account), params.Filters) {
+			continue
+		}
+		if len(params.ImageIds) == 0 {
+			// the next token is the ID of the last image of the previous page
+			if id <= awsv2.ToString(params.NextToken) {
+				continue
+			}
+			if b.PageSize > 0 && len(output.Images) == b.PageSize {
+				output.NextToken = output.Images[len(output.Images)-1].ImageId
+				break
+			}
+		}
+
+		if img.State == ec2Types.ImageStatePending {
+			switch {
+			case img.pendingPolls > 0:
+				img.pendingPolls--
+			case img.fails:
+				img.State = ec2Types.ImageStateFailed
+				img.StateReason = &ec2Types.StateReason{
+					Code:    awsv2.String("Client.InternalError"),
+					Message: awsv2.String("Client.InternalError: Internal error when copying the snapshot"),
+				}
+			default:
+				img.State = ec2Types.ImageStateAvailable
+			}
+		}
+		output.Images = append(output.Images, img.view(c.loc.account))
+	}
+	return output, nil
+}
+
+// CopyImage copies a visible image from the source region into the client's region. The copy
+// and its snapshots are owned by the client's account and start out `pending`.
+func (c *Client) CopyImage(_ context.Context, params *ec2.CopyImageInput, _ ...func(*ec2.Options)) (*ec2.CopyImageOutput, error) {
+	b := c.backend
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.record(c.loc, "CopyImage", params); err != nil {
+		return nil, err
+	}
+
+	source, ok := b.images[awsv2.ToString(params.SourceImageId)]
+	if !ok || source.region != awsv2.ToString(params.SourceRegion) || !source.visible(c.loc.account) {
+		return nil, apiError("InvalidAMIID.NotFound", fmt.Sprintf("The image id '[%s]' does not exist", awsv2.ToString(params.SourceImageId)))
+	}
+
+	// image names are unique per account and region, whatever the state of the image
+	for _, other := range b.images {
+		if other.owner == c.loc.account && other.region == c.loc.region && awsv2.ToString(other.Name) == awsv2.ToString(params.Name) {
+			return nil, apiError("InvalidAMIName.Duplicate", fmt.Sprintf("AMI name %s is already in use by AMI %s", awsv2.ToString(params.Name), awsv2.ToString(other.ImageId)))
+		}
+	}
+
+	imageTags, snapshotTags, err := copyTags(source, params)
+	if err != nil {
+		return nil, err
+	}
+
+	fails := b.FailedCopies > 0
+	if fails {
+		b.FailedCopies--
+	}
+
+	id := b.newID("ami")
+	img := &image{
+		Image: ec2Types.Image{
+			ImageId:           awsv2.String(id),
+			Name:              params.Name,
+			Description:       params.Description,
+			OwnerId:           awsv2.String(c.loc.account),
+			State:             ec2Types.ImageStatePending,
+			CreationDate:      awsv2.String(b.Now().UTC().Format("2006-01-02T15:04:05.000Z")),
+			SourceImageId:     params.SourceImageId,
+			SourceImageRegion: params.SourceRegion,
+		},
+		owner:              c.loc.account,
+		region:             c.loc.region,
+		pendingPolls:       b.PendingPolls,
+		fails:              fails,
+		tags:               map[string][]ec2Types.Tag{c.loc.account: imageTags},
+		launchAccount:      make(map[string]bool),
+		launchOrganization: make(map[string]bool),
+	}
+	for _, mapping := range source.BlockDeviceMappings {
+		if mapping.Ebs != nil && mapping.Ebs.SnapshotId != nil {
+			ebs := *mapping.Ebs
+			ebs.SnapshotId = awsv2.String(b.newID("snap"))
+			if awsv2.ToBool(params.Encrypted) {
+				ebs.Encrypted = awsv2.Bool(true)
+				// images list the ARN of the key, whichever way it was given
+				ebs.KmsKeyId = awsv2.String(b.keyArn(c.loc, awsv2.ToString(params.KmsKeyId)))
+			}
+			mapping.Ebs = &ebs
+
+			snap := newSnapshot(*ebs.SnapshotId, c.loc.account, c.loc.region)
+			snap.encrypted = awsv2.ToBool(ebs.Encrypted)
+			if snap.encrypted {
+				snap.kmsKeyID = awsv2.ToString(ebs.KmsKeyId)
+			}
+			snap.tags = snapshotTags
+			b.snapshots[snap.id] = snap
+		}
+		img.BlockDeviceMappings = append(img.BlockDeviceMappings, mapping)
+	}
+	b.images[id] = img
+
+	return &ec2.CopyImageOutput{ImageId: awsv2.String(id)}, nil
+}
+
+// copyTags returns the tags of a copy of source and of its snapshots: the user-defined tags of
+// the source with CopyImageTags, and the TagSpecifications of the request.
+func copyTags(source *image, params *ec2.CopyImageInput) (imageTags, snapshotTags []ec2Types.Tag, err error) {
+	if awsv2.ToBool(params.CopyImageTags) {
+		for _, tag := range source.tags[source.owner] {
+			if !strings.HasPrefix(awsv2.ToString(tag.Key), "aws:") {
+				imageTags = append(imageTags, tag)
+			}
+		}
+	}
+	for _, spec := range params.TagSpecifications {
+		for _, tag := range spec.Tags {
+			if strings.HasPrefix(awsv2.ToString(tag.Key), "aws:") {
+				return nil, nil, apiError("InvalidParameterValue", fmt.Sprintf("Tag keys starting with 'aws:' are reserved for internal use: %s", awsv2.ToString(tag.Key)))
+			}
+		}
+		switch spec.ResourceType {
+		case ec2Types.ResourceTypeImage:
+			imageTags = mergeTags(imageTags, spec.Tags)
+		case ec2Types.ResourceTypeSnapshot:
+			snapshotTags = mergeTags(snapshotTags, spec.Tags)
+		default:
+			return nil, nil, apiError("InvalidParameterValue", fmt.Sprintf("'%s' is not a valid taggable resource type for this operation.", spec.ResourceType))
+		}
+	}
+	return imageTags, snapshotTags, nil
+}
+
+// ModifyImageAttribute adds or removes account launch permissions on an owned image.
+func (c *Client) ModifyImageAttribute(_ context.Context, params *ec2.ModifyImageAttributeInput, _ ...func(*ec2.Options)) (*ec2.ModifyImageAttributeOutput, error) {
+	b := c.backend
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.record(c.loc, "ModifyImageAttribute", params); err != nil {
+		return nil, err
+	}
+
+	img, err := b.ownedImage(c.loc, awsv2.ToString(params.ImageId))
+	if err != nil {
+		return nil, err
+	}
+	if params.LaunchPermission != nil {
+		for _, permission := range params.LaunchPermission.Add {
+			if permission.UserId != nil {
+				img.launchAccount[*permission.UserId] = true
+			}
+			for _, arn := range []*string{permission.OrganizationArn, permission.OrganizationalUnitArn} {
+				if arn != nil {
+					img.launchOrganization[*arn] = true
+				}
+			}
+			if permission.Group == ec2Types.PermissionGroupAll {
+				img.public = true
+			}
+		}
+		for _, permission := range params.LaunchPermission.Remove {
+			if permission.UserId != nil {
+				delete(img.launchAccount, *permission.UserId)
+			}
+			for _, arn := range []*string{permission.OrganizationArn, permission.OrganizationalUnitArn} {
+				if arn != nil {
+					delete(img.launchOrganization, *arn)
+				}
+			}
+			if permission.Group == ec2Types.PermissionGroupAll {
+				img.public = false
+			}
+		}
+	}
+	return &ec2.ModifyImageAttributeOutput{}, nil
+}
+
+// DescribeImageAttribute returns the launch permissions of an owned image.
+func (c *Client) DescribeImageAttribute(_ context.Context, params *ec2.DescribeImageAttributeInput, _ ...func(*ec2.Options)) (*ec2.DescribeImageAttributeOutput, error) {
+	b := c.backend
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.record(c.loc, "DescribeImageAttribute", params); err != nil {
+		return nil, err
+	}
+
+	img, err := b.ownedImage(c.loc, awsv2.ToString(params.ImageId))
+	if err != nil {
+		return nil, err
+	}
+	if params.Attribute != ec2Types.ImageAttributeNameLaunchPermission {
+		return nil, apiError("InvalidParameterValue", fmt.Sprintf("Unsupported attribute %s", params.Attribute))
+	}
+
+	output := &ec2.DescribeImageAttributeOutput{ImageId: img.ImageId}
+	for _, account := range sortedSet(img.launchAccount) {
+		output.LaunchPermissions = append(output.LaunchPermissions, ec2Types.LaunchPermission{UserId: awsv2.String(account)})
+	}
+	for _, arn := range sortedSet(img.launchOrganization) {
+		permission := ec2Types.LaunchPermission{OrganizationArn: awsv2.String(arn)}
+		if strings.Contains(arn, ":ou/") {
+			permission = ec2Types.LaunchPermission{OrganizationalUnitArn: awsv2.String(arn)}
+		}
+		output.LaunchPermissions = append(output.LaunchPermissions, permission)
+	}
+	if img.public {
+		output.LaunchPermissions = append(output.LaunchPermissions, ec2Types.LaunchPermission{Group: ec2Types.PermissionGroupAll})
+	}
+	return output, nil
+}
+
+// CreateTags sets tags on images as seen by the client's account, and on snapshots it owns.
+func (c *Client) CreateTags(_ context.Context, params *ec2.CreateTagsInput, _ ...func(*ec2.Options)) (*ec2.CreateTagsOutput, error) {
+	b := c.backend
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.record(c.loc, "CreateTags", params); err != nil {
+		return nil, err
+	}
+
+	for _, resource := range params.Resources {
+		img, ok := b.images[resource]
+		if !ok {
+			snap, isSnapshot := b.snapshots[resource]
+			if !isSnapshot {
+				return nil, apiError("InvalidID", fmt.Sprintf("The ID '%s' is not valid", resource))
+			}
+			if snap.region != c.loc.region || snap.owner != c.loc.account {
+				return nil, apiError("InvalidSnapshot.NotFound", fmt.Sprintf("The snapshot '%s' does not exist.", resource))
+			}
+			snap.tags = mergeTags(snap.tags, params.Tags)
+			continue
+		}
+		if img.region != c.loc.region || !img.visible(c.loc.account) {
+			return nil, apiError("InvalidAMIID.NotFound", fmt.Sprintf("The image id '[%s]' does not exist", resource))
+		}
+		img.tags[c.loc.account] = mergeTags(img.tags[c.loc.account], params.Tags)
+	}
+	return &ec2.CreateTagsOutput{}, nil
+}
+
+// DeregisterImage removes an owned image. Its snapshots are left in place, like EC2 does.
+func (c *Client) DeregisterImage(_ context.Context, params *ec2.DeregisterImageInput, _ ...func(*ec2.Options)) (*ec2.DeregisterImageOutput, error) {
+	b := c.backend
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.record(c.loc, "DeregisterImage", params); err != nil {
+		return nil, err
+	}
+
+	img, err := b.ownedImage(c.loc, awsv2.ToString(params.ImageId))
+	if err != nil {
+		return nil, err
+	}
+	delete(b.images, *img.ImageId)
+	return &ec2.DeregisterImageOutput{}, nil
+}
+
+// DescribeRegions returns the regions that are enabled for the client's account.
+func (c *Client) DescribeRegions(_ context.Context, params *ec2.DescribeRegionsInput, _ ...func(*ec2.Options)) (*ec2.DescribeRegionsOutput, error) {
+	b := c.backend
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.record(c.loc, "DescribeRegions", params); err != nil {
+		return nil, err
+	}
+
+	output := &ec2.DescribeRegionsOutput{}
+	for _, region := range b.Regions {
+		status := "opt-in-not-required"
+		if b.disabled[location{account: c.loc.account, region: region}] {
+			if !awsv2.ToBool(params.AllRegions) {
+				continue
+			}
+			status = "not-opted-in"
+		}
+		output.Regions = append(output.Regions, ec2Types.Region{
+			RegionName:  awsv2.String(region),
+			Endpoint:    awsv2.String("ec2." + region + ".amazonaws.com"),
+			OptInStatus: awsv2.String(status),
+		})
+	}
+	return output, nil
+}
+
+// DeleteSnapshot deletes an owned snapshot that is no longer used by a registered image.
+func (c *Client) DeleteSnapshot(_ context.Context, params *ec2.DeleteSnapshotInput, _ ...func(*ec2.Options)) (*ec2.DeleteSnapshotOutput, error) {
+	b := c.backend
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.record(c.loc, "DeleteSnapshot", params); err != nil {
+		return nil, err
+	}
+
+	id := awsv2.ToString(params.SnapshotId)
+	snap, ok := b.snapshots[id]
+	if !ok || snap.region != c.loc.region || snap.owner != c.loc.account {
+		return nil, apiError("InvalidSnapshot.NotFound", fmt.Sprintf("The snapshot '%s' does not exist.", id))
+	}
+	for _, img := range b.images {
+		for _, used := range snapshotIDs(img.Image) {
+			if used == id {
+				return nil, apiError("InvalidSnapshot.InUse", fmt.Sprintf("The snapshot %s is currently in use by %s", id, *img.ImageId))
+			}
+		}
+	}
+	delete(b.snapshots, id)
+	return &ec2.DeleteSnapshotOutput{}, nil
+}
+
+// DescribeSnapshots returns the requested snapshots that the client's account owns or was granted
+// createVolumePermission on in its region.
+func (c *Client) DescribeSnapshots(_ context.Context, params *ec2.DescribeSnapshotsInput, _ ...func(*ec2.Options)) (*ec2.DescribeSnapshotsOutput, error) {
+	b := c.backend
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.record(c.loc, "DescribeSnapshots", params); err != nil {
+		return nil, err
+	}
+
+	output := &ec2.DescribeSnapshotsOutput{}
+	for _, id := range params.SnapshotIds {
+		snap, ok := b.snapshots[id]
+		if !ok || snap.region != c.loc.region || (snap.owner != c.loc.account && !snap.volumeAccount[c.loc.account]) {
+			return nil, apiError("InvalidSnapshot.NotFound", fmt.Sprintf("The snapshot '%s' does not exist.", id))
+		}
+		described := ec2Types.Snapshot{
+			SnapshotId: awsv2.String(snap.id),
+			OwnerId:    awsv2.String(snap.owner),
+			State:      ec2Types.SnapshotStateCompleted,
+			Encrypted:  awsv2.Bool(snap.encrypted),
+		}
+		if snap.kmsKeyID != "" {
+			described.KmsKeyId = awsv2.String(snap.kmsKeyID)
+		}
+		output.Snapshots = append(output.Snapshots, described)
+	}
+	return output, nil
+}
+
+// DescribeSnapshotAttribute returns the createVolumePermission of an owned snapshot.
+func (c *Client) DescribeSnapshotAttribute(_ context.Context, params *ec2.DescribeSnapshotAttributeInput, _ ...func(*ec2.Options)) (*ec2.DescribeSnapshotAttributeOutput, error) {
+	b := c.backend
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.record(c.loc, "DescribeSnapshotAttribute", params); err != nil {
+		return nil, err
+	}
+
+	id := awsv2.ToString(params.SnapshotId)
+	snap, ok := b.snapshots[id]
+	if !ok || snap.region != c.loc.region {
+		return nil, apiError("InvalidSnapshot.NotFound", fmt.Sprintf("The snapshot '%s' does not exist.", id))
+	}
+	if snap.owner != c.loc.account {
+		return nil, apiError("AuthFailure", fmt.Sprintf("Not authorized for snapshot:%s", id))
+	}
+
+	output := &ec2.DescribeSnapshotAttributeOutput{SnapshotId: awsv2.String(id)}
+	for _, account := range sortedSet(snap.volumeAccount) {
+		output.CreateVolumePermissions = append(output.CreateVolumePermissions, ec2Types.CreateVolumePermission{UserId: awsv2.String(account)})
+	}
+	return output, nil
+}
+
+// ModifySnapshotAttribute adds or removes account createVolumePermission on an owned snapshot.
+func (c *Client) ModifySnapshotAttribute(_ context.Context, params *ec2.ModifySnapshotAttributeInput, _ ...func(*ec2.Options)) (*ec2.ModifySnapshotAttributeOutput, error) {
+	b := c.backend
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.record(c.loc, "ModifySnapshotAttribute", params); err != nil {
+		return nil, err
+	}
+
+	id := awsv2.ToString(params.SnapshotId)
+	snap, ok := b.snapshots[id]
+	if !ok || snap.region != c.loc.region {
+		return nil, apiError("InvalidSnapshot.NotFound", fmt.Sprintf("The snapshot '%s' does not exist.", id))
+	}
+	if snap.owner != c.loc.account {
+		return nil, apiError("AuthFailure", fmt.Sprintf("Not authorized for snapshot:%s", id))
+	}
+	if params.CreateVolumePermission != nil {
+		for _, permission := range params.CreateVolumePermission.Add {
+			if permission.UserId != nil {
+				snap.volumeAccount[*permission.UserId] = true
+			}
+		}
+		for _, permission := range params.CreateVolumePermission.Remove {
+			if permission.UserId != nil {
+				delete(snap.volumeAccount, *permission.UserId)
+			}
+		}
+	}
+	return &ec2.ModifySnapshotAttributeOutput{}, nil
+}
+
+func (b *Backend) ownedImage(loc location, id string) (*image, error) {
+	img, ok := b.images[id]
+	if !ok || img.region != loc.region || !img.visible(loc.account) {
+		return nil, apiError("InvalidAMIID.NotFound", fmt.Sprintf("The image id '[%s]' does not exist", id))
+	}
+	if img.owner != loc.account {
+		return nil, apiError("AuthFailure", fmt.Sprintf("Not authorized for image:%s", id))
+	}
+	return img, nil
+}
+
+func newSnapshot(id, owner, region string) *snapshot {
+	return &snapshot{id: id, owner: owner, region: region, volumeAccount: make(map[string]bool)}
+}
+
+func matchesOwners(img *image, account string, owners []string) bool {
+	if len(owners) == 0 {
+		return true
+	}
+	for _, owner := range owners {
+		switch owner {
+		case "self":
+			if img.owner == account {
+				return true
+			}
+		default:
+			if img.owner == owner {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchesFilters(img ec2Types.Image, filters []ec2Types.Filter) bool {
+	for _, filter := range filters {
+		name := awsv2.ToString(filter.Name)
+		var candidates []string
+		switch {
+		case name == "tag-key":
+			for _, tag := range img.Tags {
+				candidates = append(candidates, awsv2.ToString(tag.Key))
+			}
+		case len(name) > 4 && name[:4] == "tag:":
+			for _, tag := range img.Tags {
+				if awsv2.ToString(tag.Key) == name[4:] {
+					candidates = append(candidates, awsv2.ToString(tag.Value))
+				}
+			}
+		case name == "name":
+			candidates = []string{awsv2.ToString(img.Name)}
+		case name == "image-id":
+			candidates = []string{awsv2.ToString(img.ImageId)}
+		case name == "owner-id":
+			candidates = []string{awsv2.ToString(img.OwnerId)}
+		case name == "state":
+			candidates = []string{string(img.State)}
+		case name == "source-image-id":
+			candidates = []string{awsv2.ToString(img.SourceImageId)}
+		case name == "source-image-region":
+			candidates = []string{awsv2.ToString(img.SourceImageRegion)}
+		default:
+			panic(fmt.Sprintf("ec2fake: unsupported DescribeImages filter %q", name))
+		}
+		if !anyMatch(candidates, filter.Values) {
+			return false
+		}
+	}
+	return true
+}
+
+// anyMatch reports whether one of the candidates matches one of the patterns, using the `*` and
+// `?` wildcards EC2 filters support.
+func anyMatch(candidates, patterns []string) bool {
+	for _, candidate := range candidates {
+		for _, pattern := range patterns {
+			if wildcardMatch(pattern, candidate) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wildcardMatch matches value against pattern over runes, backtracking only to the last `*`.
+func wildcardMatch(pattern, value string) bool {
+	p, v := []rune(pattern), []rune(value)
+	pi, vi := 0, 0
+	star, starValue := -1, 0
+	for vi < len(v) {
+		switch {
+		case pi < len(p) && p[pi] == '*':
+			star, starValue = pi, vi
+			pi++
+		case pi < len(p) && (p[pi] == '?' || p[pi] == v[vi]):
+			pi++
+			vi++
+		case star >= 0:
+			starValue++
+			pi, vi = star+1, starValue
+		default:
+			return false
+		}
+	}
+	for pi < len(p) && p[pi] == '*' {
+		pi++
+	}
+	return pi == len(p)
+}
+
+func mergeTags(existing, tags []ec2Types.Tag) []ec2Types.Tag {
+	merged := append([]ec2Types.Tag(nil), existing...)
+	for _, tag := range tags {
+		replaced := false
+		for i := range merged {
+			if awsv2.ToString(merged[i].Key) == awsv2.ToString(tag.Key) {
+				merged[i] = tag
+				replaced = true
+			}
+		}
+		if !replaced {
+			merged = append(merged, tag)
+		}
+	}
+	return merged
+}
+
+func sortedSet(set map[string]bool) []string {
+	values := make([]string, 0, len(set))
+	for value := range set {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	return values
+}
+
+func snapshotIDs(img ec2Types.Image) []string {
+	var ids []string
+	for _, mapping := range img.BlockDeviceMappings {
+		if mapping.Ebs != nil && mapping.Ebs.SnapshotId != nil {
+			ids = append(ids, *mapping.Ebs.SnapshotId)
+		}
+	}
+	return ids
+}
+
+func apiError(code, message string) error {
+	return &smithy.GenericAPIError{Code: code, Message: message}
+}
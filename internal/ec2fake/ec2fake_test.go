@@ -0,0 +1,225 @@
+package ec2fake
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func seed(b *Backend) {
+	b.AddImage("111111111111", "eu-west-1", ec2Types.Image{
+		ImageId: awsv2.String("ami-source"),
+		Name:    awsv2.String("golden"),
+		Tags:    []ec2Types.Tag{{Key: awsv2.String("Name"), Value: awsv2.String("golden")}},
+		BlockDeviceMappings: []ec2Types.BlockDeviceMapping{
+			{Ebs: &ec2Types.EbsBlockDevice{SnapshotId: awsv2.String("snap-source")}},
+		},
+	})
+}
+
+func TestCopyImageStaysPendingForConfiguredPolls(t *testing.T) {
+	b := New("111111111111")
+	b.PendingPolls = 2
+	seed(b)
+
+	client := b.EC2("111111111111", "us-east-1")
+	out, err := client.CopyImage(context.Background(), &ec2.CopyImageInput{
+		Name:          awsv2.String("golden"),
+		SourceImageId: awsv2.String("ami-source"),
+		SourceRegion:  awsv2.String("eu-west-1"),
+	})
+	if err != nil {
+		t.Fatalf("CopyImage() error = %v", err)
+	}
+
+	want := []ec2Types.ImageState{ec2Types.ImageStatePending, ec2Types.ImageStatePending, ec2Types.ImageStateAvailable}
+	for i, state := range want {
+		described, err := client.DescribeImages(context.Background(), &ec2.DescribeImagesInput{ImageIds: []string{*out.ImageId}})
+		if err != nil {
+			t.Fatalf("DescribeImages() error = %v", err)
+		}
+		if got := described.Images[0].State; got != state {
+			t.Errorf("poll %d: state = %s, want %s", i, got, state)
+		}
+	}
+
+	copied, _ := b.Image("111111111111", "us-east-1", *out.ImageId)
+	snapshot := *copied.BlockDeviceMappings[0].Ebs.SnapshotId
+	if snapshot == "snap-source" || !b.SnapshotExists(snapshot) {
+		t.Errorf("copy references snapshot %q, want a new snapshot", snapshot)
+	}
+}
+
+func TestFailedCopiesTurnFailed(t *testing.T) {
+	b := New("111111111111")
+	b.FailedCopies = 1
+	seed(b)
+
+	client := b.EC2("111111111111", "us-east-1")
+	var states []ec2Types.ImageState
+	for i := range 2 {
+		out, err := client.CopyImage(context.Background(), &ec2.CopyImageInput{
+			Name:          awsv2.String(fmt.Sprintf("golden-%d", i)),
+			SourceImageId: awsv2.String("ami-source"),
+			SourceRegion:  awsv2.String("eu-west-1"),
+		})
+		if err != nil {
+			t.Fatalf("CopyImage() error = %v", err)
+		}
+		described, err := client.DescribeImages(context.Background(), &ec2.DescribeImagesInput{ImageIds: []string{*out.ImageId}})
+		if err != nil {
+			t.Fatalf("DescribeImages() error = %v", err)
+		}
+		states = append(states, described.Images[0].State)
+	}
+
+	if states[0] != ec2Types.ImageStateFailed || states[1] != ec2Types.ImageStateAvailable {
+		t.Errorf("states = %v, want the first copy failed and the second available", states)
+	}
+}
+
+func TestCopyImageRejectsNamesInUse(t *testing.T) {
+	b := New("111111111111")
+	b.FailedCopies = 1
+	seed(b)
+
+	client := b.EC2("111111111111", "us-east-1")
+	input := &ec2.CopyImageInput{
+		Name:          awsv2.String("golden"),
+		SourceImageId: awsv2.String("ami-source"),
+		SourceRegion:  awsv2.String("eu-west-1"),
+	}
+	failed, err := client.CopyImage(context.Background(), input)
+	if err != nil {
+		t.Fatalf("CopyImage() error = %v", err)
+	}
+	// the name stays taken by the failed copy until it is deregistered
+	if _, err := client.CopyImage(context.Background(), input); err == nil {
+		t.Fatal("CopyImage() with the name of a failed copy error = nil, want InvalidAMIName.Duplicate")
+	}
+	if _, err := client.DeregisterImage(context.Background(), &ec2.DeregisterImageInput{ImageId: failed.ImageId}); err != nil {
+		t.Fatalf("DeregisterImage() error = %v", err)
+	}
+	if _, err := client.CopyImage(context.Background(), input); err != nil {
+		t.Errorf("CopyImage() after deregistering the failed copy error = %v", err)
+	}
+	// other regions have their own names
+	if _, err := b.EC2("111111111111", "us-west-2").CopyImage(context.Background(), input); err != nil {
+		t.Errorf("CopyImage() in another region error = %v", err)
+	}
+}
+
+func TestTagsAreAccountLocal(t *testing.T) {
+	b := New("111111111111")
+	seed(b)
+
+	owner := b.EC2("111111111111", "eu-west-1")
+	consumer := b.EC2("222222222222", "eu-west-1")
+
+	_, err := consumer.CreateTags(context.Background(), &ec2.CreateTagsInput{Resources: []string{"ami-source"}})
+	if err == nil {
+		t.Fatal("CreateTags() on an image that is not shared succeeded")
+	}
+
+	_, err = owner.ModifyImageAttribute(context.Background(), &ec2.ModifyImageAttributeInput{
+		ImageId: awsv2.String("ami-source"),
+		LaunchPermission: &ec2Types.LaunchPermissionModifications{
+			Add: []ec2Types.LaunchPermission{{UserId: awsv2.String("222222222222")}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("ModifyImageAttribute() error = %v", err)
+	}
+
+	_, err = consumer.CreateTags(context.Background(), &ec2.CreateTagsInput{
+		Resources: []string{"ami-source"},
+		Tags:      []ec2Types.Tag{{Key: awsv2.String("Team"), Value: awsv2.String("consumer")}},
+	})
+	if err != nil {
+		t.Fatalf("CreateTags() error = %v", err)
+	}
+
+	ownerView, _ := b.Image("111111111111", "eu-west-1", "ami-source")
+	consumerView, _ := b.Image("222222222222", "eu-west-1", "ami-source")
+	if len(ownerView.Tags) != 1 || len(consumerView.Tags) != 1 || *consumerView.Tags[0].Key != "Team" {
+		t.Errorf("owner tags = %v, consumer tags = %v", ownerView.Tags, consumerView.Tags)
+	}
+}
+
+func TestDescribeImagesFilters(t *testing.T) {
+	b := New("111111111111")
+	seed(b)
+	b.AddImage("111111111111", "eu-west-1", ec2Types.Image{
+		ImageId: awsv2.String("ami-other"),
+		Tags:    []ec2Types.Tag{{Key: awsv2.String("Name"), Value: awsv2.String("öther")}},
+	})
+
+	// ? matches the multi-byte ö as a single character
+	for pattern, want := range map[string]string{"gold*": "ami-source", "?th*r": "ami-other"} {
+		out, err := b.EC2("111111111111", "eu-west-1").DescribeImages(context.Background(), &ec2.DescribeImagesInput{
+			Owners:  []string{"self"},
+			Filters: []ec2Types.Filter{{Name: awsv2.String("tag:Name"), Values: []string{pattern}}},
+		})
+		if err != nil {
+			t.Fatalf("DescribeImages() error = %v", err)
+		}
+		if len(out.Images) != 1 || *out.Images[0].ImageId != want {
+			t.Errorf("DescribeImages(tag:Name=%s) = %v, want only %s", pattern, out.Images, want)
+		}
+	}
+}
+
+func TestDescribeImagesPaginates(t *testing.T) {
+	b := New("111111111111")
+	b.PageSize = 2
+	for i := 1; i <= 3; i++ {
+		b.AddImage("111111111111", "eu-west-1", ec2Types.Image{ImageId: awsv2.String(fmt.Sprintf("ami-%d", i)), Name: awsv2.String(fmt.Sprintf("golden-%d", i))})
+	}
+
+	var pages [][]string
+	paginator := ec2.NewDescribeImagesPaginator(b.EC2("111111111111", "eu-west-1"), &ec2.DescribeImagesInput{Owners: []string{"self"}})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.Background())
+		if err != nil {
+			t.Fatalf("NextPage() error = %v", err)
+		}
+		var ids []string
+		for _, image := range page.Images {
+			ids = append(ids, *image.ImageId)
+		}
+		pages = append(pages, ids)
+	}
+	if fmt.Sprint(pages) != "[[ami-1 ami-2] [ami-3]]" {
+		t.Errorf("pages = %v, want [[ami-1 ami-2] [ami-3]]", pages)
+	}
+}
+
+func TestLaunchTemplateVersions(t *testing.T) {
+	b := New("111111111111")
+	b.AddLaunchTemplate("111111111111", "eu-west-1", "web", "ami-v1", "ami-v2", "ami-v3")
+	client := b.EC2("111111111111", "eu-west-1")
+
+	out, err := client.DescribeLaunchTemplateVersions(context.Background(), &ec2.DescribeLaunchTemplateVersionsInput{Versions: []string{"$Default", "$Latest"}})
+	if err != nil {
+		t.Fatalf("DescribeLaunchTemplateVersions() error = %v", err)
+	}
+	var images []string
+	for _, version := range out.LaunchTemplateVersions {
+		images = append(images, *version.LaunchTemplateData.ImageId)
+	}
+	if len(images) != 2 || images[0] != "ami-v1" || images[1] != "ami-v3" {
+		t.Errorf("images of $Default and $Latest = %v, want [ami-v1 ami-v3]", images)
+	}
+
+	out, err = client.DescribeLaunchTemplateVersions(context.Background(), &ec2.DescribeLaunchTemplateVersionsInput{LaunchTemplateName: awsv2.String("web"), Versions: []string{"2"}})
+	if err != nil || len(out.LaunchTemplateVersions) != 1 || *out.LaunchTemplateVersions[0].LaunchTemplateData.ImageId != "ami-v2" {
+		t.Errorf("version 2 = %+v, %v, want ami-v2", out, err)
+	}
+	if _, err := client.DescribeLaunchTemplateVersions(context.Background(), &ec2.DescribeLaunchTemplateVersionsInput{LaunchTemplateName: awsv2.String("web"), Versions: []string{"4"}}); err == nil {
+		t.Error("DescribeLaunchTemplateVersions() of a missing version error = nil, want an error")
+	}
+}
@@ -0,0 +1,218 @@
+package ec2fake
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmsTypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+)
+
+// defaultEBSKeyAlias is the alias of the AWS managed key EBS uses when no key is given.
+const defaultEBSKeyAlias = "alias/aws/ebs"
+
+type key struct {
+	arn        string
+	id         string
+	alias      string
+	owner      string
+	region     string
+	awsManaged bool
+	// users are the accounts the key policy lets use the key
+	users  map[string]bool
+	grants []grant
+}
+
+type grant struct {
+	id      string
+	name    string
+	grantee string
+}
+
+// usableBy reports whether account can use the key through ownership, the key policy or a grant.
+func (k *key) usableBy(account string) bool {
+	if k.owner == account || k.users[account] {
+		return true
+	}
+	for _, g := range k.grants {
+		if g.grantee == account {
+			return true
+		}
+	}
+	return false
+}
+
+// AddKey creates a customer managed key with alias (e.g. alias/ami) owned by account in region
+// and returns its ARN.
+func (b *Backend) AddKey(account, region, alias string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.newKey(location{account: account, region: region}, alias, false).arn
+}
+
+// AllowKeyUse adds account to the key policy of the key with the given ARN.
+func (b *Backend) AllowKeyUse(arn, account string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if k, ok := b.keys[arn]; ok {
+		k.users[account] = true
+	}
+}
+
+// KeyGrants returns the accounts that were granted access to the key with the given ARN.
+func (b *Backend) KeyGrants(arn string) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	k, ok := b.keys[arn]
+	if !ok {
+		return nil
+	}
+	accounts := make([]string, 0, len(k.grants))
+	for _, g := range k.grants {
+		accounts = append(accounts, g.grantee)
+	}
+	sort.Strings(accounts)
+	return accounts
+}
+
+// KMS returns a KMS client acting as account in region.
+func (b *Backend) KMS(account, region string) *KMSClient {
+	return &KMSClient{backend: b, loc: location{account: account, region: region}}
+}
+
+func (b *Backend) newKey(loc location, alias string, awsManaged bool) *key {
+	b.nextID++
+	id := fmt.Sprintf("%08x-0000-4000-8000-%012x", b.nextID, b.nextID)
+	k := &key{
+		arn:        fmt.Sprintf("arn:aws:kms:%s:%s:key/%s", loc.region, loc.account, id),
+		id:         id,
+		alias:      alias,
+		owner:      loc.account,
+		region:     loc.region,
+		awsManaged: awsManaged,
+		users:      make(map[string]bool),
+	}
+	b.keys[k.arn] = k
+	return k
+}
+
+// resolveKey finds a key by ARN, or by key ID or alias in the account and region of loc. The AWS
+// managed EBS key is created on first use.
+func (b *Backend) resolveKey(loc location, keyID string) *key {
+	if k, ok := b.keys[keyID]; ok {
+		return k
+	}
+	if strings.HasPrefix(keyID, "arn:") {
+		// alias ARNs: arn:aws:kms:<region>:<account>:alias/<name>
+		parts := strings.SplitN(keyID, ":", 6)
+		if len(parts) != 6 || !strings.HasPrefix(parts[5], "alias/") {
+			return nil
+		}
+		loc, keyID = location{account: parts[4], region: parts[3]}, parts[5]
+	}
+	for _, k := range b.keys {
+		if k.owner == loc.account && k.region == loc.region && (k.id == keyID || k.alias == keyID) {
+			return k
+		}
+	}
+	if keyID == defaultEBSKeyAlias {
+		return b.newKey(loc, defaultEBSKeyAlias, true)
+	}
+	return nil
+}
+
+// keyArn returns the ARN of the key EBS encrypts with for keyID, which may be empty for the
+// default key. Unknown keys are returned unchanged.
+func (b *Backend) keyArn(loc location, keyID string) string {
+	if keyID == "" {
+		keyID = defaultEBSKeyAlias
+	}
+	if k := b.resolveKey(loc, keyID); k != nil {
+		return k.arn
+	}
+	return keyID
+}
+
+// KMSClient is a fake KMS client bound to one account and region.
+type KMSClient struct {
+	backend *Backend
+	loc     location
+}
+
+// DescribeKey describes a key the client's account can use. Keys of other accounts have to be
+// referenced by ARN.
+func (c *KMSClient) DescribeKey(_ context.Context, params *kms.DescribeKeyInput, _ ...func(*kms.Options)) (*kms.DescribeKeyOutput, error) {
+	b := c.backend
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.record(c.loc, "DescribeKey", params); err != nil {
+		return nil, err
+	}
+
+	k, err := b.usableKey(c.loc, awsv2.ToString(params.KeyId))
+	if err != nil {
+		return nil, err
+	}
+	manager := kmsTypes.KeyManagerTypeCustomer
+	if k.awsManaged {
+		manager = kmsTypes.KeyManagerTypeAws
+	}
+	return &kms.DescribeKeyOutput{KeyMetadata: &kmsTypes.KeyMetadata{
+		Arn:          awsv2.String(k.arn),
+		KeyId:        awsv2.String(k.id),
+		AWSAccountId: awsv2.String(k.owner),
+		KeyManager:   manager,
+		KeyState:     kmsTypes.KeyStateEnabled,
+		Enabled:      true,
+	}}, nil
+}
+
+// CreateGrant grants the account of an arn:aws:iam::<account>:root grantee access to a customer
+// managed key. Creating a grant with the same name for the same grantee returns the existing one.
+func (c *KMSClient) CreateGrant(_ context.Context, params *kms.CreateGrantInput, _ ...func(*kms.Options)) (*kms.CreateGrantOutput, error) {
+	b := c.backend
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.record(c.loc, "CreateGrant", params); err != nil {
+		return nil, err
+	}
+
+	k, err := b.usableKey(c.loc, awsv2.ToString(params.KeyId))
+	if err != nil {
+		return nil, err
+	}
+	if k.awsManaged {
+		return nil, apiError("AccessDeniedException", fmt.Sprintf("Grants cannot be created on AWS managed key %s", k.arn))
+	}
+	parts := strings.Split(awsv2.ToString(params.GranteePrincipal), ":")
+	if len(parts) != 6 || parts[5] != "root" {
+		return nil, apiError("InvalidArnException", fmt.Sprintf("Unsupported grantee principal %s", awsv2.ToString(params.GranteePrincipal)))
+	}
+	grantee, name := parts[4], awsv2.ToString(params.Name)
+
+	for _, g := range k.grants {
+		if g.grantee == grantee && g.name == name {
+			return &kms.CreateGrantOutput{GrantId: awsv2.String(g.id)}, nil
+		}
+	}
+	g := grant{id: b.newID("grant"), name: name, grantee: grantee}
+	k.grants = append(k.grants, g)
+	return &kms.CreateGrantOutput{GrantId: awsv2.String(g.id), GrantToken: awsv2.String("token-" + g.id)}, nil
+}
+
+func (b *Backend) usableKey(loc location, keyID string) (*key, error) {
+	k := b.resolveKey(loc, keyID)
+	if k == nil || k.region != loc.region {
+		return nil, apiError("NotFoundException", fmt.Sprintf("Key '%s' does not exist", keyID))
+	}
+	if !k.usableBy(loc.account) {
+		return nil, apiError("AccessDeniedException", fmt.Sprintf("User: arn:aws:iam::%s:root is not authorized to perform: kms:DescribeKey on resource: %s", loc.account, k.arn))
+	}
+	return k, nil
+}
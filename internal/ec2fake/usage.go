@@ -0,0 +1,213 @@
+package ec2fake
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	asTypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// resources are the things that launch instances from AMIs in an account and region.
+type resources struct {
+	instances            []ec2Types.Instance
+	launchTemplates      []*launchTemplate
+	launchConfigurations []asTypes.LaunchConfiguration
+	groups               []asTypes.AutoScalingGroup
+}
+
+type launchTemplate struct {
+	id   string
+	name string
+	// images holds the AMI of every version, starting at version 1
+	images         []string
+	defaultVersion int64
+}
+
+func (t *launchTemplate) version(number int64) ec2Types.LaunchTemplateVersion {
+	return ec2Types.LaunchTemplateVersion{
+		LaunchTemplateId:   awsv2.String(t.id),
+		LaunchTemplateName: awsv2.String(t.name),
+		VersionNumber:      awsv2.Int64(number),
+		DefaultVersion:     awsv2.Bool(number == t.defaultVersion),
+		LaunchTemplateData: &ec2Types.ResponseLaunchTemplateData{ImageId: awsv2.String(t.images[number-1])},
+	}
+}
+
+// resolve returns the version number that version, e.g. 3, $Default or $Latest, refers to.
+func (t *launchTemplate) resolve(version string) (int64, bool) {
+	switch version {
+	case "$Default":
+		return t.defaultVersion, true
+	case "$Latest":
+		return int64(len(t.images)), true
+	}
+	number, err := strconv.ParseInt(version, 10, 64)
+	return number, err == nil && number >= 1 && number <= int64(len(t.images))
+}
+
+func (b *Backend) resourcesAt(loc location) *resources {
+	if b.resources == nil {
+		b.resources = make(map[location]*resources)
+	}
+	if b.resources[loc] == nil {
+		b.resources[loc] = &resources{}
+	}
+	return b.resources[loc]
+}
+
+// AddInstance seeds an instance of account in region launched from imageID, and returns its ID.
+func (b *Backend) AddInstance(account, region, imageID string, state ec2Types.InstanceStateName) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := b.newID("i")
+	r := b.resourcesAt(location{account: account, region: region})
+	r.instances = append(r.instances, ec2Types.Instance{
+		InstanceId: awsv2.String(id),
+		ImageId:    awsv2.String(imageID),
+		State:      &ec2Types.InstanceState{Name: state},
+	})
+	return id
+}
+
+// AddLaunchTemplate seeds a launch template of account in region with one version per image,
+// starting at version 1, which is the default version. It returns the ID of the template.
+func (b *Backend) AddLaunchTemplate(account, region, name string, imageIDs ...string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := b.newID("lt")
+	r := b.resourcesAt(location{account: account, region: region})
+	r.launchTemplates = append(r.launchTemplates, &launchTemplate{id: id, name: name, images: imageIDs, defaultVersion: 1})
+	return id
+}
+
+// AddLaunchConfiguration seeds a launch configuration of account in region.
+func (b *Backend) AddLaunchConfiguration(account, region, name, imageID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	r := b.resourcesAt(location{account: account, region: region})
+	r.launchConfigurations = append(r.launchConfigurations, asTypes.LaunchConfiguration{
+		LaunchConfigurationName: awsv2.String(name),
+		ImageId:                 awsv2.String(imageID),
+	})
+}
+
+// AddAutoScalingGroup seeds an Auto Scaling group of account in region.
+func (b *Backend) AddAutoScalingGroup(account, region string, group asTypes.AutoScalingGroup) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	r := b.resourcesAt(location{account: account, region: region})
+	r.groups = append(r.groups, group)
+}
+
+// DescribeInstances returns the instances of the client's account in its region, honoring the
+// instance-state-name filter.
+func (c *Client) DescribeInstances(_ context.Context, params *ec2.DescribeInstancesInput, _ ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+	b := c.backend
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.record(c.loc, "DescribeInstances", params); err != nil {
+		return nil, err
+	}
+
+	var instances []ec2Types.Instance
+	for _, instance := range b.resourcesAt(c.loc).instances {
+		matches := true
+		for _, filter := range params.Filters {
+			if awsv2.ToString(filter.Name) == "instance-state-name" {
+				matches = matches && slices.Contains(filter.Values, string(instance.State.Name))
+			}
+		}
+		if matches {
+			instances = append(instances, instance)
+		}
+	}
+	output := &ec2.DescribeInstancesOutput{}
+	if len(instances) > 0 {
+		output.Reservations = []ec2Types.Reservation{{Instances: instances}}
+	}
+	return output, nil
+}
+
+// DescribeLaunchTemplateVersions returns the requested versions of one launch template, or the
+// $Default and $Latest versions of every launch template when no template is given.
+func (c *Client) DescribeLaunchTemplateVersions(_ context.Context, params *ec2.DescribeLaunchTemplateVersionsInput, _ ...func(*ec2.Options)) (*ec2.DescribeLaunchTemplateVersionsOutput, error) {
+	b := c.backend
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.record(c.loc, "DescribeLaunchTemplateVersions", params); err != nil {
+		return nil, err
+	}
+
+	templates := b.resourcesAt(c.loc).launchTemplates
+	if params.LaunchTemplateId != nil || params.LaunchTemplateName != nil {
+		index := slices.IndexFunc(templates, func(t *launchTemplate) bool {
+			return t.id == awsv2.ToString(params.LaunchTemplateId) || t.name == awsv2.ToString(params.LaunchTemplateName)
+		})
+		if index < 0 {
+			return nil, apiError("InvalidLaunchTemplateId.NotFound", "The specified launch template does not exist")
+		}
+		templates = templates[index : index+1]
+	}
+
+	output := &ec2.DescribeLaunchTemplateVersionsOutput{}
+	for _, template := range templates {
+		var numbers []int64
+		for _, version := range params.Versions {
+			number, ok := template.resolve(version)
+			if !ok {
+				return nil, apiError("InvalidLaunchTemplateId.VersionNotFound", fmt.Sprintf("Could not find launch template version %s", version))
+			}
+			if !slices.Contains(numbers, number) {
+				numbers = append(numbers, number)
+			}
+		}
+		for _, number := range numbers {
+			output.LaunchTemplateVersions = append(output.LaunchTemplateVersions, template.version(number))
+		}
+	}
+	return output, nil
+}
+
+// AutoScaling returns an Auto Scaling client acting as account in region.
+func (b *Backend) AutoScaling(account, region string) *AutoScalingClient {
+	return &AutoScalingClient{backend: b, loc: location{account: account, region: region}}
+}
+
+// AutoScalingClient is a fake Auto Scaling client bound to one account and region.
+type AutoScalingClient struct {
+	backend *Backend
+	loc     location
+}
+
+// DescribeAutoScalingGroups returns every Auto Scaling group of the client's account in its region.
+func (c *AutoScalingClient) DescribeAutoScalingGroups(_ context.Context, params *autoscaling.DescribeAutoScalingGroupsInput, _ ...func(*autoscaling.Options)) (*autoscaling.DescribeAutoScalingGroupsOutput, error) {
+	b := c.backend
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.record(c.loc, "DescribeAutoScalingGroups", params); err != nil {
+		return nil, err
+	}
+	return &autoscaling.DescribeAutoScalingGroupsOutput{AutoScalingGroups: slices.Clone(b.resourcesAt(c.loc).groups)}, nil
+}
+
+// DescribeLaunchConfigurations returns every launch configuration of the client's account in its
+// region.
+func (c *AutoScalingClient) DescribeLaunchConfigurations(_ context.Context, params *autoscaling.DescribeLaunchConfigurationsInput, _ ...func(*autoscaling.Options)) (*autoscaling.DescribeLaunchConfigurationsOutput, error) {
+	b := c.backend
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.record(c.loc, "DescribeLaunchConfigurations", params); err != nil {
+		return nil, err
+	}
+	return &autoscaling.DescribeLaunchConfigurationsOutput{LaunchConfigurations: slices.Clone(b.resourcesAt(c.loc).launchConfigurations)}, nil
+}